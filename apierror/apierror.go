@@ -0,0 +1,79 @@
+// Package apierror defines the error envelope every endpoint returns:
+//
+//	{"error": {"code": "argument_not_found", "message": "Argument not found", "request_id": "..."}}
+//
+// Clients should branch on code, which is stable, never on message.
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Error struct {
+	Status    int    `json:"-"`
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// cause is logged with the request but never sent to the client
+	cause error
+}
+
+// New builds an error whose HTTP status comes from the catalog entry for code
+func New(code Code, message string) *Error {
+	return &Error{
+		Status:  code.Status(),
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WithDetails attaches client-facing context such as the offending field
+func (e *Error) WithDetails(details any) *Error {
+	e.Details = details
+	return e
+}
+
+// Wrap records the underlying error for logs
+func (e *Error) Wrap(err error) *Error {
+	e.cause = err
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.cause.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Abort hands err to the ErrorHandler middleware and stops the handler chain.
+// Errors that aren't *Error are reported to the client as internal errors.
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// From converts any error into the envelope sent to clients
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		copied := *apiErr
+		return &copied
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return New(UploadTooLarge, "Upload too large").Wrap(err)
+	}
+
+	return New(Internal, "Internal server error").Wrap(err)
+}
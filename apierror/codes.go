@@ -0,0 +1,102 @@
+package apierror
+
+import "net/http"
+
+// Code is a stable, machine-readable error identifier
+type Code string
+
+const (
+	InvalidRequest        Code = "invalid_request"
+	InvalidCursor         Code = "invalid_cursor"
+	Unauthorized          Code = "unauthorized"
+	InvalidToken          Code = "invalid_token"
+	InvalidCredentials    Code = "invalid_credentials"
+	InvalidAppleToken     Code = "invalid_apple_token"
+	InsufficientCredits   Code = "insufficient_credits"
+	InvalidSignature      Code = "invalid_signature"
+	NotFound              Code = "not_found"
+	UserNotFound          Code = "user_not_found"
+	ArgumentNotFound      Code = "argument_not_found"
+	RelationshipNotFound  Code = "relationship_not_found"
+	DeviceNotFound        Code = "device_not_found"
+	UploadNotFound        Code = "upload_not_found"
+	AudioNotFound         Code = "audio_not_found"
+	EmailTaken            Code = "email_taken"
+	UploadTooLarge        Code = "upload_too_large"
+	UploadOffsetMismatch  Code = "upload_offset_mismatch"
+	UploadIncomplete      Code = "upload_incomplete"
+	UploadLocked          Code = "upload_locked"
+	UnsupportedTusVersion Code = "unsupported_tus_version"
+	UnsupportedMedia      Code = "unsupported_media"
+	NoAudioStream         Code = "no_audio_stream"
+	RecordingTooShort     Code = "recording_too_short"
+	RecordingTooLong      Code = "recording_too_long"
+	RateLimited           Code = "rate_limited"
+	InvalidWebhook        Code = "invalid_webhook"
+	MediaProcessingFailed Code = "media_processing_failed"
+	TranscriptionFailed   Code = "transcription_failed"
+	JudgmentFailed        Code = "judgment_failed"
+	Internal              Code = "internal_error"
+)
+
+// Entry documents one code for clients
+type Entry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+var catalog = []Entry{
+	{InvalidRequest, http.StatusBadRequest, "The request body, form or query is malformed or fails validation. details.field names the input when known."},
+	{InvalidCursor, http.StatusBadRequest, "The pagination cursor is malformed or from a different query. Restart from the first page."},
+	{Unauthorized, http.StatusUnauthorized, "No bearer token was sent."},
+	{InvalidToken, http.StatusUnauthorized, "The bearer token is invalid or expired. Log in again."},
+	{InvalidCredentials, http.StatusUnauthorized, "The email or password is wrong."},
+	{InvalidAppleToken, http.StatusUnauthorized, "The Sign in with Apple identity token could not be verified."},
+	{InsufficientCredits, http.StatusForbidden, "The user has no credits left to create an argument."},
+	{InvalidSignature, http.StatusForbidden, "The signed media URL is malformed or has expired. List the argument's media again for a fresh one."},
+	{NotFound, http.StatusNotFound, "No such endpoint."},
+	{UserNotFound, http.StatusNotFound, "The user does not exist."},
+	{ArgumentNotFound, http.StatusNotFound, "The argument does not exist or belongs to another user."},
+	{RelationshipNotFound, http.StatusNotFound, "The relationship does not exist or belongs to another user."},
+	{DeviceNotFound, http.StatusNotFound, "The device token is not registered to this user."},
+	{UploadNotFound, http.StatusNotFound, "The resumable upload does not exist, has expired or belongs to another user."},
+	{AudioNotFound, http.StatusNotFound, "The argument has no stored audio: it was made from screenshots or its media is past the retention period."},
+	{EmailTaken, http.StatusConflict, "An account with this email already exists."},
+	{UploadTooLarge, http.StatusRequestEntityTooLarge, "The upload exceeds the size or file count limits."},
+	{UploadOffsetMismatch, http.StatusConflict, "Upload-Offset does not match the bytes received so far. Send HEAD to find the offset to resume from."},
+	{UploadIncomplete, http.StatusConflict, "The resumable upload has not received all of its bytes yet. details.offset and details.length show its progress."},
+	{UploadLocked, http.StatusLocked, "Another request is still writing to or creating an argument from this upload. Retry once it finishes."},
+	{UnsupportedTusVersion, http.StatusPreconditionFailed, "Tus-Resumable is missing or names a protocol version other than 1.0.0."},
+	{UnsupportedMedia, http.StatusUnsupportedMediaType, "The upload is not an audio or video file in a supported container and codec."},
+	{NoAudioStream, http.StatusUnprocessableEntity, "The upload has no audio track."},
+	{RecordingTooShort, http.StatusUnprocessableEntity, "The recording is shorter than the minimum length."},
+	{RecordingTooLong, http.StatusUnprocessableEntity, "The recording is longer than the account's tier allows. details.max_duration_seconds has the limit."},
+	{RateLimited, http.StatusTooManyRequests, "Too many requests. Retry after the number of seconds in the Retry-After header."},
+	{InvalidWebhook, http.StatusBadRequest, "The webhook payload could not be processed."},
+	{MediaProcessingFailed, http.StatusInternalServerError, "The uploaded media could not be converted."},
+	{TranscriptionFailed, http.StatusInternalServerError, "The recording could not be transcribed."},
+	{JudgmentFailed, http.StatusInternalServerError, "The judge could not produce a verdict."},
+	{Internal, http.StatusInternalServerError, "Unexpected server error. Safe to retry."},
+}
+
+var statuses = func() map[Code]int {
+	m := make(map[Code]int, len(catalog))
+	for _, entry := range catalog {
+		m[entry.Code] = entry.Status
+	}
+	return m
+}()
+
+// Status is the HTTP status sent with code
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Catalog lists every code the API can return
+func Catalog() []Entry {
+	return append([]Entry(nil), catalog...)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/database"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [n]    revert the last n migrations (default 1)
+  status      list migrations and when they were applied`
+
+// runMigrateCommand implements `server migrate ...`
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+
+	if config.App.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL is required")
+	}
+
+	ctx := context.Background()
+	database.Connect(config.App.DatabaseURL)
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal("down expects a positive number of steps")
+			}
+			steps = n
+		}
+
+		reverted, err := database.MigrateDown(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+
+	case "status":
+		states, err := database.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatal("Failed to read migration status: ", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
+
+const configUsage = `usage: server config <command>
+
+commands:
+  check       validate the configuration and print it with secrets masked`
+
+// runConfigCommand implements `server config ...`
+func runConfigCommand(args []string) {
+	if len(args) != 1 || args[0] != "check" {
+		fmt.Println(configUsage)
+		os.Exit(2)
+	}
+
+	redacted, _ := json.MarshalIndent(config.App.Redacted(), "", "  ")
+	fmt.Println(string(redacted))
+
+	if err := config.App.Validate(); err != nil {
+		fmt.Println("\nconfiguration is invalid:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\nconfiguration is valid")
+}
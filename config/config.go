@@ -0,0 +1,479 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Duration is a time.Duration that reads as "30s" or "720h" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Config struct {
+	Port     string `json:"port"`
+	LogLevel string `json:"log_level"`
+
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	DatabaseURL string `json:"database_url"`
+
+	JWTSecret string   `json:"jwt_secret"`
+	TokenTTL  Duration `json:"token_ttl"`
+
+	OpenAIAPIKey       string `json:"openai_api_key"`
+	OpenAIBaseURL      string `json:"openai_base_url"`
+	JudgmentModel      string `json:"judgment_model"`
+	ScreenshotModel    string `json:"screenshot_model"`
+	TranscriptionModel string `json:"transcription_model"`
+
+	// TranscriptionBackend is openai, whisper_cpp, faster_whisper or fixture
+	TranscriptionBackend string `json:"transcription_backend"`
+	WhisperCommand       string `json:"whisper_command"`
+	WhisperModel         string `json:"whisper_model"`
+	WhisperThreads       int    `json:"whisper_threads"`
+	// WhisperTimeout bounds one local model run over a chunk
+	WhisperTimeout          Duration `json:"whisper_timeout"`
+	TranscriptionFixtureDir string   `json:"transcription_fixture_dir"`
+
+	TranscriptionChunkDuration Duration `json:"transcription_chunk_duration"`
+	TranscriptionChunkOverlap  Duration `json:"transcription_chunk_overlap"`
+	TranscriptionConcurrency   int      `json:"transcription_concurrency"`
+
+	MaxAudioUploadBytes int64    `json:"max_audio_upload_bytes"`
+	MaxScreenshotBytes  int64    `json:"max_screenshot_bytes"`
+	UploadDir           string   `json:"upload_dir"`
+	FFmpegTimeout       Duration `json:"ffmpeg_timeout"`
+
+	// AudioPreset is the filter chain run on recordings before
+	// transcription: none, light, standard or aggressive
+	AudioPreset string `json:"audio_preset"`
+	// Diarization is channels to transcribe each channel of a stereo call
+	// recording as its own speaker, or none
+	Diarization string `json:"diarization"`
+
+	// Resumable uploads are streamed to disk, so they may be larger than
+	// multipart ones
+	MaxResumableUploadBytes int64    `json:"max_resumable_upload_bytes"`
+	ResumableUploadExpiry   Duration `json:"resumable_upload_expiry"`
+
+	// BlobBackend is local or s3. Original recordings and screenshots are
+	// kept there for BlobRetention.
+	BlobBackend       string   `json:"blob_backend"`
+	BlobDir           string   `json:"blob_dir"`
+	BlobURLTTL        Duration `json:"blob_url_ttl"`
+	BlobRetention     Duration `json:"blob_retention"`
+	PublicBaseURL     string   `json:"public_base_url"`
+	S3Endpoint        string   `json:"s3_endpoint"`
+	S3Region          string   `json:"s3_region"`
+	S3Bucket          string   `json:"s3_bucket"`
+	S3AccessKeyID     string   `json:"s3_access_key_id"`
+	S3SecretAccessKey string   `json:"s3_secret_access_key"`
+
+	MinRecordingDuration        Duration `json:"min_recording_duration"`
+	MaxRecordingDurationFree    Duration `json:"max_recording_duration_free"`
+	MaxRecordingDurationPremium Duration `json:"max_recording_duration_premium"`
+
+	// Screen recordings of a chat are turned into screenshots
+	MaxScreenRecordingBytes    int64    `json:"max_screen_recording_bytes"`
+	MaxScreenRecordingDuration Duration `json:"max_screen_recording_duration"`
+
+	PremiumCredits int `json:"premium_credits"`
+
+	ServiceName     string `json:"service_name"`
+	TracingExporter string `json:"tracing_exporter"`
+	TracingEndpoint string `json:"tracing_endpoint"`
+
+	APNSKeyPath string `json:"apns_key_path"`
+	APNSKeyID   string `json:"apns_key_id"`
+	APNSTeamID  string `json:"apns_team_id"`
+	APNSTopic   string `json:"apns_topic"`
+
+	RateLimitBackend string `json:"rate_limit_backend"`
+}
+
+// App is the configuration loaded at startup
+var App *Config
+
+func Default() *Config {
+	return &Config{
+		Port:                "8080",
+		LogLevel:            "info",
+		ReadTimeout:         Duration(5 * time.Minute),
+		WriteTimeout:        Duration(5 * time.Minute),
+		IdleTimeout:         Duration(2 * time.Minute),
+		ShutdownTimeout:     Duration(25 * time.Second),
+		TokenTTL:            Duration(30 * 24 * time.Hour),
+		OpenAIBaseURL:       "https://api.openai.com/v1",
+		JudgmentModel:       "gpt-4o-mini",
+		ScreenshotModel:     "gpt-4o",
+		TranscriptionModel:  "whisper-1",
+		MaxAudioUploadBytes: 50 << 20,
+		MaxScreenshotBytes:  10 << 20,
+		UploadDir:           "/tmp/uploads",
+		FFmpegTimeout:       Duration(60 * time.Second),
+		AudioPreset:         "standard",
+		Diarization:         "channels",
+		PremiumCredits:      20,
+		ServiceName:         "thirdparty-server",
+		TracingExporter:     "none",
+		RateLimitBackend:    "memory",
+
+		MinRecordingDuration:        Duration(2 * time.Second),
+		MaxRecordingDurationFree:    Duration(10 * time.Minute),
+		MaxRecordingDurationPremium: Duration(60 * time.Minute),
+
+		MaxScreenRecordingBytes:    100 << 20,
+		MaxScreenRecordingDuration: Duration(3 * time.Minute),
+
+		MaxResumableUploadBytes: 1 << 30,
+		ResumableUploadExpiry:   Duration(24 * time.Hour),
+
+		BlobBackend:   "local",
+		BlobDir:       "data/blobs",
+		BlobURLTTL:    Duration(15 * time.Minute),
+		BlobRetention: Duration(90 * 24 * time.Hour),
+
+		TranscriptionBackend:    "openai",
+		TranscriptionFixtureDir: "fixtures/transcriptions",
+
+		TranscriptionChunkDuration: Duration(10 * time.Minute),
+		TranscriptionChunkOverlap:  Duration(2 * time.Second),
+		TranscriptionConcurrency:   3,
+		WhisperTimeout:             Duration(20 * time.Minute),
+	}
+}
+
+// Load builds the configuration from defaults, then the JSON file named by
+// CONFIG_FILE (if any), then the environment. Variables from .env are added
+// to the environment first and never override ones already set.
+func Load() (*Config, error) {
+	_ = godotenv.Load()
+
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := json.Unmarshal(contents, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+
+	stringVar(&cfg.Port, "PORT")
+	stringVar(&cfg.LogLevel, "LOG_LEVEL")
+	durationVar(&cfg.ReadTimeout, "READ_TIMEOUT", &errs)
+	durationVar(&cfg.WriteTimeout, "WRITE_TIMEOUT", &errs)
+	durationVar(&cfg.IdleTimeout, "IDLE_TIMEOUT", &errs)
+	durationVar(&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT", &errs)
+	stringVar(&cfg.DatabaseURL, "DATABASE_URL")
+	stringVar(&cfg.JWTSecret, "JWT_SECRET")
+	durationVar(&cfg.TokenTTL, "TOKEN_TTL", &errs)
+	stringVar(&cfg.OpenAIAPIKey, "OPENAI_API_KEY")
+	stringVar(&cfg.OpenAIBaseURL, "OPENAI_BASE_URL")
+	stringVar(&cfg.JudgmentModel, "JUDGMENT_MODEL")
+	stringVar(&cfg.ScreenshotModel, "SCREENSHOT_MODEL")
+	stringVar(&cfg.TranscriptionModel, "TRANSCRIPTION_MODEL")
+	stringVar(&cfg.TranscriptionBackend, "TRANSCRIPTION_BACKEND")
+	stringVar(&cfg.WhisperCommand, "WHISPER_COMMAND")
+	stringVar(&cfg.WhisperModel, "WHISPER_MODEL")
+	intVar(&cfg.WhisperThreads, "WHISPER_THREADS", &errs)
+	durationVar(&cfg.WhisperTimeout, "WHISPER_TIMEOUT", &errs)
+	stringVar(&cfg.TranscriptionFixtureDir, "TRANSCRIPTION_FIXTURE_DIR")
+	durationVar(&cfg.TranscriptionChunkDuration, "TRANSCRIPTION_CHUNK_DURATION", &errs)
+	durationVar(&cfg.TranscriptionChunkOverlap, "TRANSCRIPTION_CHUNK_OVERLAP", &errs)
+	intVar(&cfg.TranscriptionConcurrency, "TRANSCRIPTION_CONCURRENCY", &errs)
+	int64Var(&cfg.MaxAudioUploadBytes, "MAX_AUDIO_UPLOAD_BYTES", &errs)
+	int64Var(&cfg.MaxScreenshotBytes, "MAX_SCREENSHOT_BYTES", &errs)
+	stringVar(&cfg.UploadDir, "UPLOAD_DIR")
+	durationVar(&cfg.FFmpegTimeout, "FFMPEG_TIMEOUT", &errs)
+	stringVar(&cfg.AudioPreset, "AUDIO_PRESET")
+	stringVar(&cfg.Diarization, "DIARIZATION")
+	int64Var(&cfg.MaxResumableUploadBytes, "MAX_RESUMABLE_UPLOAD_BYTES", &errs)
+	durationVar(&cfg.ResumableUploadExpiry, "RESUMABLE_UPLOAD_EXPIRY", &errs)
+	stringVar(&cfg.BlobBackend, "BLOB_BACKEND")
+	stringVar(&cfg.BlobDir, "BLOB_DIR")
+	durationVar(&cfg.BlobURLTTL, "BLOB_URL_TTL", &errs)
+	durationVar(&cfg.BlobRetention, "BLOB_RETENTION", &errs)
+	stringVar(&cfg.PublicBaseURL, "PUBLIC_BASE_URL")
+	stringVar(&cfg.S3Endpoint, "S3_ENDPOINT")
+	stringVar(&cfg.S3Region, "S3_REGION")
+	stringVar(&cfg.S3Bucket, "S3_BUCKET")
+	stringVar(&cfg.S3AccessKeyID, "S3_ACCESS_KEY_ID")
+	stringVar(&cfg.S3SecretAccessKey, "S3_SECRET_ACCESS_KEY")
+	durationVar(&cfg.MinRecordingDuration, "MIN_RECORDING_DURATION", &errs)
+	durationVar(&cfg.MaxRecordingDurationFree, "MAX_RECORDING_DURATION_FREE", &errs)
+	durationVar(&cfg.MaxRecordingDurationPremium, "MAX_RECORDING_DURATION_PREMIUM", &errs)
+	int64Var(&cfg.MaxScreenRecordingBytes, "MAX_SCREEN_RECORDING_BYTES", &errs)
+	durationVar(&cfg.MaxScreenRecordingDuration, "MAX_SCREEN_RECORDING_DURATION", &errs)
+	intVar(&cfg.PremiumCredits, "PREMIUM_CREDITS", &errs)
+	stringVar(&cfg.ServiceName, "OTEL_SERVICE_NAME")
+	stringVar(&cfg.TracingExporter, "TRACING_EXPORTER")
+	stringVar(&cfg.TracingEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	stringVar(&cfg.APNSKeyPath, "APNS_KEY_PATH")
+	stringVar(&cfg.APNSKeyID, "APNS_KEY_ID")
+	stringVar(&cfg.APNSTeamID, "APNS_TEAM_ID")
+	stringVar(&cfg.APNSTopic, "APNS_TOPIC")
+	stringVar(&cfg.RateLimitBackend, "RATE_LIMIT_BACKEND")
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every problem at once so a bad deploy fails with the
+// full list instead of one variable at a time.
+func (c *Config) Validate() error {
+	var errs []error
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"DATABASE_URL", c.DatabaseURL},
+		{"JWT_SECRET", c.JWTSecret},
+		{"OPENAI_API_KEY", c.OpenAIAPIKey},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", r.name))
+		}
+	}
+
+	if c.Port == "" {
+		errs = append(errs, errors.New("PORT must not be empty"))
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if c.TokenTTL <= 0 {
+		errs = append(errs, errors.New("TOKEN_TTL must be positive"))
+	}
+	if c.MaxAudioUploadBytes <= 0 {
+		errs = append(errs, errors.New("MAX_AUDIO_UPLOAD_BYTES must be positive"))
+	}
+	if c.MaxScreenshotBytes <= 0 {
+		errs = append(errs, errors.New("MAX_SCREENSHOT_BYTES must be positive"))
+	}
+	if c.UploadDir == "" {
+		errs = append(errs, errors.New("UPLOAD_DIR must not be empty"))
+	}
+	if c.FFmpegTimeout <= 0 {
+		errs = append(errs, errors.New("FFMPEG_TIMEOUT must be positive"))
+	}
+	switch c.AudioPreset {
+	case "none", "light", "standard", "aggressive":
+	default:
+		errs = append(errs, errors.New("AUDIO_PRESET must be none, light, standard or aggressive"))
+	}
+	if c.Diarization != "channels" && c.Diarization != "none" {
+		errs = append(errs, errors.New("DIARIZATION must be channels or none"))
+	}
+	if c.MaxResumableUploadBytes <= 0 {
+		errs = append(errs, errors.New("MAX_RESUMABLE_UPLOAD_BYTES must be positive"))
+	}
+	if c.ResumableUploadExpiry <= 0 {
+		errs = append(errs, errors.New("RESUMABLE_UPLOAD_EXPIRY must be positive"))
+	}
+	if u, err := url.Parse(c.OpenAIBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.New("OPENAI_BASE_URL must be an absolute URL"))
+	}
+	if c.MinRecordingDuration < 0 || c.MaxRecordingDurationFree <= c.MinRecordingDuration || c.MaxRecordingDurationPremium < c.MaxRecordingDurationFree {
+		errs = append(errs, errors.New("recording durations must satisfy 0 <= MIN_RECORDING_DURATION < MAX_RECORDING_DURATION_FREE <= MAX_RECORDING_DURATION_PREMIUM"))
+	}
+	if c.MaxScreenRecordingBytes <= 0 {
+		errs = append(errs, errors.New("MAX_SCREEN_RECORDING_BYTES must be positive"))
+	}
+	if c.MaxScreenRecordingDuration <= 0 {
+		errs = append(errs, errors.New("MAX_SCREEN_RECORDING_DURATION must be positive"))
+	}
+	switch c.BlobBackend {
+	case "local":
+		if c.BlobDir == "" {
+			errs = append(errs, errors.New("BLOB_DIR must not be empty"))
+		}
+	case "s3":
+		if u, err := url.Parse(c.S3Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("S3_ENDPOINT must be an http or https URL with BLOB_BACKEND=s3"))
+		}
+		if c.S3Bucket == "" || c.S3AccessKeyID == "" || c.S3SecretAccessKey == "" {
+			errs = append(errs, errors.New("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required with BLOB_BACKEND=s3"))
+		}
+	default:
+		errs = append(errs, errors.New("BLOB_BACKEND must be local or s3"))
+	}
+	// S3 refuses to presign for longer than a week
+	if c.BlobURLTTL < Duration(time.Minute) || c.BlobURLTTL > Duration(7*24*time.Hour) {
+		errs = append(errs, errors.New("BLOB_URL_TTL must be between 1m and 168h"))
+	}
+	if c.BlobRetention <= 0 {
+		errs = append(errs, errors.New("BLOB_RETENTION must be positive"))
+	}
+	if c.PublicBaseURL != "" {
+		if u, err := url.Parse(c.PublicBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, errors.New("PUBLIC_BASE_URL must be an absolute URL"))
+		}
+	}
+
+	switch c.TranscriptionBackend {
+	case "openai":
+	case "whisper_cpp":
+		if _, err := os.Stat(c.WhisperModel); err != nil {
+			errs = append(errs, fmt.Errorf("WHISPER_MODEL must be a ggml model file with TRANSCRIPTION_BACKEND=whisper_cpp: %v", err))
+		}
+	case "faster_whisper":
+		if c.WhisperModel == "" {
+			errs = append(errs, errors.New("WHISPER_MODEL is required with TRANSCRIPTION_BACKEND=faster_whisper"))
+		}
+	case "fixture":
+		if _, err := os.Stat(filepath.Join(c.TranscriptionFixtureDir, "default.json")); err != nil {
+			errs = append(errs, fmt.Errorf("TRANSCRIPTION_FIXTURE_DIR must contain default.json: %v", err))
+		}
+	default:
+		errs = append(errs, errors.New("TRANSCRIPTION_BACKEND must be openai, whisper_cpp, faster_whisper or fixture"))
+	}
+	if c.WhisperThreads < 0 {
+		errs = append(errs, errors.New("WHISPER_THREADS must not be negative"))
+	}
+	if c.WhisperTimeout <= 0 {
+		errs = append(errs, errors.New("WHISPER_TIMEOUT must be positive"))
+	}
+
+	// Whisper accepts 25MB per request, about 30 minutes of the normalized audio
+	if c.TranscriptionChunkDuration < Duration(time.Minute) || c.TranscriptionChunkDuration > Duration(30*time.Minute) {
+		errs = append(errs, errors.New("TRANSCRIPTION_CHUNK_DURATION must be between 1m and 30m"))
+	}
+	if c.TranscriptionChunkOverlap < 0 || c.TranscriptionChunkOverlap*4 > c.TranscriptionChunkDuration {
+		errs = append(errs, errors.New("TRANSCRIPTION_CHUNK_OVERLAP must be between 0 and a quarter of the chunk duration"))
+	}
+	if c.TranscriptionConcurrency < 1 {
+		errs = append(errs, errors.New("TRANSCRIPTION_CONCURRENCY must be at least 1"))
+	}
+	if c.JudgmentModel == "" || c.ScreenshotModel == "" || c.TranscriptionModel == "" {
+		errs = append(errs, errors.New("model names must not be empty"))
+	}
+	if c.PremiumCredits < 0 {
+		errs = append(errs, errors.New("PREMIUM_CREDITS must not be negative"))
+	}
+
+	switch c.TracingExporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, errors.New("TRACING_EXPORTER must be none, stdout or otlp"))
+	}
+
+	switch c.RateLimitBackend {
+	case "memory", "postgres":
+	default:
+		errs = append(errs, errors.New("RATE_LIMIT_BACKEND must be memory or postgres"))
+	}
+
+	if c.APNSKeyPath != "" {
+		if c.APNSKeyID == "" || c.APNSTeamID == "" || c.APNSTopic == "" {
+			errs = append(errs, errors.New("APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC are required with APNS_KEY_PATH"))
+		}
+		if _, err := os.Stat(c.APNSKeyPath); err != nil {
+			errs = append(errs, fmt.Errorf("APNS_KEY_PATH: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy that is safe to print.
+func (c Config) Redacted() Config {
+	mask := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return "********"
+	}
+
+	c.DatabaseURL = mask(c.DatabaseURL)
+	c.JWTSecret = mask(c.JWTSecret)
+	c.OpenAIAPIKey = mask(c.OpenAIAPIKey)
+	c.S3SecretAccessKey = mask(c.S3SecretAccessKey)
+
+	return c
+}
+
+func stringVar(target *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+func intVar(target *int, name string, errs *[]error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be an integer", name))
+		return
+	}
+	*target = parsed
+}
+
+func int64Var(target *int64, name string, errs *[]error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be an integer", name))
+		return
+	}
+	*target = parsed
+}
+
+func durationVar(target *Duration, name string, errs *[]error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s must be a duration like 30s or 720h", name))
+		return
+	}
+	*target = Duration(parsed)
+}
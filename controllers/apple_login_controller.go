@@ -7,28 +7,25 @@ import (
 	"errors"
 	"math/big"
 	"net/http"
-	"os"
-	"time"
 
-	"github.com/calebchiang/thirdparty_server/database"
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/dto"
 	"github.com/calebchiang/thirdparty_server/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AppleLogin(c *gin.Context) {
-	var input struct {
-		IdentityToken string `json:"identityToken"`
-	}
+func (uc *UserController) AppleLogin(c *gin.Context) {
+	var input dto.AppleLoginRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil || input.IdentityToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "identityToken required"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "identityToken required").WithDetails(gin.H{"field": "identityToken"}))
 		return
 	}
 
 	keys, err := fetchApplePublicKeys()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch Apple keys"})
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to fetch Apple keys").Wrap(err))
 		return
 	}
 
@@ -50,7 +47,7 @@ func AppleLogin(c *gin.Context) {
 		return key, nil
 	})
 	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Apple token"})
+		apierror.Abort(c, apierror.New(apierror.InvalidAppleToken, "Invalid Apple token"))
 		return
 	}
 
@@ -58,53 +55,39 @@ func AppleLogin(c *gin.Context) {
 
 	email, _ := claims["email"].(string)
 	if email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email not found in token"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Email not found in token").WithDetails(gin.H{"field": "identityToken"}))
 		return
 	}
 
 	appleUserID, ok := claims["sub"].(string)
 	if !ok || appleUserID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Apple user ID"})
+		apierror.Abort(c, apierror.New(apierror.InvalidAppleToken, "Missing Apple user ID"))
 		return
 	}
 
 	// Check if user exists
-	var user models.User
-	result := database.DB.Where("email = ?", email).First(&user)
-	if result.Error != nil {
+	user, err := uc.Users.FindByEmail(c.Request.Context(), email)
+	if err != nil {
 		// User does not exist, create one
-		user = models.User{
+		user = &models.User{
 			Name:     "", // optional: set to "" or "Apple User"
 			Email:    email,
 			Password: "", // optional: leave blank or store "apple"
 		}
-		if err := database.DB.Create(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		if err := uc.Users.Create(c.Request.Context(), user); err != nil {
+			apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create user").Wrap(err))
 			return
 		}
 	}
 
 	// Generate JWT
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "JWT secret not configured"})
-		return
-	}
-
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(30 * 24 * time.Hour).Unix(),
-	})
-
-	tokenString, err := jwtToken.SignedString([]byte(secret))
+	tokenString, err := signToken(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to generate token").Wrap(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-	})
+	c.JSON(http.StatusOK, dto.TokenResponse{Token: tokenString})
 }
 
 func fetchApplePublicKeys() (map[string]*rsa.PublicKey, error) {
@@ -1,78 +1,301 @@
 package controllers
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
-
-	"github.com/calebchiang/thirdparty_server/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/dto"
+	"github.com/calebchiang/thirdparty_server/metrics"
 	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
 	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/calebchiang/thirdparty_server/tracing"
 	"github.com/gin-gonic/gin"
 )
 
-func GetArguments(c *gin.Context) {
+type ArgumentController struct {
+	Users         repository.UserRepo
+	Credits       repository.CreditLedger
+	Arguments     repository.ArgumentRepo
+	Judgments     repository.JudgmentRepo
+	Relationships repository.RelationshipRepo
+	Pipeline      *services.Pipeline
+	Media         services.MediaNormalizer
+	Clips         services.ClipCutter
+	Frames        services.FrameExtractor
+	Transcriber   services.Transcriber
+	Uploads       *services.UploadStore
+	Archive       *services.MediaArchive
+	Events        *services.EventBroker
+}
+
+func NewArgumentController(
+	repos *repository.Repositories,
+	pipeline *services.Pipeline,
+	media services.MediaNormalizer,
+	clips services.ClipCutter,
+	frames services.FrameExtractor,
+	transcriber services.Transcriber,
+	uploads *services.UploadStore,
+	archive *services.MediaArchive,
+	events *services.EventBroker,
+) *ArgumentController {
+	return &ArgumentController{
+		Users:         repos.Users,
+		Credits:       repos.Credits,
+		Arguments:     repos.Arguments,
+		Judgments:     repos.Judgments,
+		Relationships: repos.Relationships,
+		Pipeline:      pipeline,
+		Media:         media,
+		Clips:         clips,
+		Frames:        frames,
+		Transcriber:   transcriber,
+		Uploads:       uploads,
+		Archive:       archive,
+		Events:        events,
+	}
+}
+
+func (ac *ArgumentController) GetArguments(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
 		return
 	}
 
-	var arguments []models.Argument
+	params := repository.ArgumentListParams{
+		UserID:      userID.(uint),
+		Status:      c.Query("status"),
+		Persona:     c.Query("persona"),
+		Winner:      c.Query("winner"),
+		Participant: strings.TrimSpace(c.Query("participant")),
+		Query:       strings.TrimSpace(c.Query("q")),
+		Sort:        c.DefaultQuery("sort", "created_at"),
+		Order:       c.DefaultQuery("order", "desc"),
+		Cursor:      c.Query("cursor"),
+	}
 
-	if err := database.DB.
-		Preload("Judgment").
-		Where("user_id = ?", userID.(uint)).
-		Order("created_at desc").
-		Find(&arguments).Error; err != nil {
+	if params.Sort != "created_at" && params.Sort != "score" {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "sort must be created_at or score").WithDetails(gin.H{"field": "sort"}))
+		return
+	}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch arguments"})
+	if params.Order != "asc" && params.Order != "desc" {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "order must be asc or desc").WithDetails(gin.H{"field": "order"}))
 		return
 	}
 
-	c.JSON(http.StatusOK, arguments)
-}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > repository.MaxArgumentPageSize {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "limit must be between 1 and 100").WithDetails(gin.H{"field": "limit"}))
+			return
+		}
+		params.Limit = limit
+	}
 
-func CreateArgument(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	if raw := c.Query("relationship_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid relationship_id").WithDetails(gin.H{"field": "relationship_id"}))
+			return
+		}
+		relationshipID := uint(id)
+		params.RelationshipID = &relationshipID
+	}
+
+	var err error
+
+	if params.From, err = parseDateQuery(c.Query("from"), false); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "from must be RFC3339 or YYYY-MM-DD").WithDetails(gin.H{"field": "from"}))
 		return
 	}
 
-	// Fetch user
-	var user models.User
-	if err := database.DB.First(&user, userID.(uint)).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if params.To, err = parseDateQuery(c.Query("to"), true); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "to must be RFC3339 or YYYY-MM-DD").WithDetails(gin.H{"field": "to"}))
 		return
 	}
 
-	// Check credits
-	if user.Credits <= 0 {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "No credits remaining",
-		})
+	if params.MinScore, err = parseScoreQuery(c.Query("min_score")); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "min_score must be between 0 and 100").WithDetails(gin.H{"field": "min_score"}))
 		return
 	}
 
-	// Deduct credit
-	if err := database.DB.Model(&user).
-		Update("credits", user.Credits-1).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to deduct credit",
-		})
+	if params.MaxScore, err = parseScoreQuery(c.Query("max_score")); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "max_score must be between 0 and 100").WithDetails(gin.H{"field": "max_score"}))
 		return
 	}
 
-	// Parse form fields
+	page, err := ac.Arguments.List(c.Request.Context(), params)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			apierror.Abort(c, apierror.New(apierror.InvalidCursor, "Invalid cursor"))
+			return
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to fetch arguments").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseDateQuery accepts RFC3339 timestamps or plain dates. A plain date used
+// as an upper bound includes the whole day.
+func parseDateQuery(raw string, endOfDay bool) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t, nil
+}
+
+func parseScoreQuery(raw string) (*int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	score, err := strconv.Atoi(raw)
+	if err != nil || score < 0 || score > 100 {
+		return nil, errors.New("score out of range")
+	}
+
+	return &score, nil
+}
+
+// parseID reads a numeric path parameter. Anything else can't name a record,
+// so callers report it as not found.
+func parseID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// checkCredits rejects the upload before its body is read when the user has
+// nothing left to spend. The credit itself is taken later by Consume.
+func checkCredits(c *gin.Context, credits repository.CreditLedger, userID uint) bool {
+	balance, err := credits.Balance(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
+			return false
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to load credits").Wrap(err))
+		return false
+	}
+
+	if balance <= 0 {
+		apierror.Abort(c, apierror.New(apierror.InsufficientCredits, "No credits remaining"))
+		return false
+	}
+
+	return true
+}
+
+func (ac *ArgumentController) consumeCredit(c *gin.Context, userID uint) bool {
+	if err := ac.Credits.Consume(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrNoCredits) {
+			apierror.Abort(c, apierror.New(apierror.InsufficientCredits, "No credits remaining"))
+			return false
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to deduct credit").Wrap(err))
+		return false
+	}
+
+	return true
+}
+
+// resolveParticipants returns the names for a new argument. When the form
+// carries a relationship_id the names come from that saved relationship,
+// otherwise person_a_name and person_b_name are required.
+func (ac *ArgumentController) resolveParticipants(c *gin.Context, userID uint) (*uint, string, string, bool) {
+	// Parse the form up front so a body over the size cap is reported as such
+	if _, err := c.MultipartForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Abort(c, apierror.New(apierror.UploadTooLarge, "Upload too large"))
+			return nil, "", "", false
+		}
+	}
+
+	if raw := c.PostForm("relationship_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.RelationshipNotFound, "Relationship not found"))
+			return nil, "", "", false
+		}
+
+		relationship, err := ac.Relationships.FindForUser(c.Request.Context(), uint(id), userID)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.RelationshipNotFound, "Relationship not found"))
+			return nil, "", "", false
+		}
+
+		return &relationship.ID, relationship.PersonAName, relationship.PersonBName, true
+	}
+
 	personAName := c.PostForm("person_a_name")
 	personBName := c.PostForm("person_b_name")
-	persona := c.PostForm("persona")
 
 	if personAName == "" || personBName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Person names are required"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Person names are required").WithDetails(gin.H{"field": "person_a_name"}))
+		return nil, "", "", false
+	}
+
+	return nil, personAName, personBName, true
+}
+
+func (ac *ArgumentController) CreateArgument(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	// Check credits before reading the request body
+	if !checkCredits(c, ac.Credits, userID.(uint)) {
+		return
+	}
+
+	// Cap the body so oversized uploads are rejected while parsing instead
+	// of being spooled to disk first
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.App.MaxAudioUploadBytes+multipartOverhead)
+
+	// Resolve participants (saved relationship or free-form names)
+	relationshipID, personAName, personBName, ok := ac.resolveParticipants(c, userID.(uint))
+	if !ok {
 		return
 	}
 
+	// Parse form fields
+	persona := c.PostForm("persona")
+
 	// Validate persona
 	validPersonas := map[string]bool{
 		"mediator": true,
@@ -84,165 +307,398 @@ func CreateArgument(c *gin.Context) {
 		persona = "mediator"
 	}
 
-	// Get uploaded file
-	fileHeader, err := c.FormFile("audio")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Audio file is required"})
-		return
+	ctx := c.Request.Context()
+
+	// The recording is either a finished resumable upload or an inline file
+	var upload *services.Upload
+	var fileHeader *multipart.FileHeader
+	var err error
+
+	if uploadID := c.PostForm("upload_id"); uploadID != "" {
+		// Held until the argument exists or the attempt fails, so a
+		// concurrent request with the same upload can't be charged too
+		var release func()
+		upload, release, err = ac.Uploads.Claim(uploadID, userID.(uint))
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrUploadNotFound):
+				apierror.Abort(c, apierror.New(apierror.UploadNotFound, "Upload not found").WithDetails(gin.H{"field": "upload_id"}))
+			case errors.Is(err, services.ErrUploadLocked):
+				apierror.Abort(c, apierror.New(apierror.UploadLocked, "Upload is already being used by another request").WithDetails(gin.H{"field": "upload_id"}))
+			default:
+				apierror.Abort(c, apierror.New(apierror.Internal, "Failed to load upload").Wrap(err))
+			}
+			return
+		}
+		defer release()
+
+		if !upload.Complete() {
+			apierror.Abort(c, apierror.New(apierror.UploadIncomplete, "Upload is not finished").
+				WithDetails(gin.H{"field": "upload_id", "offset": upload.Offset, "length": upload.Length}))
+			return
+		}
+	} else {
+		fileHeader, err = c.FormFile("audio")
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Audio file or upload_id is required").WithDetails(gin.H{"field": "audio"}))
+			return
+		}
+
+		// Enforce file size limit
+		if fileHeader.Size > config.App.MaxAudioUploadBytes {
+			apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("File too large (max %dMB)", config.App.MaxAudioUploadBytes>>20)).
+				WithDetails(gin.H{"field": "audio", "max_bytes": config.App.MaxAudioUploadBytes}))
+			return
+		}
 	}
 
-	// Enforce file size limit (50MB)
-	const maxFileSize = 50 << 20 // 50MB
-	if fileHeader.Size > maxFileSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File too large (max 50MB)"})
+	// Duration limits depend on the user's tier
+	user, err := ac.Users.FindByID(ctx, userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
 		return
 	}
 
-	// Normalize media (handles video + audio formats)
-	mediaService := services.NewMediaService()
+	var inputPath, contentType, filename string
+	if upload != nil {
+		// Kept until the argument exists so a failed attempt can be retried
+		inputPath = ac.Uploads.Path(upload)
+		contentType = upload.MetadataValue("filetype")
+		filename = upload.MetadataValue("filename")
+	} else {
+		contentType = fileHeader.Header.Get("Content-Type")
+		filename = fileHeader.Filename
+
+		inputPath, err = services.SaveUploadedFile(ctx, fileHeader)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.MediaProcessingFailed, "Failed to save upload").Wrap(err))
+			return
+		}
+		defer os.Remove(inputPath)
+	}
 
-	normalizedPath, err := mediaService.Normalize(fileHeader)
+	// Validate and normalize media (handles video + audio formats)
+	media, err := ac.Media.Normalize(ctx, inputPath, services.MediaLimitsFor(*user))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process media"})
+		abortMediaError(c, err, "audio")
 		return
 	}
 
+	// Clean up normalized files after transcription
+	defer media.Remove()
+
 	// Generate transcript from normalized file
-	transcriptionResult, err := services.GenerateTranscriptFromPath(normalizedPath)
+	transcriptionResult, err := services.TranscribeMedia(ctx, ac.Transcriber, media, personAName, personBName)
 	if err != nil {
-		_ = os.Remove(normalizedPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate transcript"})
+		apierror.Abort(c, apierror.New(apierror.TranscriptionFailed, "Failed to generate transcript").Wrap(err))
+		return
+	}
+
+	// Deduct credit only once there is something to judge
+	if !ac.consumeCredit(c, userID.(uint)) {
 		return
 	}
 
-	// Clean up normalized file after transcription
-	_ = os.Remove(normalizedPath)
+	metrics.CreditsConsumed.WithLabelValues("audio").Inc()
+
+	metrics.RecordAudio(media.DurationSeconds, media.TranscribedSeconds())
 
 	// Create argument record
 	argument := models.Argument{
-		UserID:        userID.(uint),
-		PersonAName:   personAName,
-		PersonBName:   personBName,
-		Persona:       persona,
-		Transcription: transcriptionResult.Text,
-		Status:        "processing",
+		UserID:          userID.(uint),
+		RelationshipID:  relationshipID,
+		PersonAName:     personAName,
+		PersonBName:     personBName,
+		Persona:         persona,
+		Transcription:   transcriptionResult.Text,
+		DurationSeconds: &media.DurationSeconds,
+		Status:          "processing",
+
+		ProcessedDurationSeconds: &media.ProcessedDurationSeconds,
 	}
 
-	if err := database.DB.Create(&argument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create argument"})
+	dbCtx, dbSpan := tracing.Start(ctx, "db.insert_argument")
+	err = ac.Arguments.Create(dbCtx, &argument)
+	tracing.End(dbSpan, err)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create argument").Wrap(err))
 		return
 	}
 
-	// Run judgment asynchronously
-	go services.ProcessJudgment(argument.ID)
+	// Keep the original for re-processing, and the transcribed audio for
+	// playback. Losing them doesn't stop the judgment.
+	if _, err := ac.Archive.SaveFile(ctx, argument, models.MediaKindRecording, 0, inputPath, contentType, filename); err != nil {
+		slog.ErrorContext(ctx, "archiving recording failed", "argument_id", argument.ID, "error", err)
+	}
+	if _, err := ac.Archive.SaveFile(ctx, argument, models.MediaKindAudio, 0, media.Path, "audio/mp4", "audio.m4a"); err != nil {
+		slog.ErrorContext(ctx, "archiving audio failed", "argument_id", argument.ID, "error", err)
+	}
+
+	ac.Pipeline.RecordStage(ctx, argument.ID, "processing", services.StageQueued)
+
+	if upload != nil {
+		if err := ac.Uploads.Delete(upload.ID, userID.(uint)); err != nil {
+			slog.WarnContext(ctx, "removing finished upload failed", "upload_id", upload.ID, "error", err)
+		}
+	}
+
+	// Run judgment asynchronously, keeping the request ID but not the
+	// request's cancellation
+	ac.Pipeline.Enqueue(ctx, argument.ID)
 
 	// Return immediately
-	c.JSON(http.StatusCreated, gin.H{
-		"id":            argument.ID,
-		"user_id":       argument.UserID,
-		"person_a_name": argument.PersonAName,
-		"person_b_name": argument.PersonBName,
-		"persona":       argument.Persona,
-		"status":        argument.Status,
-		"created_at":    argument.CreatedAt,
-	})
+	c.JSON(http.StatusCreated, dto.NewArgument(argument))
+}
+
+// abortMediaError reports a rejected upload with its own code and anything
+// else as a processing failure
+func abortMediaError(c *gin.Context, err error, field string) {
+	var rejection *services.MediaRejection
+	if !errors.As(err, &rejection) {
+		apierror.Abort(c, apierror.New(apierror.MediaProcessingFailed, "Failed to process media").Wrap(err))
+		return
+	}
+
+	details := gin.H{"field": field, "reason": rejection.Detail}
+
+	var code apierror.Code
+	switch {
+	case errors.Is(err, services.ErrNoAudioStream):
+		code = apierror.NoAudioStream
+	case errors.Is(err, services.ErrRecordingTooShort):
+		code = apierror.RecordingTooShort
+		details["duration_seconds"] = rejection.DurationSeconds
+		details["min_duration_seconds"] = rejection.LimitSeconds
+	case errors.Is(err, services.ErrRecordingTooLong):
+		code = apierror.RecordingTooLong
+		details["duration_seconds"] = rejection.DurationSeconds
+		details["max_duration_seconds"] = rejection.LimitSeconds
+	default:
+		code = apierror.UnsupportedMedia
+	}
+
+	apierror.Abort(c, apierror.New(code, "Recording rejected: "+rejection.Detail).WithDetails(details))
+}
+
+// Allowance for form fields and multipart boundaries on top of file limits
+const multipartOverhead = 1 << 20
+
+// Screenshots have a per-file limit only, so bound the whole request by
+// the most files a client can reasonably send.
+const maxScreenshotsPerRequest = 20
+
+func maxScreenshotRequestBytes() int64 {
+	return max(config.App.MaxScreenshotBytes*maxScreenshotsPerRequest, config.App.MaxScreenRecordingBytes) + multipartOverhead
 }
 
-func GetArgumentByID(c *gin.Context) {
+func (ac *ArgumentController) GetArgumentByID(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	id, ok := parseID(c, "id")
+	if !ok {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
 		return
 	}
 
-	id := c.Param("id")
+	argument, err := ac.Arguments.FindForUser(c.Request.Context(), id, userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+		return
+	}
 
-	var argument models.Argument
+	c.JSON(http.StatusOK, dto.NewArgumentDetail(*argument))
+}
 
-	if err := database.DB.
-		Preload("Judgment").
-		Where("id = ? AND user_id = ?", id, userID.(uint)).
-		First(&argument).Error; err != nil {
+// GetArgumentMedia lists the stored recording and screenshots with signed
+// download URLs
+func (ac *ArgumentController) GetArgumentMedia(c *gin.Context) {
+	argument, ok := ac.ownedArgument(c)
+	if !ok {
+		return
+	}
 
-		c.JSON(http.StatusNotFound, gin.H{"error": "Argument not found"})
+	links, err := ac.Archive.Links(c.Request.Context(), argument.ID)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to load media").Wrap(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, argument)
+	response := dto.ArgumentMedia{Media: make([]dto.MediaBlob, 0, len(links))}
+	for _, link := range links {
+		response.Media = append(response.Media, dto.NewMediaBlob(link.Blob, link.URL, link.ExpiresAt))
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-func DeleteArgument(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+// GetArgumentAudio streams the argument's audio. Range requests are
+// honoured so players can seek.
+func (ac *ArgumentController) GetArgumentAudio(c *gin.Context) {
+	argument, ok := ac.ownedArgument(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	blob, err := ac.Archive.Playback(ctx, argument.ID)
+	if err != nil {
+		abortAudioError(c, err)
 		return
 	}
 
-	id := c.Param("id")
+	body, err := ac.Archive.Blobs.Open(ctx, blob.Key)
+	if err != nil {
+		abortAudioError(c, err)
+		return
+	}
+	defer body.Close()
+
+	c.Header("Content-Type", blob.ContentType)
+	c.Header("Cache-Control", "private, max-age=3600")
+	http.ServeContent(c.Writer, c.Request, "", blob.CreatedAt, body)
+}
+
+// GetArgumentClip cuts start to end seconds out of the argument's audio,
+// e.g. the moment a judgment cites
+func (ac *ArgumentController) GetArgumentClip(c *gin.Context) {
+	argument, ok := ac.ownedArgument(c)
+	if !ok {
+		return
+	}
 
-	var argument models.Argument
+	start, err := strconv.ParseFloat(c.Query("start"), 64)
+	if err != nil || math.IsNaN(start) || math.IsInf(start, 0) || start < 0 {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "start must be a non-negative number of seconds").WithDetails(gin.H{"field": "start"}))
+		return
+	}
 
-	// Ensure argument exists AND belongs to user
-	if err := database.DB.
-		Where("id = ? AND user_id = ?", id, userID.(uint)).
-		First(&argument).Error; err != nil {
+	end, err := strconv.ParseFloat(c.Query("end"), 64)
+	if err != nil || math.IsNaN(end) || math.IsInf(end, 0) || end <= start {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "end must be a number of seconds after start").WithDetails(gin.H{"field": "end"}))
+		return
+	}
 
-		c.JSON(http.StatusNotFound, gin.H{"error": "Argument not found"})
+	if end-start > services.MaxClipSeconds {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, fmt.Sprintf("Clips are at most %d seconds", services.MaxClipSeconds)).
+			WithDetails(gin.H{"field": "end", "max_seconds": services.MaxClipSeconds}))
 		return
 	}
 
-	// Delete (Judgment will cascade automatically)
-	if err := database.DB.Delete(&argument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete argument"})
+	// Clips are cut from the trimmed audio
+	duration := argument.ProcessedDurationSeconds
+	if duration == nil {
+		duration = argument.DurationSeconds
+	}
+	if duration != nil {
+		if start >= *duration {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "start is past the end of the recording").
+				WithDetails(gin.H{"field": "start", "duration_seconds": *duration}))
+			return
+		}
+		end = min(end, *duration)
+	}
+
+	blob, body, err := ac.Archive.Clip(c.Request.Context(), *argument, start, end, ac.Clips)
+	if err != nil {
+		abortAudioError(c, err)
 		return
 	}
+	defer body.Close()
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Argument deleted successfully",
-	})
+	c.Header("Content-Type", blob.ContentType)
+	// A clip of a given range never changes
+	c.Header("Cache-Control", "private, max-age=86400, immutable")
+	http.ServeContent(c.Writer, c.Request, "", blob.CreatedAt, body)
 }
 
-func CreateArgumentByScreenshot(c *gin.Context) {
+// ownedArgument loads the argument in the id param, aborting with 404 when
+// it isn't the user's
+func (ac *ArgumentController) ownedArgument(c *gin.Context) (*models.Argument, bool) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return nil, false
+	}
+
+	id, ok := parseID(c, "id")
+	if !ok {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+		return nil, false
+	}
+
+	argument, err := ac.Arguments.FindForUser(c.Request.Context(), id, userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+		return nil, false
+	}
+
+	return argument, true
+}
+
+func abortAudioError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrBlobNotFound) {
+		apierror.Abort(c, apierror.New(apierror.AudioNotFound, "Argument has no stored audio"))
 		return
 	}
+	apierror.Abort(c, apierror.New(apierror.MediaProcessingFailed, "Failed to load audio").Wrap(err))
+}
 
-	// Fetch user
-	var user models.User
-	if err := database.DB.First(&user, userID.(uint)).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+func (ac *ArgumentController) DeleteArgument(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
 		return
 	}
 
-	// Check credits
-	if user.Credits <= 0 {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "No credits remaining",
-		})
+	id, ok := parseID(c, "id")
+	if !ok {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
 		return
 	}
 
-	// Deduct credit
-	if err := database.DB.Model(&user).
-		Update("credits", user.Credits-1).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to deduct credit",
-		})
+	// Only the owner's argument is deleted (Judgment will cascade automatically)
+	if err := ac.Arguments.DeleteForUser(c.Request.Context(), id, userID.(uint)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+			return
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to delete argument").Wrap(err))
 		return
 	}
 
-	// Parse form fields
-	personAName := c.PostForm("person_a_name")
-	personBName := c.PostForm("person_b_name")
-	persona := c.PostForm("persona")
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: "Argument deleted successfully"})
+}
 
-	if personAName == "" || personBName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Person names are required"})
+func (ac *ArgumentController) CreateArgumentByScreenshot(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
 		return
 	}
 
+	// Check credits before reading the request body
+	if !checkCredits(c, ac.Credits, userID.(uint)) {
+		return
+	}
+
+	// Cap the body so oversized uploads are rejected while parsing instead
+	// of being spooled to disk first
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxScreenshotRequestBytes())
+
+	// Resolve participants (saved relationship or free-form names)
+	relationshipID, personAName, personBName, ok := ac.resolveParticipants(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	// Parse form fields
+	persona := c.PostForm("persona")
+
 	// Validate persona
 	validPersonas := map[string]bool{
 		"mediator": true,
@@ -254,54 +710,131 @@ func CreateArgumentByScreenshot(c *gin.Context) {
 		persona = "mediator"
 	}
 
-	// Parse multiple images (frontend should send: screenshots[])
+	// Parse multiple images (frontend should send: screenshots[]) or a
+	// screen recording of the chat
 	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Screenshots are required"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Screenshots are required").WithDetails(gin.H{"field": "screenshots"}))
 		return
 	}
 
+	ctx := c.Request.Context()
+
 	files := form.File["screenshots"]
-	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one screenshot is required"})
+	videos := form.File["video"]
+
+	if len(files) > 0 && len(videos) > 0 {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Send screenshots or a video, not both").WithDetails(gin.H{"field": "video"}))
 		return
 	}
 
-	// Optional: enforce per-file size limit (10MB each)
-	const maxFileSize = 10 << 20
-	for _, file := range files {
-		if file.Size > maxFileSize {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Each screenshot must be under 10MB"})
+	var screenshots []services.Screenshot
+	var videoPath, source string
+	if len(videos) > 0 {
+		source = "screen_recording"
+
+		var ok bool
+		screenshots, videoPath, ok = ac.screenRecordingFrames(c, videos[0])
+		if !ok {
+			return
+		}
+		defer os.Remove(videoPath)
+	} else {
+		source = "screenshot"
+
+		if len(files) == 0 {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "At least one screenshot is required").WithDetails(gin.H{"field": "screenshots"}))
+			return
+		}
+
+		if len(files) > maxScreenshotsPerRequest {
+			apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("At most %d screenshots are allowed", maxScreenshotsPerRequest)).
+				WithDetails(gin.H{"field": "screenshots", "max_files": maxScreenshotsPerRequest}))
+			return
+		}
+
+		// Enforce per-file size limit
+		for _, file := range files {
+			if file.Size > config.App.MaxScreenshotBytes {
+				apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("Each screenshot must be under %dMB", config.App.MaxScreenshotBytes>>20)).
+					WithDetails(gin.H{"field": "screenshots", "max_bytes": config.App.MaxScreenshotBytes}))
+				return
+			}
+		}
+
+		screenshots, err = services.ReadScreenshots(files)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.Internal, "Failed to read screenshots").Wrap(err))
+			return
+		}
+	}
+
+	// The originals are archived; the judge gets them scaled down, without
+	// repeats and in conversation order. Recording frames already are.
+	prepared, err := services.PrepareScreenshots(ctx, screenshots, source == "screenshot")
+	if err != nil {
+		var rejection *services.MediaRejection
+		if errors.As(err, &rejection) {
+			apierror.Abort(c, apierror.New(apierror.UnsupportedMedia, "Screenshot rejected: "+rejection.Detail).
+				WithDetails(gin.H{"field": "screenshots", "reason": rejection.Detail}))
 			return
 		}
+		apierror.Abort(c, apierror.New(apierror.MediaProcessingFailed, "Failed to process screenshots").Wrap(err))
+		return
 	}
 
+	// Deduct credit only once there is something to judge
+	if !ac.consumeCredit(c, userID.(uint)) {
+		return
+	}
+
+	metrics.CreditsConsumed.WithLabelValues(source).Inc()
+
 	// Create argument record FIRST (status = processing)
 	argument := models.Argument{
-		UserID:        userID.(uint),
-		PersonAName:   personAName,
-		PersonBName:   personBName,
-		Persona:       persona,
-		Transcription: "", // not used for screenshots
-		Status:        "processing",
+		UserID:         userID.(uint),
+		RelationshipID: relationshipID,
+		PersonAName:    personAName,
+		PersonBName:    personBName,
+		Persona:        persona,
+		Transcription:  "", // not used for screenshots
+		Status:         "processing",
 	}
 
-	if err := database.DB.Create(&argument).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create argument"})
+	if err := ac.Arguments.Create(ctx, &argument); err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create argument").Wrap(err))
 		return
 	}
 
-	// Call screenshot judgment service (we implement next)
+	if videoPath != "" {
+		video := videos[0]
+		if _, err := ac.Archive.SaveFile(ctx, argument, models.MediaKindScreenRecording, 0, videoPath, video.Header.Get("Content-Type"), video.Filename); err != nil {
+			slog.ErrorContext(ctx, "archiving screen recording failed", "argument_id", argument.ID, "error", err)
+		}
+	}
+
+	for i, screenshot := range screenshots {
+		if _, err := ac.Archive.Save(ctx, argument, models.MediaKindScreenshot, i, bytes.NewReader(screenshot.Data), int64(len(screenshot.Data)), screenshot.ContentType, screenshot.Filename); err != nil {
+			slog.ErrorContext(ctx, "archiving screenshot failed", "argument_id", argument.ID, "position", i, "error", err)
+		}
+	}
+
+	ac.Pipeline.RecordStage(ctx, argument.ID, "processing", services.StageJudging)
+
+	doneJudging := metrics.TrackJudgment()
+
 	result, err := services.GenerateScreenshotJudgment(
+		ctx,
 		personAName,
 		personBName,
 		persona,
-		files,
+		prepared,
 	)
+	doneJudging()
 
 	if err != nil {
-		database.DB.Model(&argument).Update("status", "failed")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate judgment"})
+		ac.Pipeline.RecordStage(ctx, argument.ID, "failed", services.StageFailed)
+		apierror.Abort(c, apierror.New(apierror.JudgmentFailed, "Failed to generate judgment").Wrap(err))
 		return
 	}
 
@@ -319,23 +852,72 @@ func CreateArgumentByScreenshot(c *gin.Context) {
 		ConversationHealthScore: result.ConversationHealthScore,
 	}
 
-	if err := database.DB.Create(&judgment).Error; err != nil {
-		database.DB.Model(&argument).Update("status", "failed")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save judgment"})
+	if err := ac.Judgments.Create(ctx, &judgment); err != nil {
+		ac.Pipeline.RecordStage(ctx, argument.ID, "failed", services.StageFailed)
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to save judgment").Wrap(err))
 		return
 	}
 
-	database.DB.Model(&argument).Update("status", "complete")
+	ac.Pipeline.RecordStage(ctx, argument.ID, "complete", services.StageComplete)
 
 	// Return full argument with judgment
-	c.JSON(http.StatusCreated, gin.H{
-		"id":            argument.ID,
-		"user_id":       argument.UserID,
-		"person_a_name": argument.PersonAName,
-		"person_b_name": argument.PersonBName,
-		"persona":       argument.Persona,
-		"status":        "complete",
-		"judgment":      judgment,
-		"created_at":    argument.CreatedAt,
-	})
+	argument.Status = "complete"
+	argument.Judgment = &judgment
+
+	c.JSON(http.StatusCreated, dto.NewArgument(argument))
+}
+
+// screenRecordingFrames turns a screen recording of a chat into the
+// screenshots that show it, held to the same limits as uploaded ones. The
+// caller removes the saved recording.
+func (ac *ArgumentController) screenRecordingFrames(c *gin.Context, video *multipart.FileHeader) ([]services.Screenshot, string, bool) {
+	if video.Size > config.App.MaxScreenRecordingBytes {
+		apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("Video too large (max %dMB)", config.App.MaxScreenRecordingBytes>>20)).
+			WithDetails(gin.H{"field": "video", "max_bytes": config.App.MaxScreenRecordingBytes}))
+		return nil, "", false
+	}
+
+	ctx := c.Request.Context()
+
+	videoPath, err := services.SaveUploadedFile(ctx, video)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.MediaProcessingFailed, "Failed to save upload").Wrap(err))
+		return nil, "", false
+	}
+
+	frames, err := ac.Frames.ExtractFrames(ctx, videoPath, time.Duration(config.App.MaxScreenRecordingDuration))
+	if err == nil {
+		frames, err = services.DistinctFrames(ctx, frames)
+	}
+	if err != nil {
+		_ = os.Remove(videoPath)
+		abortMediaError(c, err, "video")
+		return nil, "", false
+	}
+
+	if len(frames) == 0 {
+		_ = os.Remove(videoPath)
+		apierror.Abort(c, apierror.New(apierror.UnsupportedMedia, "No frames could be read from the video").WithDetails(gin.H{"field": "video"}))
+		return nil, "", false
+	}
+
+	if len(frames) > maxScreenshotsPerRequest {
+		_ = os.Remove(videoPath)
+		apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("The video shows more than %d screens of chat; record less of it or send screenshots", maxScreenshotsPerRequest)).
+			WithDetails(gin.H{"field": "video", "max_files": maxScreenshotsPerRequest, "frames": len(frames)}))
+		return nil, "", false
+	}
+
+	for _, frame := range frames {
+		if int64(len(frame.Data)) > config.App.MaxScreenshotBytes {
+			_ = os.Remove(videoPath)
+			apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("Each frame must be under %dMB", config.App.MaxScreenshotBytes>>20)).
+				WithDetails(gin.H{"field": "video", "max_bytes": config.App.MaxScreenshotBytes}))
+			return nil, "", false
+		}
+	}
+
+	slog.InfoContext(ctx, "screen recording split into screenshots", "frames", len(frames))
+
+	return frames, videoPath, true
 }
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetryMillis       = 3000
+)
+
+// GetArgumentEvents streams status and stage transitions for an argument as
+// Server-Sent Events. Clients reconnecting with Last-Event-ID receive the
+// events they missed before the live stream resumes.
+func (ac *ArgumentController) GetArgumentEvents(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	id, ok := parseID(c, "id")
+	if !ok {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	argument, err := ac.Arguments.FindForUser(ctx, id, userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.ArgumentNotFound, "Argument not found"))
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	var lastSent uint
+	resuming := lastEventID != ""
+	if resuming {
+		parsed, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid Last-Event-ID").WithDetails(gin.H{"field": "Last-Event-ID"}))
+			return
+		}
+		lastSent = uint(parsed)
+	}
+
+	// Subscribe before replaying so nothing falls between the two
+	live, unsubscribe := ac.Events.Subscribe(argument.ID)
+	defer unsubscribe()
+
+	backlog, err := ac.eventsSince(ctx, argument.ID, lastSent)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to load events").Wrap(err))
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetryMillis)
+
+	// Arguments created before events were recorded have no history, so
+	// start fresh streams with a snapshot of the current status.
+	if len(backlog) == 0 && !resuming {
+		writeSSE(c, "", "status", gin.H{
+			"argument_id": argument.ID,
+			"status":      argument.Status,
+		})
+		if argument.Status == "complete" || argument.Status == "failed" {
+			return
+		}
+	}
+
+	for _, event := range backlog {
+		writeArgumentEvent(c, event)
+		lastSent = event.ID
+		if event.Terminal() {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ac.Pipeline.Jobs.Draining():
+			// The client reconnects elsewhere with Last-Event-ID
+			return
+
+		case event := <-live:
+			if event.ID <= lastSent {
+				continue
+			}
+			writeArgumentEvent(c, event)
+			lastSent = event.ID
+			if event.Terminal() {
+				return
+			}
+
+		case <-heartbeat.C:
+			extendWriteDeadline(c)
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+
+			// Catch up on anything the listener missed while reconnecting
+			missed, err := ac.eventsSince(ctx, argument.ID, lastSent)
+			if err != nil {
+				continue
+			}
+			for _, event := range missed {
+				writeArgumentEvent(c, event)
+				lastSent = event.ID
+				if event.Terminal() {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ac *ArgumentController) eventsSince(ctx context.Context, argumentID uint, afterID uint) ([]services.ArgumentEvent, error) {
+	rows, err := ac.Arguments.EventsSince(ctx, argumentID, afterID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]services.ArgumentEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, services.NewArgumentEvent(row))
+	}
+
+	return events, nil
+}
+
+// extendWriteDeadline keeps the server's WriteTimeout from cutting off a
+// stream that is still sending heartbeats.
+func extendWriteDeadline(c *gin.Context) {
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(2 * sseHeartbeatInterval))
+}
+
+func writeArgumentEvent(c *gin.Context, event services.ArgumentEvent) {
+	writeSSE(c, strconv.FormatUint(uint64(event.ID), 10), "status", event)
+}
+
+func writeSSE(c *gin.Context, id string, name string, data interface{}) {
+	payload, _ := json.Marshal(data)
+
+	extendWriteDeadline(c)
+
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, payload)
+	c.Writer.Flush()
+}
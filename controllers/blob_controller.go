@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+// BlobController serves the signed URLs of the local blob store. With the
+// S3 backend the URLs point at the bucket and this route finds nothing.
+type BlobController struct {
+	Local *services.LocalBlobStore
+}
+
+func NewBlobController(blobs services.BlobStore) *BlobController {
+	local, _ := blobs.(*services.LocalBlobStore)
+	return &BlobController{Local: local}
+}
+
+func (bc *BlobController) DownloadBlob(c *gin.Context) {
+	if bc.Local == nil {
+		apierror.Abort(c, apierror.New(apierror.NotFound, "Not found"))
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	if !bc.Local.Verify(key, c.Query("expires"), c.Query("signature")) {
+		apierror.Abort(c, apierror.New(apierror.InvalidSignature, "Link is invalid or has expired"))
+		return
+	}
+
+	// ServeFile answers a missing file with a plain-text 404, outside the
+	// error envelope
+	path, err := bc.Local.File(key)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err == nil && info.IsDir() {
+			err = os.ErrNotExist
+		}
+	}
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.NotFound, "Not found"))
+		return
+	}
+
+	// The URL is a bearer credential; keep it out of shared caches
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Header("Content-Disposition", "inline")
+	http.ServeFile(c.Writer, c.Request, path)
+}
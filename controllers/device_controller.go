@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/dto"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// APNs device tokens are hex strings (64 chars today, longer is allowed)
+var deviceTokenPattern = regexp.MustCompile(`^[0-9a-f]{64,200}$`)
+
+type DeviceController struct {
+	Devices repository.DeviceRepo
+	Users   repository.UserRepo
+}
+
+func NewDeviceController(repos *repository.Repositories) *DeviceController {
+	return &DeviceController{
+		Devices: repos.Devices,
+		Users:   repos.Users,
+	}
+}
+
+func (dc *DeviceController) RegisterDevice(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	var input dto.RegisterDeviceRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid request body"))
+		return
+	}
+
+	token := strings.ToLower(strings.TrimSpace(input.Token))
+	if !deviceTokenPattern.MatchString(token) {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid device token").WithDetails(gin.H{"field": "token"}))
+		return
+	}
+
+	environment := input.Environment
+	if environment == "" {
+		environment = "production"
+	}
+	if environment != "production" && environment != "sandbox" {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "environment must be production or sandbox").WithDetails(gin.H{"field": "environment"}))
+		return
+	}
+
+	// A token belongs to one install; re-registering moves it to this user
+	device, err := dc.Devices.Register(c.Request.Context(), userID.(uint), token, environment)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to register device").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.NewDevice(*device))
+}
+
+func (dc *DeviceController) DeleteDevice(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	token := strings.ToLower(c.Param("token"))
+
+	if err := dc.Devices.DeleteForUser(c.Request.Context(), token, userID.(uint)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Abort(c, apierror.New(apierror.DeviceNotFound, "Device not found"))
+			return
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to delete device").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: "Device deleted successfully"})
+}
+
+func (dc *DeviceController) GetNotificationPreferences(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	user, err := dc.Users.FindByID(c.Request.Context(), userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewNotificationPreferences(*user))
+}
+
+func (dc *DeviceController) UpdateNotificationPreferences(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	var input dto.UpdateNotificationPreferencesRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid request body"))
+		return
+	}
+
+	// Nil preferences are left unchanged; false is written
+	user, err := dc.Users.UpdateNotificationPreferences(c.Request.Context(), userID.(uint), input.NotifyOnComplete, input.NotifyOnFailure)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
+			return
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to update preferences").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewNotificationPreferences(*user))
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/gin-gonic/gin"
+)
+
+// GetErrorCodes lists every error code the API can return so clients can
+// keep their mapping in sync
+func GetErrorCodes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"codes": apierror.Catalog(),
+	})
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct {
+	// Ping reports whether the database is reachable
+	Ping func(ctx context.Context) error
+	Jobs *services.JobTracker
+}
+
+func NewHealthController(ping func(ctx context.Context) error, jobs *services.JobTracker) *HealthController {
+	return &HealthController{Ping: ping, Jobs: jobs}
+}
+
+// Healthz is the liveness probe. It answers 200 while the process is up
+// and reports dependency state for humans.
+func (hc *HealthController) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "ok",
+		"database":      hc.databaseState(c.Request.Context()),
+		"draining":      hc.Jobs.IsDraining(),
+		"jobs_inflight": hc.Jobs.ActiveCount(),
+	})
+}
+
+// Readyz is the readiness probe. It fails while draining or when the
+// database is unreachable so load balancers stop routing here.
+func (hc *HealthController) Readyz(c *gin.Context) {
+	if hc.Jobs.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	if state := hc.databaseState(c.Request.Context()); state != "up" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":   "unavailable",
+			"database": state,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "ready",
+		"database": "up",
+	})
+}
+
+func (hc *HealthController) databaseState(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if err := hc.Ping(ctx); err != nil {
+		return "down"
+	}
+
+	return "up"
+}
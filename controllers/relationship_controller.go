@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/dto"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+type RelationshipController struct {
+	Relationships repository.RelationshipRepo
+	Arguments     repository.ArgumentRepo
+}
+
+func NewRelationshipController(repos *repository.Repositories) *RelationshipController {
+	return &RelationshipController{
+		Relationships: repos.Relationships,
+		Arguments:     repos.Arguments,
+	}
+}
+
+func (rc *RelationshipController) CreateRelationship(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	var input dto.CreateRelationshipRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid request body"))
+		return
+	}
+
+	personAName := strings.TrimSpace(input.PersonAName)
+	personBName := strings.TrimSpace(input.PersonBName)
+
+	if personAName == "" || personBName == "" {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Person names are required").WithDetails(gin.H{"field": "person_a_name"}))
+		return
+	}
+
+	relationship := models.Relationship{
+		UserID:      userID.(uint),
+		Label:       strings.TrimSpace(input.Label),
+		PersonAName: personAName,
+		PersonBName: personBName,
+	}
+
+	if err := rc.Relationships.Create(c.Request.Context(), &relationship); err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create relationship").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.NewRelationship(relationship))
+}
+
+func (rc *RelationshipController) GetRelationships(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	relationships, err := rc.Relationships.ListForUser(c.Request.Context(), userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to fetch relationships").Wrap(err))
+		return
+	}
+
+	response := make([]dto.Relationship, 0, len(relationships))
+	for _, relationship := range relationships {
+		response = append(response, dto.NewRelationship(relationship))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (rc *RelationshipController) GetRelationshipByID(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	relationship, ok := rc.findRelationship(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewRelationship(*relationship))
+}
+
+func (rc *RelationshipController) DeleteRelationship(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	relationship, ok := rc.findRelationship(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	// Arguments are kept; their relationship_id is set to NULL
+	if err := rc.Relationships.Delete(c.Request.Context(), relationship.ID); err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to delete relationship").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: "Relationship deleted successfully"})
+}
+
+func (rc *RelationshipController) GetRelationshipTrends(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	window := services.DefaultTrendWindow
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 50 {
+			apierror.Abort(c, apierror.New(apierror.InvalidRequest, "window must be between 1 and 50").WithDetails(gin.H{"field": "window"}))
+			return
+		}
+		window = parsed
+	}
+
+	relationship, ok := rc.findRelationship(c, userID.(uint))
+	if !ok {
+		return
+	}
+
+	arguments, err := rc.Arguments.ListJudged(c.Request.Context(), relationship.ID, userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to fetch arguments").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, services.BuildRelationshipTrends(*relationship, arguments, window))
+}
+
+func (rc *RelationshipController) findRelationship(c *gin.Context, userID uint) (*models.Relationship, bool) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		apierror.Abort(c, apierror.New(apierror.RelationshipNotFound, "Relationship not found"))
+		return nil, false
+	}
+
+	relationship, err := rc.Relationships.FindForUser(c.Request.Context(), id, userID)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.RelationshipNotFound, "Relationship not found"))
+		return nil, false
+	}
+
+	return relationship, true
+}
@@ -1,12 +1,15 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 
-	"github.com/calebchiang/thirdparty_server/database"
-	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/metrics"
+	"github.com/calebchiang/thirdparty_server/repository"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,31 +20,46 @@ type RevenueCatWebhookPayload struct {
 	} `json:"event"`
 }
 
-func RevenueCatWebhook(c *gin.Context) {
+type RevenueCatController struct {
+	Users   repository.UserRepo
+	Credits repository.CreditLedger
+}
+
+func NewRevenueCatController(repos *repository.Repositories) *RevenueCatController {
+	return &RevenueCatController{
+		Users:   repos.Users,
+		Credits: repos.Credits,
+	}
+}
+
+func (rc *RevenueCatController) RevenueCatWebhook(c *gin.Context) {
+
+	ctx := c.Request.Context()
 
 	var payload RevenueCatWebhookPayload
 
 	// Parse JSON body
 	if err := c.ShouldBindJSON(&payload); err != nil {
-		fmt.Println("❌ Failed to parse RevenueCat webhook:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		slog.WarnContext(ctx, "revenuecat webhook: invalid payload", "error", err)
+		apierror.Abort(c, apierror.New(apierror.InvalidWebhook, "Invalid webhook payload"))
 		return
 	}
 
 	eventType := payload.Event.Type
 	appUserID := payload.Event.AppUserID
 
-	fmt.Println("====================================")
-	fmt.Println("🔥 REVENUECAT WEBHOOK RECEIVED")
-	fmt.Println("Event Type:", eventType)
-	fmt.Println("App User ID:", appUserID)
-	fmt.Println("====================================")
+	metrics.RecordWebhookEvent(eventType)
+
+	slog.InfoContext(ctx, "revenuecat webhook received",
+		"event_type", eventType,
+		"app_user_id", appUserID,
+	)
 
 	// Convert user id from string -> int
 	userIDInt, err := strconv.Atoi(appUserID)
 	if err != nil {
-		fmt.Println("❌ Invalid app_user_id:", appUserID)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		slog.WarnContext(ctx, "revenuecat webhook: invalid app_user_id", "app_user_id", appUserID)
+		apierror.Abort(c, apierror.New(apierror.InvalidWebhook, "Invalid user id"))
 		return
 	}
 
@@ -50,46 +68,40 @@ func RevenueCatWebhook(c *gin.Context) {
 	// Handle purchase events
 	if eventType == "INITIAL_PURCHASE" || eventType == "RENEWAL" {
 
-		var user models.User
-
-		if err := database.DB.First(&user, userID).Error; err != nil {
-			fmt.Println("❌ User not found for RevenueCat webhook:", userID)
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		err := rc.Users.SetPremium(ctx, userID, true)
+		if err == nil {
+			err = rc.Credits.SetBalance(ctx, userID, config.App.PremiumCredits)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			slog.WarnContext(ctx, "revenuecat webhook: user not found", "user_id", userID, "event_type", eventType)
+			apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
 			return
 		}
-
-		user.IsPremium = true
-		user.Credits = 20
-
-		if err := database.DB.Save(&user).Error; err != nil {
-			fmt.Println("❌ Failed to update user:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		if err != nil {
+			slog.ErrorContext(ctx, "revenuecat webhook: failed to upgrade user", "user_id", userID, "error", err)
+			apierror.Abort(c, apierror.New(apierror.Internal, "Failed to update user").Wrap(err))
 			return
 		}
 
-		fmt.Println("✅ User upgraded to premium:", userID)
+		slog.InfoContext(ctx, "user upgraded to premium", "user_id", userID)
 	}
 
 	// Handle expiration (optional but recommended)
 	if eventType == "EXPIRATION" {
 
-		var user models.User
-
-		if err := database.DB.First(&user, userID).Error; err != nil {
-			fmt.Println("❌ User not found for expiration:", userID)
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		err := rc.Users.SetPremium(ctx, userID, false)
+		if errors.Is(err, repository.ErrNotFound) {
+			slog.WarnContext(ctx, "revenuecat webhook: user not found", "user_id", userID, "event_type", eventType)
+			apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
 			return
 		}
-
-		user.IsPremium = false
-
-		if err := database.DB.Save(&user).Error; err != nil {
-			fmt.Println("❌ Failed to update expiration:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		if err != nil {
+			slog.ErrorContext(ctx, "revenuecat webhook: failed to expire premium", "user_id", userID, "error", err)
+			apierror.Abort(c, apierror.New(apierror.Internal, "Failed to update user").Wrap(err))
 			return
 		}
 
-		fmt.Println("⚠️ User premium expired:", userID)
+		slog.InfoContext(ctx, "user premium expired", "user_id", userID)
 	}
 
 	// Always respond OK to RevenueCat
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+// The upload endpoints speak tus 1.0.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, creation-with-upload, termination and expiration
+// extensions. A finished upload is turned into an argument by passing its
+// ID to POST /arguments as upload_id.
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-with-upload,termination,expiration"
+	tusContentType = "application/offset+octet-stream"
+)
+
+type UploadController struct {
+	Uploads *services.UploadStore
+	Credits repository.CreditLedger
+}
+
+func NewUploadController(repos *repository.Repositories, uploads *services.UploadStore) *UploadController {
+	return &UploadController{
+		Uploads: uploads,
+		Credits: repos.Credits,
+	}
+}
+
+// RequireTusResumable rejects clients speaking another protocol version
+func (uc *UploadController) RequireTusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		apierror.Abort(c, apierror.New(apierror.UnsupportedTusVersion, "Tus-Resumable must be "+tusVersion))
+		return
+	}
+
+	c.Next()
+}
+
+// Options advertises what the server supports; it needs no auth
+func (uc *UploadController) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(config.App.MaxResumableUploadBytes, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (uc *UploadController) CreateUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Upload-Length must be a positive integer").WithDetails(gin.H{"field": "Upload-Length"}))
+		return
+	}
+
+	if length > config.App.MaxResumableUploadBytes {
+		apierror.Abort(c, apierror.New(apierror.UploadTooLarge, fmt.Sprintf("Upload too large (max %dMB)", config.App.MaxResumableUploadBytes>>20)).
+			WithDetails(gin.H{"field": "Upload-Length", "max_bytes": config.App.MaxResumableUploadBytes}))
+		return
+	}
+
+	metadata := c.GetHeader("Upload-Metadata")
+	if !validUploadMetadata(metadata) {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Upload-Metadata must be comma separated keys with base64 values").WithDetails(gin.H{"field": "Upload-Metadata"}))
+		return
+	}
+
+	// Don't take a long upload from someone who can't turn it into an argument
+	if !checkCredits(c, uc.Credits, userID.(uint)) {
+		return
+	}
+
+	upload, err := uc.Uploads.Create(userID.(uint), length, metadata)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create upload").Wrap(err))
+		return
+	}
+
+	c.Header("Location", "/uploads/"+upload.ID)
+
+	// creation-with-upload: the first chunk may come with the request
+	if c.ContentType() == tusContentType && c.Request.ContentLength != 0 {
+		upload, err = uc.Uploads.Append(c.Request.Context(), upload.ID, userID.(uint), 0, c.Request.Body)
+		if err != nil {
+			abortUploadError(c, upload, err)
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+func (uc *UploadController) GetUploadOffset(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	upload, err := uc.Uploads.Get(c.Param("id"), userID.(uint))
+	if err != nil {
+		abortUploadError(c, upload, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+func (uc *UploadController) AppendUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		apierror.Abort(c, apierror.New(apierror.UnsupportedMedia, "Content-Type must be "+tusContentType))
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Upload-Offset must be a non-negative integer").WithDetails(gin.H{"field": "Upload-Offset"}))
+		return
+	}
+
+	upload, err := uc.Uploads.Append(c.Request.Context(), c.Param("id"), userID.(uint), offset, c.Request.Body)
+	if err != nil {
+		abortUploadError(c, upload, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+func (uc *UploadController) DeleteUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
+		return
+	}
+
+	if err := uc.Uploads.Delete(c.Param("id"), userID.(uint)); err != nil {
+		abortUploadError(c, nil, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// abortUploadError maps store errors to the statuses tus clients expect.
+// upload, when known, lets the client see where to resume.
+func abortUploadError(c *gin.Context, upload *services.Upload, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		apierror.Abort(c, apierror.New(apierror.UploadNotFound, "Upload not found"))
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		apierror.Abort(c, apierror.New(apierror.UploadOffsetMismatch, "Upload-Offset does not match the upload").
+			WithDetails(gin.H{"offset": upload.Offset}))
+	case errors.Is(err, services.ErrUploadLocked):
+		apierror.Abort(c, apierror.New(apierror.UploadLocked, "Upload is being written by another request"))
+	case upload != nil:
+		// The body broke off; whatever arrived is kept
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Upload interrupted").
+			WithDetails(gin.H{"offset": upload.Offset}).Wrap(err))
+	default:
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to write upload").Wrap(err))
+	}
+}
+
+// validUploadMetadata checks the Upload-Metadata format: "key value" pairs
+// separated by commas, where the value is base64 and may be left out
+func validUploadMetadata(header string) bool {
+	if header == "" {
+		return true
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " ,") {
+			return false
+		}
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
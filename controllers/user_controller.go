@@ -1,33 +1,40 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/calebchiang/thirdparty_server/database"
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/dto"
 	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func CreateUser(c *gin.Context) {
-	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+type UserController struct {
+	Users repository.UserRepo
+}
+
+func NewUserController(users repository.UserRepo) *UserController {
+	return &UserController{Users: users}
+}
+
+func (uc *UserController) CreateUser(c *gin.Context) {
+	var input dto.CreateUserRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid request body"))
 		return
 	}
 
 	// Basic validation
 	if input.Email == "" || input.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password required"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Email and password required"))
 		return
 	}
 
@@ -37,7 +44,7 @@ func CreateUser(c *gin.Context) {
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to hash password").Wrap(err))
 		return
 	}
 
@@ -47,130 +54,92 @@ func CreateUser(c *gin.Context) {
 		Password: string(hashedPassword),
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
-		if strings.Contains(err.Error(), "duplicate key") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+	if err := uc.Users.Create(c.Request.Context(), &user); err != nil {
+		if errors.Is(err, repository.ErrEmailTaken) {
+			apierror.Abort(c, apierror.New(apierror.EmailTaken, "Email already exists"))
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to create user").Wrap(err))
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":    user.ID,
-		"name":  user.Name,
-		"email": user.Email,
-	})
+	c.JSON(http.StatusCreated, dto.NewUser(user))
 }
 
-func LoginUser(c *gin.Context) {
-	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+func (uc *UserController) LoginUser(c *gin.Context) {
+	var input dto.LoginRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Invalid request body"))
 		return
 	}
 
 	if input.Email == "" || input.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password required"})
+		apierror.Abort(c, apierror.New(apierror.InvalidRequest, "Email and password required"))
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	user, err := uc.Users.FindByEmail(c.Request.Context(), input.Email)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.InvalidCredentials, "Invalid email or password"))
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		apierror.Abort(c, apierror.New(apierror.InvalidCredentials, "Invalid email or password"))
 		return
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "JWT secret not configured"})
+	tokenString, err := signToken(user.ID)
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to generate token").Wrap(err))
 		return
 	}
 
+	c.JSON(http.StatusOK, dto.TokenResponse{Token: tokenString})
+}
+
+// signToken issues the session JWT returned by every login flow
+func signToken(userID uint) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(30 * 24 * time.Hour).Unix(),
+		"user_id": userID,
+		"exp":     time.Now().Add(time.Duration(config.App.TokenTTL)).Unix(),
 	})
 
-	tokenString, err := token.SignedString([]byte(secret))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-	})
+	return token.SignedString([]byte(config.App.JWTSecret))
 }
 
-func GetCurrentUser(c *gin.Context) {
+func (uc *UserController) GetCurrentUser(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized",
-		})
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
 		return
 	}
 
-	var user models.User
-
-	if err := database.DB.
-		Select("id, name, email, credits, is_premium").
-		Where("id = ?", userID.(uint)).
-		First(&user).Error; err != nil {
-
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
+	user, err := uc.Users.FindByID(c.Request.Context(), userID.(uint))
+	if err != nil {
+		apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":        user.ID,
-		"name":      user.Name,
-		"email":     user.Email,
-		"credits":   user.Credits,
-		"isPremium": user.IsPremium,
-	})
+	c.JSON(http.StatusOK, dto.NewUser(*user))
 }
 
-func DeleteCurrentUser(c *gin.Context) {
+func (uc *UserController) DeleteCurrentUser(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized",
-		})
+		apierror.Abort(c, apierror.New(apierror.Unauthorized, "Unauthorized"))
 		return
 	}
 
-	var user models.User
-
-	// Make sure user exists
-	if err := database.DB.First(&user, userID.(uint)).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "User not found",
-		})
-		return
-	}
-
-	// Delete user
-	if err := database.DB.Delete(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to delete user",
-		})
+	if err := uc.Users.Delete(c.Request.Context(), userID.(uint)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Abort(c, apierror.New(apierror.UserNotFound, "User not found"))
+			return
+		}
+		apierror.Abort(c, apierror.New(apierror.Internal, "Failed to delete user").Wrap(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "User deleted successfully",
-	})
+	c.JSON(http.StatusOK, dto.MessageResponse{Message: "User deleted successfully"})
 }
@@ -1,9 +1,8 @@
 package database
 
 import (
-	"fmt"
 	"log"
-	"os"
+	"log/slog"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -11,12 +10,7 @@ import (
 
 var DB *gorm.DB
 
-func Connect() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL not set in environment")
-	}
-
+func Connect(dsn string) {
 	var err error
 	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -33,5 +27,5 @@ func Connect() {
 		log.Fatal("Database ping failed:", err)
 	}
 
-	fmt.Println("Connected to PostgreSQL successfully")
+	slog.Info("connected to PostgreSQL")
 }
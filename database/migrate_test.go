@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The models as they were when AutoMigrate created the production schema
+
+type baselineUser struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"not null"`
+	Email     string `gorm:"uniqueIndex;not null"`
+	Password  string `gorm:"not null"`
+	Credits   int    `gorm:"not null;default:1"`
+	IsPremium bool   `gorm:"not null;default:false"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineArgument struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        uint   `gorm:"not null;index"`
+	PersonAName   string `gorm:"type:varchar(255);not null"`
+	PersonBName   string `gorm:"type:varchar(255);not null"`
+	Persona       string `gorm:"type:varchar(50);not null;default:'mediator'"`
+	Transcription string `gorm:"type:text;not null"`
+	Status        string `gorm:"type:varchar(20);default:'processing'"`
+	CreatedAt     time.Time
+
+	User     baselineUser      `gorm:"foreignKey:UserID"`
+	Judgment *baselineJudgment `gorm:"foreignKey:ArgumentID;constraint:OnDelete:CASCADE"`
+}
+
+func (baselineArgument) TableName() string { return "arguments" }
+
+type baselineJudgment struct {
+	ID           uint   `gorm:"primaryKey"`
+	ArgumentID   uint   `gorm:"not null;uniqueIndex;index"`
+	Winner       string `gorm:"type:varchar(20);not null"`
+	Reasoning    string `gorm:"type:text;not null"`
+	FullResponse string `gorm:"type:text;not null"`
+
+	Respect              int `gorm:"not null"`
+	Empathy              int `gorm:"not null"`
+	Accountability       int `gorm:"not null"`
+	EmotionalRegulation  int `gorm:"not null"`
+	ManipulationToxicity int `gorm:"not null"`
+
+	ConversationHealthScore int `gorm:"not null"`
+	CreatedAt               time.Time
+}
+
+func (baselineJudgment) TableName() string { return "judgments" }
+
+// useTestSchema points DB at a new, empty schema of TEST_DATABASE_URL and
+// drops it when the test ends
+func useTestSchema(t *testing.T) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connConfig.RuntimeParams["search_path"] = schema
+
+	sqlDB := stdlib.OpenDB(*connConfig)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	previous := DB
+	DB, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB = previous })
+}
+
+func TestMigrateUpAdoptsBaselineSchema(t *testing.T) {
+	useTestSchema(t)
+	ctx := context.Background()
+
+	if err := DB.AutoMigrate(&baselineUser{}, &baselineArgument{}, &baselineJudgment{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := baselineUser{Name: "Sam", Email: "sam@example.com", Password: "hash", Credits: 3}
+	if err := DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&baselineArgument{UserID: user.ID, PersonAName: "Sam", PersonBName: "Alex", Transcription: "We need to talk."}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("MigrateUp() on the baseline schema: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	// Existing rows take the defaults of the added columns
+	var migrated models.User
+	if err := DB.First(&migrated, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !migrated.NotifyOnComplete || !migrated.NotifyOnFailure || migrated.Credits != 3 {
+		t.Fatalf("migrated user = %+v", migrated)
+	}
+
+	// The current models read and write the migrated tables
+	relationship := models.Relationship{UserID: user.ID, PersonAName: "Sam", PersonBName: "Alex"}
+	if err := DB.Create(&relationship).Error; err != nil {
+		t.Fatal(err)
+	}
+	argument := models.Argument{UserID: user.ID, RelationshipID: &relationship.ID, PersonAName: "Sam", PersonBName: "Alex", Persona: "mediator"}
+	if err := DB.Create(&argument).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&models.ArgumentEvent{ArgumentID: argument.ID, Status: "processing", Stage: "queued"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&models.Device{UserID: user.ID, Token: "device-token"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var arguments []models.Argument
+	if err := DB.Where("user_id = ?", user.ID).Find(&arguments).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(arguments) != 2 {
+		t.Fatalf("found %d arguments, want 2", len(arguments))
+	}
+}
+
+func TestMigrateUpAndDownOnEmptySchema(t *testing.T) {
+	useTestSchema(t)
+	ctx := context.Background()
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		if _, err := MigrateUp(ctx); err != nil {
+			t.Fatalf("MigrateUp(): %v", err)
+		}
+		reverted, err := MigrateDown(ctx, len(migrations))
+		if err != nil {
+			t.Fatalf("MigrateDown(): %v", err)
+		}
+		if len(reverted) != len(migrations) {
+			t.Fatalf("reverted %d migrations, want %d", len(reverted), len(migrations))
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS judgments;
+DROP TABLE IF EXISTS arguments;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,47 @@
+-- Baseline schema: the tables AutoMigrate created before migrations were
+-- introduced. Every statement is guarded so databases that were set up by
+-- AutoMigrate adopt this migration without changes; what was added since
+-- comes in later migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+    id         bigserial PRIMARY KEY,
+    name       text        NOT NULL,
+    email      text        NOT NULL,
+    password   text        NOT NULL,
+    credits    bigint      NOT NULL DEFAULT 1,
+    is_premium boolean     NOT NULL DEFAULT false,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS arguments (
+    id            bigserial PRIMARY KEY,
+    user_id       bigint       NOT NULL CONSTRAINT fk_arguments_user REFERENCES users (id),
+    person_a_name varchar(255) NOT NULL,
+    person_b_name varchar(255) NOT NULL,
+    persona       varchar(50)  NOT NULL DEFAULT 'mediator',
+    transcription text         NOT NULL,
+    status        varchar(20)  DEFAULT 'processing',
+    created_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_arguments_user_id ON arguments (user_id);
+
+CREATE TABLE IF NOT EXISTS judgments (
+    id                        bigserial PRIMARY KEY,
+    argument_id               bigint      NOT NULL CONSTRAINT fk_arguments_judgment REFERENCES arguments (id) ON DELETE CASCADE,
+    winner                    varchar(20) NOT NULL,
+    reasoning                 text        NOT NULL,
+    full_response             text        NOT NULL,
+    respect                   bigint      NOT NULL,
+    empathy                   bigint      NOT NULL,
+    accountability            bigint      NOT NULL,
+    emotional_regulation      bigint      NOT NULL,
+    manipulation_toxicity     bigint      NOT NULL,
+    conversation_health_score bigint      NOT NULL,
+    created_at                timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_judgments_argument_id ON judgments (argument_id);
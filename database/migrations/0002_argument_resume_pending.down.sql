@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_arguments_resume_pending;
+
+ALTER TABLE arguments DROP COLUMN IF EXISTS resume_pending;
@@ -0,0 +1,5 @@
+-- Set when a shutdown interrupts a judgment; cleared by the instance that
+-- resumes it.
+ALTER TABLE arguments ADD COLUMN IF NOT EXISTS resume_pending boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_arguments_resume_pending ON arguments (resume_pending) WHERE resume_pending;
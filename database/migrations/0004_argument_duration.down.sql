@@ -0,0 +1 @@
+ALTER TABLE arguments DROP COLUMN IF EXISTS duration_seconds;
@@ -0,0 +1,3 @@
+-- Length of the uploaded recording as measured by ffprobe. NULL for
+-- screenshot arguments and those created before it was recorded.
+ALTER TABLE arguments ADD COLUMN IF NOT EXISTS duration_seconds double precision;
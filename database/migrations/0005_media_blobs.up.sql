@@ -0,0 +1,16 @@
+-- Original recordings and screenshots kept in the blob store. Deleting an
+-- argument only detaches its rows; the retention sweeper removes the
+-- objects and then the rows, so no object is left without a row.
+CREATE TABLE IF NOT EXISTS media_blobs (
+    id           bigserial PRIMARY KEY,
+    argument_id  bigint       REFERENCES arguments (id) ON DELETE SET NULL,
+    kind         varchar(20)  NOT NULL,
+    key          text         NOT NULL UNIQUE,
+    content_type varchar(100) NOT NULL,
+    size_bytes   bigint       NOT NULL,
+    position     integer      NOT NULL DEFAULT 0,
+    created_at   timestamptz  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_blobs_argument_id ON media_blobs (argument_id);
+CREATE INDEX IF NOT EXISTS idx_media_blobs_created_at ON media_blobs (created_at);
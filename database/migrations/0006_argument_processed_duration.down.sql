@@ -0,0 +1 @@
+ALTER TABLE arguments DROP COLUMN IF EXISTS processed_duration_seconds;
@@ -0,0 +1,4 @@
+-- Length of the audio sent for transcription, after pauses were trimmed.
+-- Transcript times refer to it; with duration_seconds it shows how much
+-- preprocessing saves. NULL for screenshot arguments and older ones.
+ALTER TABLE arguments ADD COLUMN IF NOT EXISTS processed_duration_seconds double precision;
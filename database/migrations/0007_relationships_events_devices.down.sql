@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS devices;
+
+ALTER TABLE users DROP COLUMN IF EXISTS notify_on_failure;
+ALTER TABLE users DROP COLUMN IF EXISTS notify_on_complete;
+
+DROP TABLE IF EXISTS argument_events;
+
+DROP INDEX IF EXISTS idx_judgments_reasoning_fts;
+DROP INDEX IF EXISTS idx_arguments_transcription_fts;
+
+ALTER TABLE arguments DROP COLUMN IF EXISTS relationship_id;
+
+DROP TABLE IF EXISTS relationships;
@@ -0,0 +1,52 @@
+-- Saved relationships, search indexes, argument events, devices and
+-- notification preferences, which AutoMigrate added after the baseline.
+-- Databases still on the baseline get them here; ones that already have
+-- them are left as they are.
+
+CREATE TABLE IF NOT EXISTS relationships (
+    id            bigserial PRIMARY KEY,
+    user_id       bigint       NOT NULL CONSTRAINT fk_relationships_user REFERENCES users (id),
+    label         varchar(255),
+    person_a_name varchar(255) NOT NULL,
+    person_b_name varchar(255) NOT NULL,
+    created_at    timestamptz,
+    updated_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_relationships_user_id ON relationships (user_id);
+
+ALTER TABLE arguments ADD COLUMN IF NOT EXISTS relationship_id bigint
+    CONSTRAINT fk_relationships_arguments REFERENCES relationships (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_arguments_relationship_id ON arguments (relationship_id);
+
+CREATE INDEX IF NOT EXISTS idx_arguments_transcription_fts
+    ON arguments USING GIN (to_tsvector('english', transcription));
+CREATE INDEX IF NOT EXISTS idx_judgments_reasoning_fts
+    ON judgments USING GIN (to_tsvector('english', reasoning));
+
+CREATE TABLE IF NOT EXISTS argument_events (
+    id          bigserial PRIMARY KEY,
+    argument_id bigint      NOT NULL CONSTRAINT fk_argument_events_argument REFERENCES arguments (id) ON DELETE CASCADE,
+    status      varchar(20) NOT NULL,
+    stage       varchar(40) NOT NULL,
+    created_at  timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_argument_events_argument_id ON argument_events (argument_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_on_complete boolean NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_on_failure boolean NOT NULL DEFAULT true;
+
+CREATE TABLE IF NOT EXISTS devices (
+    id          bigserial PRIMARY KEY,
+    user_id     bigint       NOT NULL CONSTRAINT fk_devices_user REFERENCES users (id) ON DELETE CASCADE,
+    token       varchar(200) NOT NULL,
+    platform    varchar(20)  NOT NULL DEFAULT 'ios',
+    environment varchar(20)  NOT NULL DEFAULT 'production',
+    created_at  timestamptz,
+    updated_at  timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_token ON devices (token);
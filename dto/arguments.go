@@ -0,0 +1,177 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+)
+
+// Judgment omits the judge's raw response, which is kept for debugging only.
+// It keeps the keys the model was serialized with, which the iOS client
+// reads.
+type Judgment struct {
+	ID                      uint      `json:"ID"`
+	ArgumentID              uint      `json:"ArgumentID"`
+	Winner                  string    `json:"Winner"`
+	Reasoning               string    `json:"Reasoning"`
+	Respect                 int       `json:"Respect"`
+	Empathy                 int       `json:"Empathy"`
+	Accountability          int       `json:"Accountability"`
+	EmotionalRegulation     int       `json:"EmotionalRegulation"`
+	ManipulationToxicity    int       `json:"ManipulationToxicity"`
+	ConversationHealthScore int       `json:"ConversationHealthScore"`
+	CreatedAt               time.Time `json:"CreatedAt"`
+}
+
+func NewJudgment(judgment models.Judgment) Judgment {
+	return Judgment{
+		ID:                      judgment.ID,
+		ArgumentID:              judgment.ArgumentID,
+		Winner:                  judgment.Winner,
+		Reasoning:               judgment.Reasoning,
+		Respect:                 judgment.Respect,
+		Empathy:                 judgment.Empathy,
+		Accountability:          judgment.Accountability,
+		EmotionalRegulation:     judgment.EmotionalRegulation,
+		ManipulationToxicity:    judgment.ManipulationToxicity,
+		ConversationHealthScore: judgment.ConversationHealthScore,
+		CreatedAt:               judgment.CreatedAt,
+	}
+}
+
+// Argument is returned when an argument is created. Judgment is null until
+// judging completes.
+type Argument struct {
+	ID              uint     `json:"id"`
+	UserID          uint     `json:"user_id"`
+	RelationshipID  *uint    `json:"relationship_id"`
+	PersonAName     string   `json:"person_a_name"`
+	PersonBName     string   `json:"person_b_name"`
+	Persona         string   `json:"persona"`
+	Transcription   string   `json:"transcription"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	// ProcessedDurationSeconds is the length of GET /arguments/{id}/audio,
+	// which transcript times refer to
+	ProcessedDurationSeconds *float64  `json:"processed_duration_seconds"`
+	Status                   string    `json:"status"`
+	Judgment                 *Judgment `json:"judgment"`
+	CreatedAt                time.Time `json:"created_at"`
+}
+
+func NewArgument(argument models.Argument) Argument {
+	response := Argument{
+		ID:              argument.ID,
+		UserID:          argument.UserID,
+		RelationshipID:  argument.RelationshipID,
+		PersonAName:     argument.PersonAName,
+		PersonBName:     argument.PersonBName,
+		Persona:         argument.Persona,
+		Transcription:   argument.Transcription,
+		DurationSeconds: argument.DurationSeconds,
+		Status:          argument.Status,
+		CreatedAt:       argument.CreatedAt,
+
+		ProcessedDurationSeconds: argument.ProcessedDurationSeconds,
+	}
+
+	if argument.Judgment != nil {
+		judgment := NewJudgment(*argument.Judgment)
+		response.Judgment = &judgment
+	}
+
+	return response
+}
+
+// ArgumentDetail is GET /arguments/{id}. Like Judgment it keeps the keys of
+// the model the endpoint used to return.
+type ArgumentDetail struct {
+	ID                       uint      `json:"ID"`
+	UserID                   uint      `json:"UserID"`
+	RelationshipID           *uint     `json:"RelationshipID"`
+	PersonAName              string    `json:"PersonAName"`
+	PersonBName              string    `json:"PersonBName"`
+	Persona                  string    `json:"Persona"`
+	Transcription            string    `json:"Transcription"`
+	DurationSeconds          *float64  `json:"DurationSeconds"`
+	ProcessedDurationSeconds *float64  `json:"ProcessedDurationSeconds"`
+	Status                   string    `json:"Status"`
+	CreatedAt                time.Time `json:"CreatedAt"`
+	Judgment                 *Judgment `json:"Judgment"`
+}
+
+func NewArgumentDetail(argument models.Argument) ArgumentDetail {
+	response := ArgumentDetail{
+		ID:                       argument.ID,
+		UserID:                   argument.UserID,
+		RelationshipID:           argument.RelationshipID,
+		PersonAName:              argument.PersonAName,
+		PersonBName:              argument.PersonBName,
+		Persona:                  argument.Persona,
+		Transcription:            argument.Transcription,
+		DurationSeconds:          argument.DurationSeconds,
+		ProcessedDurationSeconds: argument.ProcessedDurationSeconds,
+		Status:                   argument.Status,
+		CreatedAt:                argument.CreatedAt,
+	}
+
+	if argument.Judgment != nil {
+		judgment := NewJudgment(*argument.Judgment)
+		response.Judgment = &judgment
+	}
+
+	return response
+}
+
+type CreateRelationshipRequest struct {
+	Label       string `json:"label"`
+	PersonAName string `json:"person_a_name"`
+	PersonBName string `json:"person_b_name"`
+}
+
+type Relationship struct {
+	ID          uint      `json:"id"`
+	Label       string    `json:"label"`
+	PersonAName string    `json:"person_a_name"`
+	PersonBName string    `json:"person_b_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MediaBlob is a stored original recording or screenshot. URL works without
+// credentials until url_expires_at; fetch the list again for a fresh one.
+type MediaBlob struct {
+	ID           uint      `json:"id"`
+	Kind         string    `json:"kind"`
+	ContentType  string    `json:"content_type"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Position     int       `json:"position"`
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ArgumentMedia struct {
+	Media []MediaBlob `json:"media"`
+}
+
+func NewMediaBlob(blob models.MediaBlob, url string, expiresAt time.Time) MediaBlob {
+	return MediaBlob{
+		ID:           blob.ID,
+		Kind:         blob.Kind,
+		ContentType:  blob.ContentType,
+		SizeBytes:    blob.SizeBytes,
+		Position:     blob.Position,
+		URL:          url,
+		URLExpiresAt: expiresAt,
+		CreatedAt:    blob.CreatedAt,
+	}
+}
+
+func NewRelationship(relationship models.Relationship) Relationship {
+	return Relationship{
+		ID:          relationship.ID,
+		Label:       relationship.Label,
+		PersonAName: relationship.PersonAName,
+		PersonBName: relationship.PersonBName,
+		CreatedAt:   relationship.CreatedAt,
+	}
+}
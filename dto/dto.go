@@ -0,0 +1,8 @@
+// Package dto holds the request and response bodies of the HTTP API. Handlers
+// never serialize models directly, so internal columns (password hashes, the
+// judge's raw response) cannot leak. Keep these in sync with openapi.json.
+package dto
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,85 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+)
+
+type CreateUserRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type AppleLoginRequest struct {
+	IdentityToken string `json:"identityToken"`
+}
+
+type TokenResponse struct {
+	Token string `json:"token"`
+}
+
+// User is the public view of an account. IsPremium keeps the camelCase key
+// the iOS client already reads.
+type User struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Credits   int    `json:"credits"`
+	IsPremium bool   `json:"isPremium"`
+}
+
+func NewUser(user models.User) User {
+	return User{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Credits:   user.Credits,
+		IsPremium: user.IsPremium,
+	}
+}
+
+type RegisterDeviceRequest struct {
+	Token       string `json:"token"`
+	Environment string `json:"environment"`
+}
+
+type Device struct {
+	ID          uint      `json:"id"`
+	Platform    string    `json:"platform"`
+	Environment string    `json:"environment"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewDevice(device models.Device) Device {
+	return Device{
+		ID:          device.ID,
+		Platform:    device.Platform,
+		Environment: device.Environment,
+		CreatedAt:   device.CreatedAt,
+	}
+}
+
+// Omitted fields are left unchanged
+type UpdateNotificationPreferencesRequest struct {
+	NotifyOnComplete *bool `json:"notify_on_complete"`
+	NotifyOnFailure  *bool `json:"notify_on_failure"`
+}
+
+type NotificationPreferences struct {
+	NotifyOnComplete bool `json:"notify_on_complete"`
+	NotifyOnFailure  bool `json:"notify_on_failure"`
+}
+
+func NewNotificationPreferences(user models.User) NotificationPreferences {
+	return NotificationPreferences{
+		NotifyOnComplete: user.NotifyOnComplete,
+		NotifyOnFailure:  user.NotifyOnFailure,
+	}
+}
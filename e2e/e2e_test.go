@@ -0,0 +1,835 @@
+// Package e2e_test drives the real router, handlers and judgment pipeline
+// against in-memory repositories and a fake OpenAI server.
+package e2e_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math/rand/v2"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/dto"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/openaifake"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/routes"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+)
+
+// copyMedia stands in for ffprobe and ffmpeg: every upload measures
+// duration seconds, of which trimming leaves processed, and is handed to
+// transcription as is, once per channel when stereo is set. Clips are
+// copies of the whole recording. Screen recordings yield frames.
+type copyMedia struct {
+	dir       string
+	duration  float64
+	processed float64
+	stereo    bool
+	frames    []services.Screenshot
+	cuts      atomic.Int32
+}
+
+func (m *copyMedia) Normalize(ctx context.Context, inputPath string, limits services.MediaLimits) (*services.NormalizedMedia, error) {
+	if err := limits.Check(m.duration); err != nil {
+		return nil, err
+	}
+
+	src, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(m.dir, "normalized-*.m4a")
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return nil, err
+	}
+
+	normalized := &services.NormalizedMedia{Path: dst.Name(), DurationSeconds: m.duration, ProcessedDurationSeconds: m.processed}
+	if m.stereo {
+		// Every channel is the same bytes; the transcriptions tell them apart
+		normalized.ChannelPaths = []string{dst.Name(), dst.Name()}
+	}
+	return normalized, nil
+}
+
+func (m *copyMedia) Cut(ctx context.Context, inputPath string, start float64, end float64) (string, error) {
+	m.cuts.Add(1)
+
+	contents, err := os.ReadFile(inputPath)
+	if err != nil {
+		return "", err
+	}
+
+	clipPath := filepath.Join(m.dir, fmt.Sprintf("clip-%d.m4a", m.cuts.Load()))
+	return clipPath, os.WriteFile(clipPath, contents, 0o600)
+}
+
+func (m *copyMedia) ExtractFrames(ctx context.Context, videoPath string, maxDuration time.Duration) ([]services.Screenshot, error) {
+	return m.frames, nil
+}
+
+// scrollFrames renders frames of a scroll through a chat: each shows the
+// chat offset rows down, between a fixed header and input bar
+func scrollFrames(t *testing.T, offsets ...int) []services.Screenshot {
+	t.Helper()
+
+	const width, height, bar = 240, 320, 40
+
+	// Messages are bands of random shade, a few to a dozen rows tall
+	random := rand.New(rand.NewPCG(1, 2))
+	chat := make([]uint8, 2000)
+	for y := 0; y < len(chat); {
+		shade := uint8(40 + random.IntN(200))
+		for end := y + 4 + random.IntN(24); y < end && y < len(chat); y++ {
+			chat[y] = shade
+		}
+	}
+
+	frames := make([]services.Screenshot, len(offsets))
+	for i, offset := range offsets {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			shade := uint8(250)
+			if y >= bar && y < height-bar {
+				shade = chat[offset+y-bar]
+			}
+			for x := 0; x < width; x++ {
+				img.SetGray(x, y, color.Gray{Y: shade})
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = services.Screenshot{Filename: fmt.Sprintf("frame_%04d.jpg", i+1), ContentType: "image/jpeg", Data: buf.Bytes()}
+	}
+	return frames
+}
+
+type harness struct {
+	t      *testing.T
+	router *gin.Engine
+	openai *openaifake.Server
+	media  *copyMedia
+	blobs  *services.LocalBlobStore
+	repos  *repository.Repositories
+	token  string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	fake := openaifake.New()
+	t.Cleanup(fake.Close)
+
+	cfg := config.Default()
+	cfg.JWTSecret = "e2e-secret"
+	cfg.OpenAIAPIKey = "sk-e2e"
+	cfg.OpenAIBaseURL = fake.BaseURL()
+	cfg.UploadDir = t.TempDir()
+	// Splitting needs ffmpeg; keep the fake recordings in one piece
+	cfg.TranscriptionChunkDuration = config.Duration(30 * time.Minute)
+	config.App = cfg
+
+	events := services.NewEventBroker("")
+	repos := repository.NewMemory(func(event models.ArgumentEvent) {
+		events.Publish(services.NewArgumentEvent(event))
+	})
+	notifications := &services.Notifications{Users: repos.Users, Devices: repos.Devices, Push: services.NewFakeNotifier()}
+	jobs := services.NewJobTracker()
+	pipeline := services.NewPipeline(repos, notifications, jobs)
+
+	// Judgments outlive their requests; let them finish before the next test
+	// swaps the configuration
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		jobs.Wait(ctx)
+	})
+
+	media := &copyMedia{dir: cfg.UploadDir, duration: 90, processed: 75}
+	transcriber, err := services.NewTranscriber(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := services.NewLocalBlobStore(t.TempDir(), "", cfg.JWTSecret)
+
+	handlers := routes.NewHandlers(routes.Dependencies{
+		Repos:       repos,
+		Pipeline:    pipeline,
+		Media:       media,
+		Clips:       media,
+		Frames:      media,
+		Transcriber: transcriber,
+		Uploads:     services.NewUploadStore(filepath.Join(cfg.UploadDir, "resumable"), time.Hour),
+		Archive:     services.NewMediaArchive(blobs, repos.Media),
+		Events:      events,
+		RateLimits:  ratelimit.NewMemoryStore(),
+	})
+
+	return &harness{
+		t:      t,
+		router: routes.NewRouter(cfg.ServiceName, handlers),
+		openai: fake,
+		media:  media,
+		blobs:  blobs,
+		repos:  repos,
+	}
+}
+
+func (h *harness) do(req *http.Request) *httptest.ResponseRecorder {
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	return w
+}
+
+func (h *harness) doJSON(method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return h.do(req)
+}
+
+func (h *harness) expect(w *httptest.ResponseRecorder, status int, into any) {
+	h.t.Helper()
+
+	if w.Code != status {
+		h.t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if into != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), into); err != nil {
+			h.t.Fatalf("decoding %s: %v", w.Body.String(), err)
+		}
+	}
+}
+
+// signUp registers an account and logs in as it
+func (h *harness) signUp(email string) {
+	h.t.Helper()
+
+	h.expect(h.doJSON(http.MethodPost, "/users", fmt.Sprintf(`{"name":"Sam","email":%q,"password":"hunter22"}`, email)), http.StatusCreated, nil)
+
+	var login dto.TokenResponse
+	h.expect(h.doJSON(http.MethodPost, "/login", fmt.Sprintf(`{"email":%q,"password":"hunter22"}`, email)), http.StatusOK, &login)
+	h.token = login.Token
+}
+
+type upload struct {
+	field       string
+	name        string
+	contentType string
+	data        []byte
+}
+
+func (h *harness) postMultipart(path string, fields map[string]string, files ...upload) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for name, value := range fields {
+		_ = writer.WriteField(name, value)
+	}
+
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, file.field, file.name))
+		header.Set("Content-Type", file.contentType)
+		part, _ := writer.CreatePart(header)
+		_, _ = part.Write(file.data)
+	}
+
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return h.do(req)
+}
+
+func (h *harness) uploadAudio() *httptest.ResponseRecorder {
+	return h.postMultipart("/arguments", map[string]string{
+		"person_a_name": "Sam",
+		"person_b_name": "Alex",
+		"persona":       "judge",
+	}, upload{field: "audio", name: "argument.m4a", contentType: "audio/mp4", data: []byte("fake audio bytes")})
+}
+
+// waitForStatus polls the argument until it leaves processing
+func (h *harness) waitForStatus(id uint) dto.ArgumentDetail {
+	h.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var argument dto.ArgumentDetail
+		h.expect(h.doJSON(http.MethodGet, fmt.Sprintf("/arguments/%d", id), ""), http.StatusOK, &argument)
+		if argument.Status != "processing" {
+			return argument
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.t.Fatalf("argument %d still processing", id)
+	return dto.ArgumentDetail{}
+}
+
+func TestAudioArgumentIsJudged(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.Transcriptions, openaifake.Transcription("You forgot again.\nI was busy."))
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Sam", "Sam stayed calm.", 8))
+
+	h.signUp("sam@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+
+	if created.Status != "processing" {
+		t.Fatalf("new argument status = %q, want processing", created.Status)
+	}
+	if created.Transcription != "You forgot again.\nI was busy." {
+		t.Fatalf("transcription = %q", created.Transcription)
+	}
+
+	argument := h.waitForStatus(created.ID)
+
+	if argument.Status != "complete" || argument.Judgment == nil {
+		t.Fatalf("argument = %+v, want complete with a judgment", argument)
+	}
+	if argument.Judgment.Winner != "person_a" || argument.Judgment.ConversationHealthScore != 80 {
+		t.Fatalf("judgment = %+v, want person_a scoring 80", argument.Judgment)
+	}
+	if argument.DurationSeconds == nil || *argument.DurationSeconds != 90 {
+		t.Fatalf("duration_seconds = %v, want 90", argument.DurationSeconds)
+	}
+	if argument.ProcessedDurationSeconds == nil || *argument.ProcessedDurationSeconds != 75 {
+		t.Fatalf("processed_duration_seconds = %v, want 75", argument.ProcessedDurationSeconds)
+	}
+
+	transcriptions := h.openai.Requests(openaifake.Transcriptions)
+	if len(transcriptions) != 1 || transcriptions[0].FileBytes == 0 || transcriptions[0].Authorization != "Bearer sk-e2e" {
+		t.Fatalf("transcription requests = %+v", transcriptions)
+	}
+
+	completions := h.openai.Requests(openaifake.ChatCompletions)
+	if len(completions) != 1 || completions[0].Model != config.App.JudgmentModel || completions[0].Images != 0 {
+		t.Fatalf("completion requests = %+v", completions)
+	}
+
+	// The only credit was spent
+	var me dto.User
+	h.expect(h.doJSON(http.MethodGet, "/users/me", ""), http.StatusOK, &me)
+	if me.Credits != 0 {
+		t.Fatalf("credits = %d, want 0", me.Credits)
+	}
+
+	h.expect(h.uploadAudio(), http.StatusForbidden, nil)
+}
+
+func TestStereoCallIsTranscribedPerSpeaker(t *testing.T) {
+	h := newHarness(t)
+	h.media.stereo = true
+	// Channels are transcribed concurrently, so either may get either reply
+	h.openai.Script(openaifake.Transcriptions,
+		openaifake.Transcription("", openaifake.Segment{ID: 0, Start: 2, End: 4, Text: " I was busy."}),
+		openaifake.Transcription("", openaifake.Segment{ID: 0, Start: 0.5, End: 2, Text: " You forgot again."}, openaifake.Segment{ID: 1, Start: 5, End: 6, Text: " Busy again?"}, openaifake.Segment{ID: 2, Start: 6, End: 7, Text: " Really?"}),
+	)
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Sam", "Sam stayed calm.", 8))
+
+	h.signUp("sam@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+
+	// Whoever speaks first is Person A
+	want := "Sam: You forgot again.\nAlex: I was busy.\nSam: Busy again? Really?"
+	if created.Transcription != want {
+		t.Fatalf("transcription = %q, want %q", created.Transcription, want)
+	}
+
+	if requests := h.openai.Requests(openaifake.Transcriptions); len(requests) != 2 {
+		t.Fatalf("transcription requests = %d, want one per channel", len(requests))
+	}
+
+	h.waitForStatus(created.ID)
+}
+
+func TestJudgmentFailureMarksArgumentFailed(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Failure(http.StatusInternalServerError, "upstream exploded"))
+
+	h.signUp("alex@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+
+	argument := h.waitForStatus(created.ID)
+	if argument.Status != "failed" || argument.Judgment != nil {
+		t.Fatalf("argument = %+v, want failed without a judgment", argument)
+	}
+}
+
+func TestUnparseableVerdictMarksArgumentFailed(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Completion("I refuse to pick a side."))
+
+	h.signUp("pat@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+
+	if argument := h.waitForStatus(created.ID); argument.Status != "failed" {
+		t.Fatalf("status = %q, want failed", argument.Status)
+	}
+}
+
+func TestTranscriptionFailureRejectsUpload(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.Transcriptions, openaifake.Failure(http.StatusServiceUnavailable, "overloaded"))
+
+	h.signUp("jo@example.com")
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	h.expect(h.uploadAudio(), http.StatusInternalServerError, &body)
+
+	if body.Error.Code != "transcription_failed" {
+		t.Fatalf("code = %q, want transcription_failed", body.Error.Code)
+	}
+	if calls := h.openai.Requests(openaifake.ChatCompletions); len(calls) != 0 {
+		t.Fatalf("judge was called %d times after a failed transcription", len(calls))
+	}
+
+	// A failed transcription costs nothing
+	var me dto.User
+	h.expect(h.doJSON(http.MethodGet, "/users/me", ""), http.StatusOK, &me)
+	if me.Credits != 1 {
+		t.Fatalf("credits = %d, want 1", me.Credits)
+	}
+}
+
+func TestScreenshotArgumentSendsImages(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Alex", "Alex apologised.", 6))
+
+	h.signUp("kim@example.com")
+
+	// Uploaded newest first, with the first screen twice and labelled
+	// as whatever the phone felt like
+	frames := scrollFrames(t, 0, 150)
+
+	var argument dto.Argument
+	h.expect(h.postMultipart("/arguments/screenshot",
+		map[string]string{"person_a_name": "Sam", "person_b_name": "Alex"},
+		upload{field: "screenshots", name: "two.png", contentType: "application/octet-stream", data: frames[1].Data},
+		upload{field: "screenshots", name: "one.png", contentType: "image/png", data: frames[0].Data},
+		upload{field: "screenshots", name: "one again.png", contentType: "image/png", data: frames[0].Data},
+	), http.StatusCreated, &argument)
+
+	if argument.Status != "complete" || argument.Judgment == nil || argument.Judgment.Winner != "person_b" {
+		t.Fatalf("argument = %+v, want complete with person_b winning", argument)
+	}
+
+	completions := h.openai.Requests(openaifake.ChatCompletions)
+	if len(completions) != 1 || completions[0].Images != 2 || completions[0].Model != config.App.ScreenshotModel {
+		t.Fatalf("completion requests = %+v", completions)
+	}
+
+	for i, frame := range frames {
+		if want := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(frame.Data); completions[0].ImageURLs[i] != want {
+			t.Fatalf("image %d is not screen %d of the chat", i, i)
+		}
+		// Small screenshots fit one low detail tile
+		if completions[0].ImageDetails[i] != "low" {
+			t.Fatalf("image details = %v, want low", completions[0].ImageDetails)
+		}
+	}
+}
+
+func TestScreenshotsMustBeImages(t *testing.T) {
+	h := newHarness(t)
+
+	h.signUp("lee@example.com")
+
+	h.expect(h.postMultipart("/arguments/screenshot",
+		map[string]string{"person_a_name": "Sam", "person_b_name": "Alex"},
+		upload{field: "screenshots", name: "chat.png", contentType: "image/png", data: []byte("just some text")},
+	), http.StatusUnsupportedMediaType, nil)
+
+	if calls := h.openai.Requests(openaifake.ChatCompletions); len(calls) != 0 {
+		t.Fatalf("judge was called %d times for a rejected upload", len(calls))
+	}
+
+	// Rejected uploads cost nothing
+	var me dto.User
+	h.expect(h.doJSON(http.MethodGet, "/users/me", ""), http.StatusOK, &me)
+	if me.Credits != 1 {
+		t.Fatalf("credits = %d, want 1", me.Credits)
+	}
+}
+
+func TestScreenRecordingSendsDistinctFrames(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Sam", "Sam kept to the facts.", 7))
+
+	// A pause on the first screen, a slow scroll and a jump past a whole
+	// screen: the repeats go, the frame before the jump stays
+	h.media.frames = scrollFrames(t, 0, 0, 40, 80, 160, 280, 360, 700, 800)
+
+	h.signUp("jo@example.com")
+
+	// Both at once is ambiguous and costs nothing
+	h.expect(h.postMultipart("/arguments/screenshot",
+		map[string]string{"person_a_name": "Sam", "person_b_name": "Alex"},
+		upload{field: "video", name: "chat.mp4", contentType: "video/mp4", data: []byte("not really a video")},
+		upload{field: "screenshots", name: "one.png", contentType: "image/png", data: []byte("\x89PNG")},
+	), http.StatusBadRequest, nil)
+
+	var argument dto.Argument
+	h.expect(h.postMultipart("/arguments/screenshot",
+		map[string]string{"person_a_name": "Sam", "person_b_name": "Alex"},
+		upload{field: "video", name: "chat.mp4", contentType: "video/mp4", data: []byte("not really a video")},
+	), http.StatusCreated, &argument)
+
+	if argument.Status != "complete" || argument.Judgment == nil || argument.Judgment.Winner != "person_a" {
+		t.Fatalf("argument = %+v, want complete with person_a winning", argument)
+	}
+
+	completions := h.openai.Requests(openaifake.ChatCompletions)
+	if len(completions) != 1 || completions[0].Images != 6 || completions[0].Model != config.App.ScreenshotModel {
+		t.Fatalf("completion requests = %+v", completions)
+	}
+}
+
+func TestOriginalRecordingIsArchived(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.Transcriptions, openaifake.Transcription("You forgot again."))
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Sam", "Sam stayed calm.", 8))
+
+	h.signUp("sam@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+	h.waitForStatus(created.ID)
+
+	var media dto.ArgumentMedia
+	h.expect(h.doJSON(http.MethodGet, fmt.Sprintf("/arguments/%d/media", created.ID), ""), http.StatusOK, &media)
+	if len(media.Media) != 2 || media.Media[0].Kind != models.MediaKindRecording || media.Media[0].ContentType != "audio/mp4" ||
+		media.Media[1].Kind != models.MediaKindAudio {
+		t.Fatalf("media = %+v, want the recording and its audio", media.Media)
+	}
+
+	// The signed URL needs no token
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, media.Media[0].URL, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "fake audio bytes" {
+		t.Fatalf("download = %d %q, want the original bytes", w.Code, w.Body.String())
+	}
+
+	tampered := strings.Replace(media.Media[0].URL, "signature=", "signature=0", 1)
+	w = httptest.NewRecorder()
+	h.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tampered, nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("tampered download = %d, want 403", w.Code)
+	}
+
+	// A validly signed link to a blob that is gone gets the error envelope
+	missing, err := h.blobs.SignedURL(context.Background(), "arguments/missing.m4a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	w = httptest.NewRecorder()
+	h.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, missing, nil))
+	h.expect(w, http.StatusNotFound, &body)
+	if body.Error.Code != "not_found" {
+		t.Fatalf("code = %q, want not_found", body.Error.Code)
+	}
+}
+
+func TestAudioPlaybackAndClips(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.Transcriptions, openaifake.Transcription("You forgot again."))
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Sam", "Sam stayed calm.", 8))
+
+	h.signUp("sam@example.com")
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+	h.waitForStatus(created.ID)
+
+	audioPath := fmt.Sprintf("/arguments/%d/audio", created.ID)
+
+	w := h.doJSON(http.MethodGet, audioPath, "")
+	if w.Code != http.StatusOK || w.Body.String() != "fake audio bytes" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("audio = %d %q, want the whole recording", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, audioPath, nil)
+	req.Header.Set("Range", "bytes=5-9")
+	w = h.do(req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "audio" || w.Header().Get("Content-Range") != "bytes 5-9/16" {
+		t.Fatalf("ranged audio = %d %q %q, want bytes 5-9", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+
+	clipPath := fmt.Sprintf("/arguments/%d/clips?start=1.5&end=4", created.ID)
+	for range 2 {
+		w = h.doJSON(http.MethodGet, clipPath, "")
+		if w.Code != http.StatusOK || w.Body.String() != "fake audio bytes" || w.Header().Get("Content-Type") != "audio/mp4" {
+			t.Fatalf("clip = %d %q", w.Code, w.Body.String())
+		}
+	}
+	if cuts := h.media.cuts.Load(); cuts != 1 {
+		t.Fatalf("clip cut %d times, want once and then cached", cuts)
+	}
+
+	// Clips are cached with the argument but not listed as its media
+	var media dto.ArgumentMedia
+	h.expect(h.doJSON(http.MethodGet, fmt.Sprintf("/arguments/%d/media", created.ID), ""), http.StatusOK, &media)
+	if len(media.Media) != 2 {
+		t.Fatalf("media = %+v, want no clips", media.Media)
+	}
+
+	for _, query := range []string{"start=4&end=1.5", "start=-1&end=2", "start=0&end=61", "start=80&end=85", "end=2"} {
+		h.expect(h.doJSON(http.MethodGet, fmt.Sprintf("/arguments/%d/clips?%s", created.ID, query), ""), http.StatusBadRequest, nil)
+	}
+
+	// Another user's argument doesn't exist for them
+	h.signUp("alex@example.com")
+	h.expect(h.doJSON(http.MethodGet, audioPath, ""), http.StatusNotFound, nil)
+	h.expect(h.doJSON(http.MethodGet, clipPath, ""), http.StatusNotFound, nil)
+}
+
+func TestUploadWithoutCreditsIsRejectedBeforeProcessing(t *testing.T) {
+	h := newHarness(t)
+	h.signUp("lee@example.com")
+
+	user, err := h.repos.Users.FindByEmail(context.Background(), "lee@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.repos.Credits.SetBalance(context.Background(), user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	h.expect(h.uploadAudio(), http.StatusForbidden, nil)
+
+	if calls := h.openai.Requests(openaifake.Transcriptions); len(calls) != 0 {
+		t.Fatalf("transcription was called %d times without credits", len(calls))
+	}
+
+	// Nothing was written to the upload directory
+	leftovers, _ := filepath.Glob(filepath.Join(config.App.UploadDir, "*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("upload dir holds %v", leftovers)
+	}
+}
+
+func TestRecordingLimitsFollowTier(t *testing.T) {
+	h := newHarness(t)
+	h.signUp("max@example.com")
+
+	// Longer than the free tier allows
+	h.media.duration = (time.Duration(config.App.MaxRecordingDurationFree) + time.Minute).Seconds()
+
+	var body struct {
+		Error struct {
+			Code    string         `json:"code"`
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	h.expect(h.uploadAudio(), http.StatusUnprocessableEntity, &body)
+
+	if body.Error.Code != "recording_too_long" {
+		t.Fatalf("code = %q, want recording_too_long", body.Error.Code)
+	}
+	if body.Error.Details["max_duration_seconds"] != time.Duration(config.App.MaxRecordingDurationFree).Seconds() {
+		t.Fatalf("details = %v", body.Error.Details)
+	}
+
+	// Rejected uploads cost nothing
+	var me dto.User
+	h.expect(h.doJSON(http.MethodGet, "/users/me", ""), http.StatusOK, &me)
+	if me.Credits != 1 {
+		t.Fatalf("credits = %d, want 1", me.Credits)
+	}
+	if calls := h.openai.Requests(openaifake.Transcriptions); len(calls) != 0 {
+		t.Fatalf("rejected recording was transcribed %d times", len(calls))
+	}
+
+	// Premium accounts get the longer limit
+	if err := h.repos.Users.SetPremium(context.Background(), me.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.repos.Credits.SetBalance(context.Background(), me.ID, config.App.PremiumCredits); err != nil {
+		t.Fatal(err)
+	}
+
+	var created dto.Argument
+	h.expect(h.uploadAudio(), http.StatusCreated, &created)
+	h.waitForStatus(created.ID)
+
+	// Everyone has a minimum
+	h.media.duration = 0.5
+	h.expect(h.uploadAudio(), http.StatusUnprocessableEntity, &body)
+	if body.Error.Code != "recording_too_short" {
+		t.Fatalf("code = %q, want recording_too_short", body.Error.Code)
+	}
+}
+
+// tus sends a resumable upload request with the protocol header set
+func (h *harness) tus(method string, path string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return h.do(req)
+}
+
+func TestResumableUploadBecomesArgument(t *testing.T) {
+	h := newHarness(t)
+	h.openai.Script(openaifake.Transcriptions, openaifake.Transcription("You never listen.\nI do listen."))
+	h.openai.Script(openaifake.ChatCompletions, openaifake.Verdict("Alex", "Alex explained.", 7))
+
+	h.signUp("sam@example.com")
+
+	recording := bytes.Repeat([]byte("0123456789"), 100)
+
+	created := h.tus(http.MethodPost, "/uploads", map[string]string{
+		"Upload-Length":   fmt.Sprint(len(recording)),
+		"Upload-Metadata": "filename YXJndW1lbnQubW92",
+	}, nil)
+	h.expect(created, http.StatusCreated, nil)
+	location := created.Header().Get("Location")
+	if !strings.HasPrefix(location, "/uploads/") || created.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("headers = %v", created.Header())
+	}
+	uploadID := strings.TrimPrefix(location, "/uploads/")
+
+	// The first chunk arrives, then the connection drops
+	first := h.tus(http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, recording[:400])
+	h.expect(first, http.StatusNoContent, nil)
+	if first.Header().Get("Upload-Offset") != "400" {
+		t.Fatalf("offset after first chunk = %q", first.Header().Get("Upload-Offset"))
+	}
+
+	// An unfinished upload can't be judged yet
+	var body struct {
+		Error struct {
+			Code    string         `json:"code"`
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	h.expect(h.postMultipart("/arguments", map[string]string{
+		"upload_id":     uploadID,
+		"person_a_name": "Sam",
+		"person_b_name": "Alex",
+	}), http.StatusConflict, &body)
+	if body.Error.Code != "upload_incomplete" || body.Error.Details["offset"] != float64(400) {
+		t.Fatalf("error = %+v", body.Error)
+	}
+
+	// Resuming from the wrong place is refused
+	stale := h.tus(http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, recording)
+	h.expect(stale, http.StatusConflict, &body)
+	if body.Error.Code != "upload_offset_mismatch" {
+		t.Fatalf("code = %q, want upload_offset_mismatch", body.Error.Code)
+	}
+
+	head := h.tus(http.MethodHead, location, nil, nil)
+	h.expect(head, http.StatusOK, nil)
+	if head.Header().Get("Upload-Offset") != "400" || head.Header().Get("Upload-Length") != "1000" {
+		t.Fatalf("HEAD headers = %v", head.Header())
+	}
+	if head.Header().Get("Upload-Metadata") != "filename YXJndW1lbnQubW92" {
+		t.Fatalf("metadata = %q", head.Header().Get("Upload-Metadata"))
+	}
+
+	h.expect(h.tus(http.MethodPatch, location, map[string]string{"Upload-Offset": "400"}, recording[400:]), http.StatusNoContent, nil)
+
+	// Other users can't see the upload
+	owner := h.token
+	h.signUp("alex@example.com")
+	h.expect(h.tus(http.MethodHead, location, nil, nil), http.StatusNotFound, nil)
+	h.token = owner
+
+	var argument dto.Argument
+	h.expect(h.postMultipart("/arguments", map[string]string{
+		"upload_id":     uploadID,
+		"person_a_name": "Sam",
+		"person_b_name": "Alex",
+	}), http.StatusCreated, &argument)
+
+	if judged := h.waitForStatus(argument.ID); judged.Status != "complete" {
+		t.Fatalf("status = %q, want complete", judged.Status)
+	}
+
+	transcriptions := h.openai.Requests(openaifake.Transcriptions)
+	if len(transcriptions) != 1 || transcriptions[0].FileBytes != len(recording) {
+		t.Fatalf("transcription requests = %+v", transcriptions)
+	}
+
+	// The upload is spent once it becomes an argument
+	h.expect(h.tus(http.MethodHead, location, nil, nil), http.StatusNotFound, nil)
+}
+
+func TestUploadsRequireTusVersion(t *testing.T) {
+	h := newHarness(t)
+	h.signUp("sam@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", "10")
+	w := h.do(req)
+	h.expect(w, http.StatusPreconditionFailed, nil)
+	if w.Header().Get("Tus-Version") != "1.0.0" {
+		t.Fatalf("Tus-Version = %q", w.Header().Get("Tus-Version"))
+	}
+
+	options := httptest.NewRequest(http.MethodOptions, "/uploads", nil)
+	w = h.do(options)
+	h.expect(w, http.StatusNoContent, nil)
+	if !strings.Contains(w.Header().Get("Tus-Extension"), "creation") {
+		t.Fatalf("Tus-Extension = %q", w.Header().Get("Tus-Extension"))
+	}
+}
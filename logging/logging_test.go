@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		key      string
+		redacted bool
+	}{
+		{key: "token", redacted: true},
+		{key: "device_token", redacted: true},
+		{key: "Access_Token", redacted: true},
+		{key: "authorization", redacted: true},
+		{key: "openai_api_key", redacted: true},
+		{key: "transcription", redacted: true},
+		{key: "prompt_tokens"},
+		{key: "completion_tokens"},
+		{key: "token_ttl"},
+		{key: "transcription_backend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			var buf bytes.Buffer
+			New(&buf, "info").Info("test", tt.key, "value")
+
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			if got := record[tt.key] == redacted; got != tt.redacted {
+				t.Fatalf("%s = %v, want redacted %v", tt.key, record[tt.key], tt.redacted)
+			}
+		})
+	}
+}
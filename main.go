@@ -1,27 +1,166 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
 	"github.com/calebchiang/thirdparty_server/database"
-	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/logging"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/calebchiang/thirdparty_server/repository"
 	"github.com/calebchiang/thirdparty_server/routes"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/calebchiang/thirdparty_server/tracing"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	_ = godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	config.App = cfg
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+	gin.SetMode(gin.ReleaseMode)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrateCommand(os.Args[2:])
+			return
+		case "config":
+			runConfigCommand(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: cfg.ServiceName,
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+	})
+	if err != nil {
+		log.Fatal("Failed to set up tracing: ", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	database.Connect(cfg.DatabaseURL)
+
+	// Safe with several instances booting at once: MigrateUp holds an
+	// advisory lock while it runs.
+	if _, err := database.MigrateUp(context.Background()); err != nil {
+		log.Fatal("Failed to apply migrations: ", err)
+	}
+
+	// Cancelled once shutdown gives up waiting, aborting in-flight requests
+	// (and the ffmpeg/OpenAI calls they started) and background listeners
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	events := services.NewEventBroker(cfg.DatabaseURL)
+	go events.Run(baseCtx)
+
+	repos := repository.NewGorm(database.DB)
+
+	var push services.Notifier = services.NewFakeNotifier()
+	if cfg.APNSKeyPath != "" {
+		notifier, err := services.NewAPNsNotifier(
+			cfg.APNSKeyPath,
+			cfg.APNSKeyID,
+			cfg.APNSTeamID,
+			cfg.APNSTopic,
+		)
+		if err != nil {
+			log.Fatal("Failed to configure APNs:", err)
+		}
+		push = notifier
+	}
+
+	notifications := &services.Notifications{
+		Users:   repos.Users,
+		Devices: repos.Devices,
+		Push:    push,
+	}
+	pipeline := services.NewPipeline(repos, notifications, services.NewJobTracker())
+
+	var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitBackend == "postgres" {
+		rateLimits = ratelimit.NewPostgresStore(database.DB)
+	}
+	go ratelimit.RunSweeper(baseCtx, rateLimits, 10*time.Minute, time.Hour)
+
+	transcriber, err := services.NewTranscriber(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure transcription: ", err)
+	}
+
+	blobs, err := services.NewBlobStore(baseCtx, cfg)
+	if err != nil {
+		log.Fatal("Failed to configure blob storage: ", err)
+	}
+
+	archive := services.NewMediaArchive(blobs, repos.Media)
+	go archive.RunSweeper(baseCtx, time.Hour)
+
+	uploads := services.NewUploadStore(filepath.Join(cfg.UploadDir, "resumable"), time.Duration(cfg.ResumableUploadExpiry))
+	go uploads.RunSweeper(baseCtx, time.Hour)
+
+	media := services.NewMediaService()
+
+	handlers := routes.NewHandlers(routes.Dependencies{
+		Repos:       repos,
+		Pipeline:    pipeline,
+		Media:       media,
+		Clips:       media,
+		Frames:      media,
+		Transcriber: transcriber,
+		Uploads:     uploads,
+		Archive:     archive,
+		Events:      events,
+		RateLimits:  rateLimits,
+	})
+	r := routes.NewRouter(cfg.ServiceName, handlers)
+
+	pipeline.ResumeInterruptedJudgments(context.Background())
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed: ", err)
+		}
+	}()
 
-	database.Connect()
-	database.DB.AutoMigrate(
-		&models.User{},
-		&models.Argument{},
-		&models.Judgment{},
-	)
+	slog.Info("server listening", "port", cfg.Port)
 
-	r := gin.Default()
-	routes.UserRoutes(r)
-	routes.ArgumentRoutes(r)
-	routes.RevenueCatRoutes(r)
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-signals.Done()
 
-	r.Run()
+	drain(srv, pipeline, cancelBase, time.Duration(cfg.ShutdownTimeout))
 }
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "thirdparty"
+
+// Pipeline stages used as the "stage" label
+const (
+	StageNormalize          = "normalize"
+	StageTranscribe         = "transcribe"
+	StageJudgment           = "judgment"
+	StageScreenshotJudgment = "screenshot_judgment"
+	StageSave               = "save"
+)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	PipelineStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pipeline_stage_duration_seconds",
+		Help:      "Duration of each processing pipeline stage.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"stage"})
+
+	PipelineFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipeline_failures_total",
+		Help:      "Pipeline failures by stage and reason.",
+	}, []string{"stage", "reason"})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "LLM tokens used, by model and kind (prompt or completion).",
+	}, []string{"model", "kind"})
+
+	CreditsConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "credits_consumed_total",
+		Help:      "Credits deducted from users, by argument source.",
+	}, []string{"source"})
+
+	AudioSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_seconds_total",
+		Help:      "Seconds of audio uploaded (original) and sent for transcription after preprocessing (processed).",
+	}, []string{"kind"})
+
+	WebhookEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenuecat_webhook_events_total",
+		Help:      "RevenueCat webhook events received, by type.",
+	}, []string{"type"})
+
+	JudgmentsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "judgments_in_flight",
+		Help:      "Judgments currently being generated.",
+	})
+)
+
+// ObserveStage records how long a stage took and, when err is non-nil, a
+// failure with the given reason.
+func ObserveStage(stage string, start time.Time, err error, reason string) {
+	PipelineStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+	if err != nil {
+		PipelineFailures.WithLabelValues(stage, reason).Inc()
+	}
+}
+
+// TrackJudgment marks a judgment in flight until the returned func is called
+func TrackJudgment() func() {
+	JudgmentsInFlight.Inc()
+	return JudgmentsInFlight.Dec
+}
+
+// RecordAudio adds a recording's length before and after preprocessing
+func RecordAudio(originalSeconds float64, processedSeconds float64) {
+	AudioSeconds.WithLabelValues("original").Add(originalSeconds)
+	AudioSeconds.WithLabelValues("processed").Add(processedSeconds)
+}
+
+// RecordTokens adds the token usage reported by the provider
+func RecordTokens(model string, promptTokens int, completionTokens int) {
+	LLMTokens.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	LLMTokens.WithLabelValues(model, "completion").Add(float64(completionTokens))
+}
+
+var knownWebhookEvents = map[string]bool{
+	"INITIAL_PURCHASE":            true,
+	"RENEWAL":                     true,
+	"CANCELLATION":                true,
+	"UNCANCELLATION":              true,
+	"NON_RENEWING_PURCHASE":       true,
+	"SUBSCRIPTION_PAUSED":         true,
+	"SUBSCRIPTION_EXTENDED":       true,
+	"EXPIRATION":                  true,
+	"BILLING_ISSUE":               true,
+	"PRODUCT_CHANGE":              true,
+	"TRANSFER":                    true,
+	"TEMPORARY_ENTITLEMENT_GRANT": true,
+	"TEST":                        true,
+}
+
+// RecordWebhookEvent counts an event, folding unknown types into "other"
+// so client input cannot grow the label set.
+func RecordWebhookEvent(eventType string) {
+	if !knownWebhookEvents[eventType] {
+		eventType = "other"
+	}
+	WebhookEvents.WithLabelValues(eventType).Inc()
+}
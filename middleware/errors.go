@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler writes the error envelope for handlers that aborted with
+// apierror.Abort. It must run after RequestID and before Recovery.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		apiErr := apierror.From(c.Errors.Last().Err)
+		apiErr.RequestID = c.GetString("request_id")
+
+		c.JSON(apiErr.Status, gin.H{"error": apiErr})
+	}
+}
+
+// NotFound answers unknown routes with the envelope
+func NotFound(c *gin.Context) {
+	apierror.Abort(c, apierror.New(apierror.NotFound, "Not found"))
+}
@@ -1,10 +1,10 @@
 package middleware
 
 import (
-	"net/http"
-	"os"
 	"strings"
 
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/config"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -14,25 +14,13 @@ func RequireAuth() gin.HandlerFunc {
 
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header required",
-			})
+			apierror.Abort(c, apierror.New(apierror.Unauthorized, "Authorization header required"))
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header format must be Bearer {token}",
-			})
-			return
-		}
-
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-				"error": "JWT secret not configured",
-			})
+			apierror.Abort(c, apierror.New(apierror.Unauthorized, "Authorization header format must be Bearer {token}"))
 			return
 		}
 
@@ -40,29 +28,23 @@ func RequireAuth() gin.HandlerFunc {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, jwt.ErrSignatureInvalid
 			}
-			return []byte(secret), nil
+			return []byte(config.App.JWTSecret), nil
 		})
 
 		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
+			apierror.Abort(c, apierror.New(apierror.InvalidToken, "Invalid token"))
 			return
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok || claims["user_id"] == nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token claims",
-			})
+			apierror.Abort(c, apierror.New(apierror.InvalidToken, "Invalid token claims"))
 			return
 		}
 
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid user ID",
-			})
+			apierror.Abort(c, apierror.New(apierror.InvalidToken, "Invalid user ID"))
 			return
 		}
 
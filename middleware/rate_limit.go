@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/calebchiang/thirdparty_server/apierror"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc picks the bucket a request is counted against
+type KeyFunc func(c *gin.Context) string
+
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser must run after RequireAuth; unauthenticated requests fall back
+// to the client IP.
+func KeyByUser(c *gin.Context) string {
+	if userID, ok := c.Get("user_id"); ok {
+		return fmt.Sprintf("user:%v", userID)
+	}
+	return KeyByIP(c)
+}
+
+// RateLimiter builds rate limit middleware whose buckets live in one store
+type RateLimiter struct {
+	store ratelimit.Store
+}
+
+func NewRateLimiter(store ratelimit.Store) *RateLimiter {
+	return &RateLimiter{store: store}
+}
+
+// Limit rejects requests over policy with 429 and a Retry-After header.
+// If the store is unavailable requests are let through.
+func (l *RateLimiter) Limit(policy ratelimit.Policy, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := l.store.Take(c.Request.Context(), key(c), policy)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "rate limiter unavailable", "policy", policy.Name, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Abort(c, apierror.New(apierror.RateLimited, "Too many requests").
+				WithDetails(gin.H{"retry_after_seconds": retryAfter}))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -3,15 +3,23 @@ package models
 import "time"
 
 type Argument struct {
-	ID            uint   `gorm:"primaryKey"`
-	UserID        uint   `gorm:"not null;index"`
-	PersonAName   string `gorm:"type:varchar(255);not null"`
-	PersonBName   string `gorm:"type:varchar(255);not null"`
-	Persona       string `gorm:"type:varchar(50);not null;default:'mediator'"`
-	Transcription string `gorm:"type:text;not null"`
-	Status        string `gorm:"type:varchar(20);default:'processing'"`
-	CreatedAt     time.Time
+	ID             uint   `gorm:"primaryKey"`
+	UserID         uint   `gorm:"not null;index"`
+	RelationshipID *uint  `gorm:"index"`
+	PersonAName    string `gorm:"type:varchar(255);not null"`
+	PersonBName    string `gorm:"type:varchar(255);not null"`
+	Persona        string `gorm:"type:varchar(50);not null;default:'mediator'"`
+	Transcription  string `gorm:"type:text;not null"`
+	// DurationSeconds is measured from the upload and
+	// ProcessedDurationSeconds from the trimmed audio that was transcribed;
+	// nil for screenshots
+	DurationSeconds          *float64
+	ProcessedDurationSeconds *float64
+	Status                   string `gorm:"type:varchar(20);default:'processing'"`
+	ResumePending            bool   `gorm:"not null;default:false"`
+	CreatedAt                time.Time
 
 	User     User
 	Judgment *Judgment `gorm:"constraint:OnDelete:CASCADE"`
+	Media    []MediaBlob
 }
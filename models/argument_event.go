@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type ArgumentEvent struct {
+	ID         uint   `gorm:"primaryKey"`
+	ArgumentID uint   `gorm:"not null;index"`
+	Status     string `gorm:"type:varchar(20);not null"`
+	Stage      string `gorm:"type:varchar(40);not null"`
+	CreatedAt  time.Time
+
+	Argument *Argument `gorm:"foreignKey:ArgumentID;constraint:OnDelete:CASCADE"`
+}
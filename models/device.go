@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Device struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;index"`
+	Token       string `gorm:"type:varchar(200);not null;uniqueIndex"`
+	Platform    string `gorm:"type:varchar(20);not null;default:'ios'"`
+	Environment string `gorm:"type:varchar(20);not null;default:'production'"` // production | sandbox
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	User *User `gorm:"constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	MediaKindRecording  = "recording"
+	MediaKindScreenshot = "screenshot"
+	// MediaKindScreenRecording is a video the screenshots were taken from
+	MediaKindScreenRecording = "screen_recording"
+	// MediaKindAudio is the normalized audio that was transcribed, so
+	// transcript times line up with it
+	MediaKindAudio = "audio"
+	// MediaKindClip is a cached excerpt of the audio
+	MediaKindClip = "clip"
+)
+
+// MediaBlob records an object in the blob store. ArgumentID is cleared when
+// the argument is deleted, leaving the row for the retention sweeper.
+type MediaBlob struct {
+	ID          uint   `gorm:"primaryKey"`
+	ArgumentID  *uint  `gorm:"index"`
+	Kind        string `gorm:"type:varchar(20);not null"` // recording | screenshot | screen_recording | audio | clip
+	Key         string `gorm:"type:text;not null;uniqueIndex"`
+	ContentType string `gorm:"type:varchar(100);not null"`
+	SizeBytes   int64  `gorm:"not null"`
+	// Position orders screenshots as they were uploaded
+	Position  int `gorm:"not null;default:0"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type Relationship struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;index"`
+	Label       string `gorm:"type:varchar(255)"`
+	PersonAName string `gorm:"type:varchar(255);not null"`
+	PersonBName string `gorm:"type:varchar(255);not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	User      User
+	Arguments []Argument `gorm:"constraint:OnDelete:SET NULL"`
+}
@@ -9,6 +9,11 @@ type User struct {
 	Password  string `gorm:"not null"`
 	Credits   int    `gorm:"not null;default:1"`
 	IsPremium bool   `gorm:"not null;default:false"`
+
+	// Push notification preferences
+	NotifyOnComplete bool `gorm:"not null;default:true"`
+	NotifyOnFailure  bool `gorm:"not null;default:true"`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
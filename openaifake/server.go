@@ -0,0 +1,326 @@
+// Package openaifake is an in-process stand-in for the parts of the OpenAI
+// API the server calls: verbose_json transcriptions and chat completions,
+// including completions with image parts. Replies are scripted per endpoint
+// and failures can be injected, so tests can drive the pipeline end to end
+// without network access.
+package openaifake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Endpoint string
+
+const (
+	Transcriptions  Endpoint = "/v1/audio/transcriptions"
+	ChatCompletions Endpoint = "/v1/chat/completions"
+)
+
+// Reply is one scripted response. Body is written as is when it is a
+// string or []byte and encoded as JSON otherwise.
+type Reply struct {
+	Status int
+	Body   any
+	// Delay holds the response back, or until the client gives up
+	Delay time.Duration
+}
+
+type Segment struct {
+	ID    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcription replies with a verbose_json transcription. Segments are
+// spread one second apart when none are given.
+func Transcription(text string, segments ...Segment) Reply {
+	if len(segments) == 0 {
+		for i, line := range strings.Split(text, "\n") {
+			segments = append(segments, Segment{ID: i, Start: float64(i), End: float64(i + 1), Text: line})
+		}
+	}
+
+	duration := 0.0
+	if len(segments) > 0 {
+		duration = segments[len(segments)-1].End
+	}
+
+	return Reply{
+		Status: http.StatusOK,
+		Body: map[string]any{
+			"task":     "transcribe",
+			"language": "english",
+			"duration": duration,
+			"text":     text,
+			"segments": segments,
+		},
+	}
+}
+
+// Completion replies with a single chat choice whose content is given
+func Completion(content string) Reply {
+	return Reply{
+		Status: http.StatusOK,
+		Body: map[string]any{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   "fake-model",
+			"choices": []map[string]any{{
+				"index":         0,
+				"finish_reason": "stop",
+				"message":       map[string]any{"role": "assistant", "content": content},
+			}},
+			"usage": map[string]any{"prompt_tokens": 10, "completion_tokens": 20, "total_tokens": 30},
+		},
+	}
+}
+
+// Verdict replies with a completion in the JSON shape the judge prompt asks for
+func Verdict(winnerName string, reasoning string, score int) Reply {
+	content, _ := json.Marshal(map[string]any{
+		"winner_name":           winnerName,
+		"reasoning":             reasoning,
+		"respect":               score,
+		"empathy":               score,
+		"accountability":        score,
+		"emotional_regulation":  score,
+		"manipulation_toxicity": score,
+	})
+	return Completion(string(content))
+}
+
+// Failure replies with an OpenAI-style error body
+func Failure(status int, message string) Reply {
+	return Reply{
+		Status: status,
+		Body: map[string]any{
+			"error": map[string]any{"message": message, "type": "fake_error", "code": nil},
+		},
+	}
+}
+
+// Request is what the server saw of one call
+type Request struct {
+	Endpoint       Endpoint
+	Authorization  string
+	Model          string
+	ResponseFormat string
+	FileName       string
+	FileBytes      int
+	Messages       int
+	Images         int
+	// ImageURLs and ImageDetails describe the attached images in order
+	ImageURLs    []string
+	ImageDetails []string
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	scripts  map[Endpoint][]Reply
+	defaults map[Endpoint]Reply
+	requests []Request
+}
+
+// New starts a server that answers every call with a generic transcription
+// or verdict until told otherwise. Close it when done.
+func New() *Server {
+	s := &Server{
+		scripts: map[Endpoint][]Reply{},
+		defaults: map[Endpoint]Reply{
+			Transcriptions:  Transcription("I said I'd do the dishes.\nYou never do the dishes."),
+			ChatCompletions: Verdict("tie", "Both of you have a point.", 5),
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// BaseURL is the value for config.OpenAIBaseURL
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// Script queues replies for an endpoint; each call takes the next one and
+// the default answers once the queue is empty
+func (s *Server) Script(endpoint Endpoint, replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[endpoint] = append(s.scripts[endpoint], replies...)
+}
+
+// SetDefault changes the reply used when no scripted reply is queued
+func (s *Server) SetDefault(endpoint Endpoint, reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[endpoint] = reply
+}
+
+// Requests returns the calls made to an endpoint so far
+func (s *Server) Requests(endpoint Endpoint) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []Request
+	for _, request := range s.requests {
+		if request.Endpoint == endpoint {
+			matched = append(matched, request)
+		}
+	}
+	return matched
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	endpoint := Endpoint(r.URL.Path)
+
+	if r.Method != http.MethodPost || (endpoint != Transcriptions && endpoint != ChatCompletions) {
+		write(w, Failure(http.StatusNotFound, fmt.Sprintf("unknown endpoint %s %s", r.Method, r.URL.Path)))
+		return
+	}
+
+	request := Request{Endpoint: endpoint, Authorization: r.Header.Get("Authorization")}
+
+	var err error
+	if endpoint == Transcriptions {
+		err = readTranscription(r, &request)
+	} else {
+		err = readCompletion(r, &request)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	reply := s.defaults[endpoint]
+	if queued := s.scripts[endpoint]; len(queued) > 0 {
+		reply = queued[0]
+		s.scripts[endpoint] = queued[1:]
+	}
+	s.mu.Unlock()
+
+	// Malformed requests fail like the real API would, whatever was scripted
+	if err != nil {
+		write(w, Failure(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	if !strings.HasPrefix(request.Authorization, "Bearer ") {
+		write(w, Failure(http.StatusUnauthorized, "missing API key"))
+		return
+	}
+
+	if reply.Delay > 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	write(w, reply)
+}
+
+func readTranscription(r *http.Request, request *Request) error {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return fmt.Errorf("file is required: %v", err)
+	}
+	defer file.Close()
+
+	size, _ := io.Copy(io.Discard, file)
+
+	request.Model = r.FormValue("model")
+	request.ResponseFormat = r.FormValue("response_format")
+	request.FileName = header.Filename
+	request.FileBytes = int(size)
+
+	if request.Model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if request.ResponseFormat != "verbose_json" {
+		return fmt.Errorf("only verbose_json is supported, got %q", request.ResponseFormat)
+	}
+
+	return nil
+}
+
+func readCompletion(r *http.Request, request *Request) error {
+	var body struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+
+	request.Model = body.Model
+	request.Messages = len(body.Messages)
+
+	if request.Model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if len(body.Messages) == 0 {
+		return fmt.Errorf("messages are required")
+	}
+
+	for _, message := range body.Messages {
+		// Content is a string, or an array of parts when images are attached
+		var parts []struct {
+			Type     string `json:"type"`
+			ImageURL *struct {
+				URL    string `json:"url"`
+				Detail string `json:"detail"`
+			} `json:"image_url"`
+		}
+		if json.Unmarshal(message.Content, &parts) != nil {
+			continue
+		}
+
+		for _, part := range parts {
+			if part.Type != "image_url" {
+				continue
+			}
+			if part.ImageURL == nil || !strings.HasPrefix(part.ImageURL.URL, "data:image/") {
+				return fmt.Errorf("image parts must carry a data:image URL")
+			}
+			request.Images++
+			request.ImageURLs = append(request.ImageURLs, part.ImageURL.URL)
+			request.ImageDetails = append(request.ImageDetails, part.ImageURL.Detail)
+		}
+	}
+
+	return nil
+}
+
+func write(w http.ResponseWriter, reply Reply) {
+	status := reply.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	var payload []byte
+	switch body := reply.Body.(type) {
+	case string:
+		payload = []byte(body)
+	case []byte:
+		payload = body
+	default:
+		payload, _ = json.Marshal(body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(payload)
+}
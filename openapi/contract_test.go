@@ -0,0 +1,415 @@
+package openapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/dto"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/openapi"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/routes"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Spec)
+	if err != nil {
+		t.Fatalf("loading spec: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		t.Fatalf("spec is invalid: %v", err)
+	}
+
+	return doc
+}
+
+func newRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.JWTSecret = "contract-test-secret"
+	config.App = cfg
+
+	events := services.NewEventBroker("")
+	repos := repository.NewMemory(func(event models.ArgumentEvent) {
+		events.Publish(services.NewArgumentEvent(event))
+	})
+	notifications := &services.Notifications{Users: repos.Users, Devices: repos.Devices, Push: services.NewFakeNotifier()}
+	pipeline := services.NewPipeline(repos, notifications, services.NewJobTracker())
+
+	handlers := routes.NewHandlers(routes.Dependencies{
+		Repos:       repos,
+		Pipeline:    pipeline,
+		Media:       services.NewMediaService(),
+		Clips:       services.NewMediaService(),
+		Frames:      services.NewMediaService(),
+		Transcriber: &services.FixtureTranscriber{Dir: t.TempDir()},
+		Uploads:     services.NewUploadStore(t.TempDir(), time.Hour),
+		Archive:     services.NewMediaArchive(services.NewLocalBlobStore(t.TempDir(), "", cfg.JWTSecret), repos.Media),
+		Events:      events,
+		RateLimits:  ratelimit.NewMemoryStore(),
+	})
+
+	return routes.NewRouter(cfg.ServiceName, handlers)
+}
+
+var ginParam = regexp.MustCompile(`[:*](\w+)`)
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	doc := loadSpec(t)
+	r := newRouter(t)
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		key := route.Method + " " + path
+		registered[key] = true
+
+		item := doc.Paths.Find(path)
+		if item == nil || item.GetOperation(route.Method) == nil {
+			t.Errorf("%s is served but missing from openapi.json", key)
+		}
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if !registered[method+" "+path] {
+				t.Errorf("%s %s is documented but not served", method, path)
+			}
+		}
+	}
+}
+
+// Requests are driven through the real router backed by in-memory
+// repositories; their responses must validate against the spec.
+func TestResponsesMatchSpec(t *testing.T) {
+	doc := loadSpec(t)
+	r := newRouter(t)
+
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signedToken(t, 42)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		auth   bool
+		status int
+	}{
+		{"error catalog", http.MethodGet, "/error-codes", "", false, http.StatusOK},
+		{"openapi document", http.MethodGet, "/openapi.json", "", false, http.StatusOK},
+		{"missing token", http.MethodGet, "/users/me", "", false, http.StatusUnauthorized},
+		{"malformed token", http.MethodGet, "/arguments", "", false, http.StatusUnauthorized},
+		{"invalid sort", http.MethodGet, "/arguments?sort=length", "", true, http.StatusBadRequest},
+		{"invalid limit", http.MethodGet, "/arguments?limit=0", "", true, http.StatusBadRequest},
+		{"invalid trend window", http.MethodGet, "/relationships/{id}/trends?window=0", "", true, http.StatusBadRequest},
+		{"invalid device token", http.MethodPost, "/users/me/devices", `{"token":"nope"}`, true, http.StatusBadRequest},
+		{"empty relationship", http.MethodPost, "/relationships", `{}`, true, http.StatusBadRequest},
+		{"register without password", http.MethodPost, "/users", `{"email":"a@example.com"}`, false, http.StatusBadRequest},
+		{"login without body", http.MethodPost, "/login", `not json`, false, http.StatusBadRequest},
+		{"apple login without token", http.MethodPost, "/apple_login", `{}`, false, http.StatusBadRequest},
+		{"webhook without body", http.MethodPost, "/revenuecat/webhook", `not json`, false, http.StatusBadRequest},
+		{"upload capabilities", http.MethodOptions, "/uploads", "", false, http.StatusNoContent},
+		{"upload without tus version", http.MethodPost, "/uploads", "", true, http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tc.auth {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else if tc.name == "malformed token" {
+				req.Header.Set("Authorization", "Bearer not-a-jwt")
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+
+			validateResponse(t, specRouter, req, w)
+		})
+	}
+}
+
+// Walks an account through its resources so success bodies are checked
+// against the spec too, not just errors.
+func TestAccountFlowMatchesSpec(t *testing.T) {
+	doc := loadSpec(t)
+	r := newRouter(t)
+
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token and relationship id come from earlier steps
+	var token, relationshipID string
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"register", http.MethodPost, "/users", `{"name":"Sam","email":"Sam@Example.com","password":"hunter22"}`, http.StatusCreated},
+		{"duplicate email", http.MethodPost, "/users", `{"email":"sam@example.com","password":"x"}`, http.StatusConflict},
+		{"wrong password", http.MethodPost, "/login", `{"email":"sam@example.com","password":"nope"}`, http.StatusUnauthorized},
+		{"login", http.MethodPost, "/login", `{"email":"sam@example.com","password":"hunter22"}`, http.StatusOK},
+		{"current user", http.MethodGet, "/users/me", "", http.StatusOK},
+		{"create relationship", http.MethodPost, "/relationships", `{"label":"Roommates","person_a_name":"Sam","person_b_name":"Alex"}`, http.StatusCreated},
+		{"list relationships", http.MethodGet, "/relationships", "", http.StatusOK},
+		{"get relationship", http.MethodGet, "/relationships/{id}", "", http.StatusOK},
+		{"empty trends", http.MethodGet, "/relationships/{id}/trends", "", http.StatusOK},
+		{"unknown relationship", http.MethodGet, "/relationships/99", "", http.StatusNotFound},
+		{"list arguments", http.MethodGet, "/arguments?status=complete", "", http.StatusOK},
+		{"unknown argument", http.MethodGet, "/arguments/99", "", http.StatusNotFound},
+		{"register device", http.MethodPost, "/users/me/devices", `{"token":"` + strings.Repeat("ab", 32) + `"}`, http.StatusCreated},
+		{"remove device", http.MethodDelete, "/users/me/devices/" + strings.Repeat("ab", 32), "", http.StatusOK},
+		{"remove unknown device", http.MethodDelete, "/users/me/devices/" + strings.Repeat("cd", 32), "", http.StatusNotFound},
+		{"update preferences", http.MethodPut, "/users/me/notifications", `{"notify_on_failure":false}`, http.StatusOK},
+		{"read preferences", http.MethodGet, "/users/me/notifications", "", http.StatusOK},
+		{"delete relationship", http.MethodDelete, "/relationships/{id}", "", http.StatusOK},
+		{"delete account", http.MethodDelete, "/users/me", "", http.StatusOK},
+		{"deleted account", http.MethodGet, "/users/me", "", http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		path := strings.Replace(step.path, "{id}", relationshipID, 1)
+
+		req := httptest.NewRequest(step.method, path, strings.NewReader(step.body))
+		if step.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+
+		validateResponse(t, specRouter, req, w)
+
+		switch step.name {
+		case "login":
+			var body dto.TokenResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			token = body.Token
+		case "create relationship":
+			var body dto.Relationship
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			relationshipID = strconv.FormatUint(uint64(body.ID), 10)
+		}
+	}
+}
+
+func TestRateLimitedResponseMatchesSpec(t *testing.T) {
+	doc := loadSpec(t)
+	r := newRouter(t)
+
+	specRouter, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code == http.StatusTooManyRequests {
+			if w.Header().Get("Retry-After") == "" {
+				t.Fatal("429 without Retry-After")
+			}
+			validateResponse(t, specRouter, req, w)
+			return
+		}
+	}
+
+	t.Fatal("login was never rate limited")
+}
+
+// Shapes the flows above don't reach, such as judged arguments, are checked
+// through the DTOs the handlers serialize.
+func TestDTOsMatchSchemas(t *testing.T) {
+	doc := loadSpec(t)
+
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	relationshipID := uint(3)
+
+	judgment := models.Judgment{
+		ID:                      9,
+		ArgumentID:              7,
+		Winner:                  "person_a",
+		Reasoning:               "A listened.",
+		FullResponse:            `{"winner":"person_a"}`,
+		Respect:                 8,
+		Empathy:                 7,
+		Accountability:          6,
+		EmotionalRegulation:     5,
+		ManipulationToxicity:    9,
+		ConversationHealthScore: 71,
+		CreatedAt:               created,
+	}
+
+	argument := models.Argument{
+		ID:             7,
+		UserID:         42,
+		RelationshipID: &relationshipID,
+		PersonAName:    "Sam",
+		PersonBName:    "Alex",
+		Persona:        "mediator",
+		Transcription:  "hello",
+		Status:         "complete",
+		CreatedAt:      created,
+		User:           models.User{Password: "hash"},
+		Judgment:       &judgment,
+	}
+
+	pending := argument
+	pending.Status = "processing"
+	pending.Judgment = nil
+
+	user := models.User{ID: 42, Name: "Sam", Email: "sam@example.com", Password: "hash", Credits: 3}
+	relationship := models.Relationship{ID: relationshipID, UserID: 42, Label: "Roommates", PersonAName: "Sam", PersonBName: "Alex", CreatedAt: created}
+	winner := "person_a"
+	score := 71
+
+	fixtures := []struct {
+		schema string
+		value  any
+	}{
+		{"User", dto.NewUser(user)},
+		{"TokenResponse", dto.TokenResponse{Token: "t"}},
+		{"MessageResponse", dto.MessageResponse{Message: "ok"}},
+		{"Device", dto.NewDevice(models.Device{ID: 1, Platform: "ios", Environment: "sandbox", CreatedAt: created})},
+		{"NotificationPreferences", dto.NewNotificationPreferences(user)},
+		{"Judgment", dto.NewJudgment(judgment)},
+		{"Argument", dto.NewArgument(argument)},
+		{"Argument", dto.NewArgument(pending)},
+		{"ArgumentDetail", dto.NewArgumentDetail(argument)},
+		{"ArgumentDetail", dto.NewArgumentDetail(pending)},
+		{"Relationship", dto.NewRelationship(relationship)},
+		{"ArgumentPage", repository.ArgumentPage{Arguments: []repository.ArgumentSummary{}}},
+		{"ArgumentPage", repository.ArgumentPage{
+			Arguments: []repository.ArgumentSummary{{
+				ID: 7, RelationshipID: &relationshipID, PersonAName: "Sam", PersonBName: "Alex",
+				Persona: "judge", Status: "complete", Winner: &winner, ConversationHealthScore: &score, CreatedAt: created,
+			}},
+			NextCursor: "abc",
+		}},
+		{"ArgumentEvent", services.ArgumentEvent{ID: 1, ArgumentID: 7, Status: "processing", Stage: services.StageJudging, CreatedAt: created}},
+		{"RelationshipTrends", services.BuildRelationshipTrends(relationship, []models.Argument{argument}, services.DefaultTrendWindow)},
+		{"RelationshipTrends", services.BuildRelationshipTrends(relationship, nil, services.DefaultTrendWindow)},
+		{"ArgumentMedia", dto.ArgumentMedia{Media: []dto.MediaBlob{
+			dto.NewMediaBlob(models.MediaBlob{ID: 1, Kind: models.MediaKindRecording, ContentType: "audio/mp4", SizeBytes: 2048, CreatedAt: created}, "/blobs/a.m4a?expires=1&signature=s", created),
+			dto.NewMediaBlob(models.MediaBlob{ID: 3, Kind: models.MediaKindAudio, ContentType: "audio/mp4", SizeBytes: 1024, CreatedAt: created}, "/blobs/c.m4a?expires=1&signature=s", created),
+			dto.NewMediaBlob(models.MediaBlob{ID: 2, Kind: models.MediaKindScreenshot, ContentType: "image/png", SizeBytes: 512, Position: 1, CreatedAt: created}, "/blobs/b.png?expires=1&signature=s", created),
+		}}},
+	}
+
+	for _, fixture := range fixtures {
+		schema := doc.Components.Schemas[fixture.schema]
+		if schema == nil {
+			t.Fatalf("schema %s missing", fixture.schema)
+		}
+
+		encoded, err := json.Marshal(fixture.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded any
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := schema.Value.VisitJSON(decoded); err != nil {
+			t.Errorf("%s does not match its schema: %v\n%s", fixture.schema, err, encoded)
+		}
+	}
+}
+
+func validateResponse(t *testing.T, specRouter routers.Router, req *http.Request, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	route, pathParams, err := specRouter.FindRoute(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		},
+		Status: w.Code,
+		Header: w.Header(),
+		Body:   io.NopCloser(bytes.NewReader(w.Body.Bytes())),
+	}
+
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		t.Errorf("response does not match spec: %v\n%s", err, w.Body.String())
+	}
+}
+
+func signedToken(t *testing.T, userID uint) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+
+	signed, err := token.SignedString([]byte(config.App.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
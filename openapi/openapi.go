@@ -0,0 +1,8 @@
+// Package openapi embeds the API description served at /openapi.json.
+// The contract test in this package checks it against the router and DTOs.
+package openapi
+
+import _ "embed"
+
+//go:embed openapi.json
+var Spec []byte
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStore keeps buckets in process. Limits are per instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	fullKey := policy.Name + ":" + key
+
+	b, ok := m.buckets[fullKey]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Burst), updatedAt: now}
+		m.buckets[fullKey] = b
+	}
+
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+elapsed*policy.Rate)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return result(policy, false, b.tokens), nil
+	}
+
+	b.tokens--
+	return result(policy, true, b.tokens), nil
+}
+
+func (m *MemoryStore) Sweep(ctx context.Context, idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.now().Add(-idle)
+	for key, b := range m.buckets {
+		if b.updatedAt.Before(cutoff) {
+			delete(m.buckets, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so every
+// instance enforces the same limits. Each Take is a single atomic upsert.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// The refill expression caps tokens at the burst size. The conditional
+// update only consumes a token when one is available; when it is not, no
+// row is returned and the request is denied.
+const takeSQL = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at)
+VALUES (@key, @burst - 1, now())
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * @rate) - 1,
+    updated_at = now()
+WHERE LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * @rate) >= 1
+RETURNING tokens`
+
+const peekSQL = `
+SELECT LEAST(@burst, tokens + EXTRACT(EPOCH FROM now() - updated_at) * @rate) AS tokens
+FROM rate_limit_buckets
+WHERE key = @key`
+
+func (p *PostgresStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	args := map[string]interface{}{
+		"key":   policy.Name + ":" + key,
+		"burst": float64(policy.Burst),
+		"rate":  policy.Rate,
+	}
+
+	var rows []struct{ Tokens float64 }
+	if err := p.db.WithContext(ctx).Raw(takeSQL, args).Scan(&rows).Error; err != nil {
+		return Result{}, err
+	}
+
+	if len(rows) == 1 {
+		return result(policy, true, rows[0].Tokens), nil
+	}
+
+	var tokens float64
+	if err := p.db.WithContext(ctx).Raw(peekSQL, args).Scan(&tokens).Error; err != nil {
+		return Result{}, err
+	}
+
+	return result(policy, false, tokens), nil
+}
+
+func (p *PostgresStore) Sweep(ctx context.Context, idle time.Duration) error {
+	return p.db.WithContext(ctx).
+		Exec(`DELETE FROM rate_limit_buckets WHERE updated_at < ?`, time.Now().Add(-idle)).
+		Error
+}
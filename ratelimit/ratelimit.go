@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Policy is a token bucket: Burst requests at once, refilled at Rate per
+// second. Name namespaces the buckets so policies never share state.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests per minute with bursts of up to burst
+func PerMinute(name string, n int, burst int) Policy {
+	return Policy{Name: name, Rate: float64(n) / 60, Burst: burst}
+}
+
+// PerHour allows n requests per hour with bursts of up to burst
+func PerHour(name string, n int, burst int) Policy {
+	return Policy{Name: name, Rate: float64(n) / 3600, Burst: burst}
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store keeps bucket state. Take consumes one token for key under policy.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+
+	// Sweep forgets buckets untouched for longer than idle
+	Sweep(ctx context.Context, idle time.Duration) error
+}
+
+// RunSweeper periodically sweeps idle buckets until ctx is done
+func RunSweeper(ctx context.Context, store Store, every time.Duration, idle time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = store.Sweep(ctx, idle)
+		}
+	}
+}
+
+// result builds the Result for a bucket left with tokens after the attempt
+func result(policy Policy, allowed bool, tokens float64) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     policy.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+	}
+
+	if !allowed && policy.Rate > 0 {
+		wait := (1 - tokens) / policy.Rate
+		r.RetryAfter = time.Duration(math.Ceil(wait * float64(time.Second)))
+	}
+
+	return r
+}
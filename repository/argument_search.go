@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultArgumentPageSize = 20
+	MaxArgumentPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ArgumentListParams struct {
+	UserID         uint
+	Status         string
+	Persona        string
+	Winner         string
+	RelationshipID *uint
+	Participant    string
+	From           *time.Time
+	To             *time.Time
+	MinScore       *int
+	MaxScore       *int
+	Query          string
+	Sort           string // created_at | score
+	Order          string // asc | desc
+	Cursor         string
+	Limit          int
+}
+
+// ArgumentSummary is the lightweight list shape. It never carries the
+// transcription or the judge's full response.
+type ArgumentSummary struct {
+	ID                      uint      `json:"id"`
+	RelationshipID          *uint     `json:"relationship_id"`
+	PersonAName             string    `json:"person_a_name"`
+	PersonBName             string    `json:"person_b_name"`
+	Persona                 string    `json:"persona"`
+	Status                  string    `json:"status"`
+	Winner                  *string   `json:"winner"`
+	ConversationHealthScore *int      `json:"conversation_health_score"`
+	CreatedAt               time.Time `json:"created_at"`
+}
+
+type ArgumentPage struct {
+	Arguments  []ArgumentSummary `json:"arguments"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// argumentCursor is the keyset position of the last row on a page. It is
+// only valid for the sort it was issued with.
+type argumentCursor struct {
+	Sort      string    `json:"s"`
+	Order     string    `json:"o"`
+	Score     int       `json:"v,omitempty"`
+	CreatedAt time.Time `json:"t,omitempty"`
+	ID        uint      `json:"id"`
+}
+
+func (params *ArgumentListParams) normalize() {
+	if params.Sort != "score" {
+		params.Sort = "created_at"
+	}
+	if params.Order != "asc" {
+		params.Order = "desc"
+	}
+	if params.Limit <= 0 {
+		params.Limit = DefaultArgumentPageSize
+	}
+	if params.Limit > MaxArgumentPageSize {
+		params.Limit = MaxArgumentPageSize
+	}
+}
+
+// cursor decodes params.Cursor, which may be empty
+func (params ArgumentListParams) cursor() (*argumentCursor, error) {
+	if params.Cursor == "" {
+		return nil, nil
+	}
+
+	cursor, err := decodeArgumentCursor(params.Cursor)
+	if err != nil || cursor.Sort != params.Sort || cursor.Order != params.Order {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// sortScore places unjudged arguments below every real score
+func (summary ArgumentSummary) sortScore() int {
+	if summary.ConversationHealthScore == nil {
+		return -1
+	}
+	return *summary.ConversationHealthScore
+}
+
+// newArgumentPage trims rows fetched with limit+1 to a page and issues the
+// cursor for the next one when there is more.
+func newArgumentPage(rows []ArgumentSummary, params ArgumentListParams) *ArgumentPage {
+	page := &ArgumentPage{Arguments: rows}
+	if page.Arguments == nil {
+		page.Arguments = []ArgumentSummary{}
+	}
+
+	if len(rows) > params.Limit {
+		page.Arguments = rows[:params.Limit]
+		last := page.Arguments[params.Limit-1]
+
+		cursor := argumentCursor{
+			Sort:      params.Sort,
+			Order:     params.Order,
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		}
+		if params.Sort == "score" {
+			cursor.Score = last.sortScore()
+		}
+
+		page.NextCursor = encodeArgumentCursor(cursor)
+	}
+
+	return page
+}
+
+func encodeArgumentCursor(cursor argumentCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeArgumentCursor(encoded string) (*argumentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor argumentCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
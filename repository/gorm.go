@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// NewGorm returns the Postgres-backed repositories
+func NewGorm(db *gorm.DB) *Repositories {
+	return &Repositories{
+		Users:         &gormUserRepo{db: db},
+		Credits:       &gormCreditLedger{db: db},
+		Arguments:     &gormArgumentRepo{db: db},
+		Judgments:     &gormJudgmentRepo{db: db},
+		Media:         &gormMediaBlobRepo{db: db},
+		Relationships: &gormRelationshipRepo{db: db},
+		Devices:       &gormDeviceRepo{db: db},
+		Ping: func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.PingContext(ctx)
+		},
+	}
+}
+
+// notFound maps GORM's sentinel onto ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
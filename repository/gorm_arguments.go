@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"gorm.io/gorm"
+)
+
+// ArgumentEventsChannel is the Postgres channel stage events are sent on
+const ArgumentEventsChannel = "argument_events"
+
+// stageEventPayload is the NOTIFY payload. It matches services.ArgumentEvent,
+// which listeners decode it into.
+type stageEventPayload struct {
+	ID         uint      `json:"id"`
+	ArgumentID uint      `json:"argument_id"`
+	Status     string    `json:"status"`
+	Stage      string    `json:"stage"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type gormArgumentRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormArgumentRepo) Create(ctx context.Context, argument *models.Argument) error {
+	return r.db.WithContext(ctx).Create(argument).Error
+}
+
+func (r *gormArgumentRepo) FindByID(ctx context.Context, id uint) (*models.Argument, error) {
+	var argument models.Argument
+	if err := r.db.WithContext(ctx).First(&argument, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &argument, nil
+}
+
+func (r *gormArgumentRepo) FindForUser(ctx context.Context, id uint, userID uint) (*models.Argument, error) {
+	var argument models.Argument
+	if err := r.db.WithContext(ctx).
+		Preload("Judgment").
+		Where("id = ? AND user_id = ?", id, userID).
+		First(&argument).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &argument, nil
+}
+
+// DeleteForUser removes the argument; its judgment cascades
+func (r *gormArgumentRepo) DeleteForUser(ctx context.Context, id uint, userID uint) error {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", id, userID).
+		Delete(&models.Argument{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormArgumentRepo) List(ctx context.Context, params ArgumentListParams) (*ArgumentPage, error) {
+	params.normalize()
+
+	// Unjudged arguments sort below every real score
+	sortColumn := "arguments.created_at"
+	if params.Sort == "score" {
+		sortColumn = "COALESCE(judgments.conversation_health_score, -1)"
+	}
+
+	query := r.db.WithContext(ctx).
+		Table("arguments").
+		Select(`arguments.id, arguments.relationship_id, arguments.person_a_name,
+			arguments.person_b_name, arguments.persona, arguments.status, arguments.created_at,
+			judgments.winner, judgments.conversation_health_score`).
+		Joins("LEFT JOIN judgments ON judgments.argument_id = arguments.id").
+		Where("arguments.user_id = ?", params.UserID)
+
+	if params.Status != "" {
+		query = query.Where("arguments.status = ?", params.Status)
+	}
+	if params.Persona != "" {
+		query = query.Where("arguments.persona = ?", params.Persona)
+	}
+	if params.Winner != "" {
+		query = query.Where("judgments.winner = ?", params.Winner)
+	}
+	if params.RelationshipID != nil {
+		query = query.Where("arguments.relationship_id = ?", *params.RelationshipID)
+	}
+	if params.Participant != "" {
+		pattern := "%" + escapeLike(params.Participant) + "%"
+		query = query.Where("(arguments.person_a_name ILIKE ? OR arguments.person_b_name ILIKE ?)", pattern, pattern)
+	}
+	if params.From != nil {
+		query = query.Where("arguments.created_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("arguments.created_at < ?", *params.To)
+	}
+	if params.MinScore != nil {
+		query = query.Where("judgments.conversation_health_score >= ?", *params.MinScore)
+	}
+	if params.MaxScore != nil {
+		query = query.Where("judgments.conversation_health_score <= ?", *params.MaxScore)
+	}
+	if params.Query != "" {
+		// Both expressions match the GIN indexes from the migrations
+		query = query.Where(
+			`(to_tsvector('english', arguments.transcription) @@ websearch_to_tsquery('english', ?)
+			OR to_tsvector('english', judgments.reasoning) @@ websearch_to_tsquery('english', ?))`,
+			params.Query, params.Query,
+		)
+	}
+
+	cursor, err := params.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor != nil {
+		comparator := "<"
+		if params.Order == "asc" {
+			comparator = ">"
+		}
+
+		var sortValue interface{} = cursor.CreatedAt
+		if params.Sort == "score" {
+			sortValue = cursor.Score
+		}
+
+		query = query.Where(
+			fmt.Sprintf("(%s, arguments.id) %s (?, ?)", sortColumn, comparator),
+			sortValue, cursor.ID,
+		)
+	}
+
+	direction := strings.ToUpper(params.Order)
+
+	var rows []ArgumentSummary
+	if err := query.
+		Order(fmt.Sprintf("%s %s, arguments.id %s", sortColumn, direction, direction)).
+		Limit(params.Limit + 1).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return newArgumentPage(rows, params), nil
+}
+
+func (r *gormArgumentRepo) ListJudged(ctx context.Context, relationshipID uint, userID uint) ([]models.Argument, error) {
+	var arguments []models.Argument
+
+	if err := r.db.WithContext(ctx).
+		Preload("Judgment").
+		Where("relationship_id = ? AND user_id = ? AND status = ?", relationshipID, userID, "complete").
+		Order("created_at asc").
+		Find(&arguments).Error; err != nil {
+		return nil, err
+	}
+
+	return arguments, nil
+}
+
+// RecordStage also notifies every instance listening on the argument_events
+// channel. The notification is only delivered once the transaction commits.
+func (r *gormArgumentRepo) RecordStage(ctx context.Context, id uint, status string, stage string) (*models.ArgumentEvent, error) {
+	event := models.ArgumentEvent{
+		ArgumentID: id,
+		Status:     status,
+		Stage:      stage,
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Argument{}).
+			Where("id = ?", id).
+			Update("status", status).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(stageEventPayload{
+			ID:         event.ID,
+			ArgumentID: event.ArgumentID,
+			Status:     event.Status,
+			Stage:      event.Stage,
+			CreatedAt:  event.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("SELECT pg_notify(?, ?)", ArgumentEventsChannel, string(payload)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (r *gormArgumentRepo) EventsSince(ctx context.Context, id uint, afterID uint) ([]models.ArgumentEvent, error) {
+	var events []models.ArgumentEvent
+
+	if err := r.db.WithContext(ctx).
+		Where("argument_id = ? AND id > ?", id, afterID).
+		Order("id asc").
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *gormArgumentRepo) MarkResumePending(ctx context.Context, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Argument{}).
+		Where("id = ? AND status = ?", id, "processing").
+		Update("resume_pending", true)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormArgumentRepo) ResumePending(ctx context.Context) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Model(&models.Argument{}).
+		Where("resume_pending = ?", true).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (r *gormArgumentRepo) ClaimResume(ctx context.Context, id uint) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Argument{}).
+		Where("id = ? AND resume_pending = ?", id, true).
+		Update("resume_pending", false)
+	return result.RowsAffected > 0, result.Error
+}
+
+type gormJudgmentRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormJudgmentRepo) Create(ctx context.Context, judgment *models.Judgment) error {
+	return r.db.WithContext(ctx).Create(judgment).Error
+}
+
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
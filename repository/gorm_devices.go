@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"gorm.io/gorm"
+)
+
+type gormDeviceRepo struct {
+	db *gorm.DB
+}
+
+// Register reassigns an existing token because a token belongs to one
+// install, whoever is logged in on it now
+func (r *gormDeviceRepo) Register(ctx context.Context, userID uint, token string, environment string) (*models.Device, error) {
+	db := r.db.WithContext(ctx)
+
+	var device models.Device
+	err := db.Where("token = ?", token).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		device = models.Device{
+			UserID:      userID,
+			Token:       token,
+			Platform:    "ios",
+			Environment: environment,
+		}
+		if err := db.Create(&device).Error; err != nil {
+			return nil, err
+		}
+		return &device, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	device.UserID = userID
+	device.Environment = environment
+	if err := db.Save(&device).Error; err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+func (r *gormDeviceRepo) ListForUser(ctx context.Context, userID uint) ([]models.Device, error) {
+	var devices []models.Device
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&devices).Error
+	return devices, err
+}
+
+func (r *gormDeviceRepo) DeleteForUser(ctx context.Context, token string, userID uint) error {
+	result := r.db.WithContext(ctx).
+		Where("token = ? AND user_id = ?", token, userID).
+		Delete(&models.Device{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormDeviceRepo) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&models.Device{}, id).Error
+}
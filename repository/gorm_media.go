@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"gorm.io/gorm"
+)
+
+type gormMediaBlobRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormMediaBlobRepo) Create(ctx context.Context, blob *models.MediaBlob) error {
+	return r.db.WithContext(ctx).Create(blob).Error
+}
+
+func (r *gormMediaBlobRepo) ListForArgument(ctx context.Context, argumentID uint) ([]models.MediaBlob, error) {
+	var blobs []models.MediaBlob
+	err := r.db.WithContext(ctx).
+		Where("argument_id = ?", argumentID).
+		Order("kind = 'recording' DESC, position, id").
+		Find(&blobs).Error
+	return blobs, err
+}
+
+func (r *gormMediaBlobRepo) FindByKey(ctx context.Context, key string) (*models.MediaBlob, error) {
+	var blob models.MediaBlob
+	if err := r.db.WithContext(ctx).Where("key = ?", key).First(&blob).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &blob, nil
+}
+
+func (r *gormMediaBlobRepo) ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.MediaBlob, error) {
+	var blobs []models.MediaBlob
+	err := r.db.WithContext(ctx).
+		Where("argument_id IS NULL OR created_at < ?", cutoff).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&blobs).Error
+	return blobs, err
+}
+
+func (r *gormMediaBlobRepo) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&models.MediaBlob{}, id).Error
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"gorm.io/gorm"
+)
+
+type gormRelationshipRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormRelationshipRepo) Create(ctx context.Context, relationship *models.Relationship) error {
+	return r.db.WithContext(ctx).Create(relationship).Error
+}
+
+func (r *gormRelationshipRepo) FindForUser(ctx context.Context, id uint, userID uint) (*models.Relationship, error) {
+	var relationship models.Relationship
+	if err := r.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", id, userID).
+		First(&relationship).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &relationship, nil
+}
+
+func (r *gormRelationshipRepo) ListForUser(ctx context.Context, userID uint) ([]models.Relationship, error) {
+	var relationships []models.Relationship
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&relationships).Error
+	return relationships, err
+}
+
+// Delete keeps the relationship's arguments; their relationship_id is set
+// to NULL
+func (r *gormRelationshipRepo) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&models.Relationship{}, id).Error
+}
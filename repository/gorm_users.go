@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"gorm.io/gorm"
+)
+
+type gormUserRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepo) Create(ctx context.Context, user *models.User) error {
+	err := r.db.WithContext(ctx).Create(user).Error
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func (r *gormUserRepo) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&models.User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormUserRepo) SetPremium(ctx context.Context, id uint, premium bool) error {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ?", id).
+		Update("is_premium", premium)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormUserRepo) UpdateNotificationPreferences(ctx context.Context, id uint, onComplete *bool, onFailure *bool) (*models.User, error) {
+	user, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map updates so that false is written rather than skipped
+	updates := map[string]interface{}{}
+	if onComplete != nil {
+		updates["notify_on_complete"] = *onComplete
+		user.NotifyOnComplete = *onComplete
+	}
+	if onFailure != nil {
+		updates["notify_on_failure"] = *onFailure
+		user.NotifyOnFailure = *onFailure
+	}
+
+	if len(updates) > 0 {
+		if err := r.db.WithContext(ctx).Model(user).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+type gormCreditLedger struct {
+	db *gorm.DB
+}
+
+func (l *gormCreditLedger) Balance(ctx context.Context, userID uint) (int, error) {
+	var user models.User
+	if err := l.db.WithContext(ctx).Select("credits").First(&user, userID).Error; err != nil {
+		return 0, notFound(err)
+	}
+	return user.Credits, nil
+}
+
+func (l *gormCreditLedger) Consume(ctx context.Context, userID uint) error {
+	result := l.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND credits > 0", userID).
+		Update("credits", gorm.Expr("credits - 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoCredits
+	}
+	return nil
+}
+
+func (l *gormCreditLedger) SetBalance(ctx context.Context, userID uint, credits int) error {
+	result := l.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("credits", credits)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
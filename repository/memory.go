@@ -0,0 +1,757 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+)
+
+// memoryStore backs the in-memory repositories. Rows are copied in and out
+// so callers can't mutate stored state, and zero values get the same
+// defaults the database columns have.
+type memoryStore struct {
+	mu     sync.Mutex
+	nextID uint
+
+	users         map[uint]models.User
+	arguments     map[uint]models.Argument
+	judgments     map[uint]models.Judgment // keyed by argument ID
+	relationships map[uint]models.Relationship
+	devices       map[uint]models.Device
+	media         map[uint]models.MediaBlob
+	events        []models.ArgumentEvent
+
+	onStageEvent func(models.ArgumentEvent)
+}
+
+// NewMemory returns repositories that keep everything in process, for tests
+// and local runs without Postgres. onStageEvent, if set, receives every
+// recorded stage event, standing in for LISTEN/NOTIFY.
+func NewMemory(onStageEvent func(models.ArgumentEvent)) *Repositories {
+	store := &memoryStore{
+		users:         make(map[uint]models.User),
+		arguments:     make(map[uint]models.Argument),
+		judgments:     make(map[uint]models.Judgment),
+		relationships: make(map[uint]models.Relationship),
+		devices:       make(map[uint]models.Device),
+		media:         make(map[uint]models.MediaBlob),
+		onStageEvent:  onStageEvent,
+	}
+
+	return &Repositories{
+		Users:         &memoryUserRepo{store},
+		Credits:       &memoryCreditLedger{store},
+		Arguments:     &memoryArgumentRepo{store},
+		Judgments:     &memoryJudgmentRepo{store},
+		Media:         &memoryMediaBlobRepo{store},
+		Relationships: &memoryRelationshipRepo{store},
+		Devices:       &memoryDeviceRepo{store},
+		Ping:          func(ctx context.Context) error { return nil },
+	}
+}
+
+// id must be called with mu held
+func (s *memoryStore) id() uint {
+	s.nextID++
+	return s.nextID
+}
+
+func stamp(createdAt *time.Time) {
+	if createdAt.IsZero() {
+		*createdAt = time.Now()
+	}
+}
+
+type memoryUserRepo struct{ s *memoryStore }
+
+func (r *memoryUserRepo) Create(ctx context.Context, user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.users {
+		if existing.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+
+	user.ID = r.s.id()
+	if user.Credits == 0 {
+		user.Credits = 1
+	}
+	if !user.NotifyOnComplete {
+		user.NotifyOnComplete = true
+	}
+	if !user.NotifyOnFailure {
+		user.NotifyOnFailure = true
+	}
+	stamp(&user.CreatedAt)
+	user.UpdatedAt = user.CreatedAt
+
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUserRepo) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *memoryUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, user := range r.s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete cascades like the foreign keys: arguments, their judgments and
+// events, relationships and devices go with the user
+func (r *memoryUserRepo) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.s.users, id)
+
+	for argumentID, argument := range r.s.arguments {
+		if argument.UserID == id {
+			r.s.deleteArgument(argumentID)
+		}
+	}
+	for relationshipID, relationship := range r.s.relationships {
+		if relationship.UserID == id {
+			delete(r.s.relationships, relationshipID)
+		}
+	}
+	for deviceID, device := range r.s.devices {
+		if device.UserID == id {
+			delete(r.s.devices, deviceID)
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryUserRepo) SetPremium(ctx context.Context, id uint, premium bool) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.IsPremium = premium
+	r.s.users[id] = user
+	return nil
+}
+
+func (r *memoryUserRepo) UpdateNotificationPreferences(ctx context.Context, id uint, onComplete *bool, onFailure *bool) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if onComplete != nil {
+		user.NotifyOnComplete = *onComplete
+	}
+	if onFailure != nil {
+		user.NotifyOnFailure = *onFailure
+	}
+	r.s.users[id] = user
+	return &user, nil
+}
+
+type memoryCreditLedger struct{ s *memoryStore }
+
+func (l *memoryCreditLedger) Balance(ctx context.Context, userID uint) (int, error) {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	user, ok := l.s.users[userID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return user.Credits, nil
+}
+
+func (l *memoryCreditLedger) Consume(ctx context.Context, userID uint) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	user, ok := l.s.users[userID]
+	if !ok || user.Credits <= 0 {
+		return ErrNoCredits
+	}
+	user.Credits--
+	l.s.users[userID] = user
+	return nil
+}
+
+func (l *memoryCreditLedger) SetBalance(ctx context.Context, userID uint, credits int) error {
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	user, ok := l.s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Credits = credits
+	l.s.users[userID] = user
+	return nil
+}
+
+type memoryArgumentRepo struct{ s *memoryStore }
+
+func (r *memoryArgumentRepo) Create(ctx context.Context, argument *models.Argument) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument.ID = r.s.id()
+	if argument.Status == "" {
+		argument.Status = "processing"
+	}
+	if argument.Persona == "" {
+		argument.Persona = "mediator"
+	}
+	stamp(&argument.CreatedAt)
+
+	stored := *argument
+	stored.User = models.User{}
+	stored.Judgment = nil
+	r.s.arguments[argument.ID] = stored
+	return nil
+}
+
+func (r *memoryArgumentRepo) FindByID(ctx context.Context, id uint) (*models.Argument, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &argument, nil
+}
+
+func (r *memoryArgumentRepo) FindForUser(ctx context.Context, id uint, userID uint) (*models.Argument, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok || argument.UserID != userID {
+		return nil, ErrNotFound
+	}
+	if judgment, ok := r.s.judgments[id]; ok {
+		argument.Judgment = &judgment
+	}
+	return &argument, nil
+}
+
+func (r *memoryArgumentRepo) DeleteForUser(ctx context.Context, id uint, userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok || argument.UserID != userID {
+		return ErrNotFound
+	}
+	r.s.deleteArgument(id)
+	return nil
+}
+
+// deleteArgument must be called with mu held. Media is detached, not
+// deleted, like the ON DELETE SET NULL foreign key.
+func (s *memoryStore) deleteArgument(id uint) {
+	delete(s.arguments, id)
+	delete(s.judgments, id)
+
+	for blobID, blob := range s.media {
+		if blob.ArgumentID != nil && *blob.ArgumentID == id {
+			blob.ArgumentID = nil
+			s.media[blobID] = blob
+		}
+	}
+
+	kept := s.events[:0]
+	for _, event := range s.events {
+		if event.ArgumentID != id {
+			kept = append(kept, event)
+		}
+	}
+	s.events = kept
+}
+
+// List applies the same filters as the Postgres query. Full-text search is
+// approximated: every word of the query must appear in the transcription or
+// the reasoning.
+func (r *memoryArgumentRepo) List(ctx context.Context, params ArgumentListParams) (*ArgumentPage, error) {
+	params.normalize()
+
+	cursor, err := params.cursor()
+	if err != nil {
+		return nil, err
+	}
+
+	r.s.mu.Lock()
+	var rows []ArgumentSummary
+	for _, argument := range r.s.arguments {
+		judgment, judged := r.s.judgments[argument.ID]
+		if !matchesListParams(argument, judgment, judged, params) {
+			continue
+		}
+
+		summary := ArgumentSummary{
+			ID:             argument.ID,
+			RelationshipID: argument.RelationshipID,
+			PersonAName:    argument.PersonAName,
+			PersonBName:    argument.PersonBName,
+			Persona:        argument.Persona,
+			Status:         argument.Status,
+			CreatedAt:      argument.CreatedAt,
+		}
+		if judged {
+			winner, score := judgment.Winner, judgment.ConversationHealthScore
+			summary.Winner = &winner
+			summary.ConversationHealthScore = &score
+		}
+		rows = append(rows, summary)
+	}
+	r.s.mu.Unlock()
+
+	// less reports whether a sorts before b in ascending order
+	less := func(a, b ArgumentSummary) bool {
+		if params.Sort == "score" && a.sortScore() != b.sortScore() {
+			return a.sortScore() < b.sortScore()
+		}
+		if params.Sort == "created_at" && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	}
+	if params.Order == "desc" {
+		ascending := less
+		less = func(a, b ArgumentSummary) bool { return ascending(b, a) }
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+
+	if cursor != nil {
+		position := ArgumentSummary{ID: cursor.ID, CreatedAt: cursor.CreatedAt}
+		if params.Sort == "score" {
+			score := cursor.Score
+			position.ConversationHealthScore = &score
+		}
+
+		start := sort.Search(len(rows), func(i int) bool { return less(position, rows[i]) })
+		rows = rows[start:]
+	}
+
+	if len(rows) > params.Limit+1 {
+		rows = rows[:params.Limit+1]
+	}
+
+	return newArgumentPage(rows, params), nil
+}
+
+func matchesListParams(argument models.Argument, judgment models.Judgment, judged bool, params ArgumentListParams) bool {
+	if argument.UserID != params.UserID {
+		return false
+	}
+	if params.Status != "" && argument.Status != params.Status {
+		return false
+	}
+	if params.Persona != "" && argument.Persona != params.Persona {
+		return false
+	}
+	if params.RelationshipID != nil && (argument.RelationshipID == nil || *argument.RelationshipID != *params.RelationshipID) {
+		return false
+	}
+	if params.Participant != "" {
+		participant := strings.ToLower(params.Participant)
+		if !strings.Contains(strings.ToLower(argument.PersonAName), participant) &&
+			!strings.Contains(strings.ToLower(argument.PersonBName), participant) {
+			return false
+		}
+	}
+	if params.From != nil && argument.CreatedAt.Before(*params.From) {
+		return false
+	}
+	if params.To != nil && !argument.CreatedAt.Before(*params.To) {
+		return false
+	}
+
+	// Filters on the judgment exclude unjudged arguments, as the SQL does
+	if params.Winner != "" && (!judged || judgment.Winner != params.Winner) {
+		return false
+	}
+	if params.MinScore != nil && (!judged || judgment.ConversationHealthScore < *params.MinScore) {
+		return false
+	}
+	if params.MaxScore != nil && (!judged || judgment.ConversationHealthScore > *params.MaxScore) {
+		return false
+	}
+
+	if params.Query != "" {
+		text := strings.ToLower(argument.Transcription + " " + judgment.Reasoning)
+		for _, word := range strings.Fields(strings.ToLower(params.Query)) {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *memoryArgumentRepo) ListJudged(ctx context.Context, relationshipID uint, userID uint) ([]models.Argument, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var arguments []models.Argument
+	for _, argument := range r.s.arguments {
+		if argument.UserID != userID || argument.Status != "complete" ||
+			argument.RelationshipID == nil || *argument.RelationshipID != relationshipID {
+			continue
+		}
+		if judgment, ok := r.s.judgments[argument.ID]; ok {
+			argument.Judgment = &judgment
+		}
+		arguments = append(arguments, argument)
+	}
+
+	sort.Slice(arguments, func(i, j int) bool {
+		return arguments[i].CreatedAt.Before(arguments[j].CreatedAt)
+	})
+
+	return arguments, nil
+}
+
+func (r *memoryArgumentRepo) RecordStage(ctx context.Context, id uint, status string, stage string) (*models.ArgumentEvent, error) {
+	r.s.mu.Lock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok {
+		r.s.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	argument.Status = status
+	r.s.arguments[id] = argument
+
+	event := models.ArgumentEvent{
+		ID:         r.s.id(),
+		ArgumentID: id,
+		Status:     status,
+		Stage:      stage,
+		CreatedAt:  time.Now(),
+	}
+	r.s.events = append(r.s.events, event)
+
+	r.s.mu.Unlock()
+
+	if r.s.onStageEvent != nil {
+		r.s.onStageEvent(event)
+	}
+
+	return &event, nil
+}
+
+func (r *memoryArgumentRepo) EventsSince(ctx context.Context, id uint, afterID uint) ([]models.ArgumentEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var events []models.ArgumentEvent
+	for _, event := range r.s.events {
+		if event.ArgumentID == id && event.ID > afterID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryArgumentRepo) MarkResumePending(ctx context.Context, id uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok || argument.Status != "processing" {
+		return false, nil
+	}
+	argument.ResumePending = true
+	r.s.arguments[id] = argument
+	return true, nil
+}
+
+func (r *memoryArgumentRepo) ResumePending(ctx context.Context) ([]uint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var ids []uint
+	for id, argument := range r.s.arguments {
+		if argument.ResumePending {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *memoryArgumentRepo) ClaimResume(ctx context.Context, id uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	argument, ok := r.s.arguments[id]
+	if !ok || !argument.ResumePending {
+		return false, nil
+	}
+	argument.ResumePending = false
+	r.s.arguments[id] = argument
+	return true, nil
+}
+
+type memoryJudgmentRepo struct{ s *memoryStore }
+
+func (r *memoryJudgmentRepo) Create(ctx context.Context, judgment *models.Judgment) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.arguments[judgment.ArgumentID]; !ok {
+		return ErrNotFound
+	}
+
+	judgment.ID = r.s.id()
+	stamp(&judgment.CreatedAt)
+
+	stored := *judgment
+	stored.Argument = nil
+	r.s.judgments[judgment.ArgumentID] = stored
+	return nil
+}
+
+type memoryRelationshipRepo struct{ s *memoryStore }
+
+func (r *memoryRelationshipRepo) Create(ctx context.Context, relationship *models.Relationship) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	relationship.ID = r.s.id()
+	stamp(&relationship.CreatedAt)
+	relationship.UpdatedAt = relationship.CreatedAt
+
+	stored := *relationship
+	stored.User = models.User{}
+	stored.Arguments = nil
+	r.s.relationships[relationship.ID] = stored
+	return nil
+}
+
+func (r *memoryRelationshipRepo) FindForUser(ctx context.Context, id uint, userID uint) (*models.Relationship, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	relationship, ok := r.s.relationships[id]
+	if !ok || relationship.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return &relationship, nil
+}
+
+func (r *memoryRelationshipRepo) ListForUser(ctx context.Context, userID uint) ([]models.Relationship, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var relationships []models.Relationship
+	for _, relationship := range r.s.relationships {
+		if relationship.UserID == userID {
+			relationships = append(relationships, relationship)
+		}
+	}
+
+	sort.Slice(relationships, func(i, j int) bool {
+		return relationships[i].CreatedAt.After(relationships[j].CreatedAt)
+	})
+
+	return relationships, nil
+}
+
+func (r *memoryRelationshipRepo) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.relationships, id)
+
+	for argumentID, argument := range r.s.arguments {
+		if argument.RelationshipID != nil && *argument.RelationshipID == id {
+			argument.RelationshipID = nil
+			r.s.arguments[argumentID] = argument
+		}
+	}
+
+	return nil
+}
+
+type memoryDeviceRepo struct{ s *memoryStore }
+
+func (r *memoryDeviceRepo) Register(ctx context.Context, userID uint, token string, environment string) (*models.Device, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, device := range r.s.devices {
+		if device.Token == token {
+			device.UserID = userID
+			device.Environment = environment
+			device.UpdatedAt = time.Now()
+			r.s.devices[id] = device
+			return &device, nil
+		}
+	}
+
+	device := models.Device{
+		ID:          r.s.id(),
+		UserID:      userID,
+		Token:       token,
+		Platform:    "ios",
+		Environment: environment,
+		CreatedAt:   time.Now(),
+	}
+	device.UpdatedAt = device.CreatedAt
+	r.s.devices[device.ID] = device
+
+	return &device, nil
+}
+
+func (r *memoryDeviceRepo) ListForUser(ctx context.Context, userID uint) ([]models.Device, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var devices []models.Device
+	for _, device := range r.s.devices {
+		if device.UserID == userID {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+func (r *memoryDeviceRepo) DeleteForUser(ctx context.Context, token string, userID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, device := range r.s.devices {
+		if device.Token == token && device.UserID == userID {
+			delete(r.s.devices, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryDeviceRepo) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.devices, id)
+	return nil
+}
+
+type memoryMediaBlobRepo struct{ s *memoryStore }
+
+func (r *memoryMediaBlobRepo) Create(ctx context.Context, blob *models.MediaBlob) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.media {
+		if existing.Key == blob.Key {
+			return fmt.Errorf("media blob key %q already recorded", blob.Key)
+		}
+	}
+
+	blob.ID = r.s.id()
+	stamp(&blob.CreatedAt)
+	r.s.media[blob.ID] = *blob
+	return nil
+}
+
+func (r *memoryMediaBlobRepo) ListForArgument(ctx context.Context, argumentID uint) ([]models.MediaBlob, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	blobs := []models.MediaBlob{}
+	for _, blob := range r.s.media {
+		if blob.ArgumentID != nil && *blob.ArgumentID == argumentID {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		a, b := blobs[i], blobs[j]
+		if (a.Kind == models.MediaKindRecording) != (b.Kind == models.MediaKindRecording) {
+			return a.Kind == models.MediaKindRecording
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.ID < b.ID
+	})
+	return blobs, nil
+}
+
+func (r *memoryMediaBlobRepo) FindByKey(ctx context.Context, key string) (*models.MediaBlob, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, blob := range r.s.media {
+		if blob.Key == key {
+			return &blob, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryMediaBlobRepo) ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.MediaBlob, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var blobs []models.MediaBlob
+	for _, blob := range r.s.media {
+		if blob.ArgumentID == nil || blob.CreatedAt.Before(cutoff) {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		if !blobs[i].CreatedAt.Equal(blobs[j].CreatedAt) {
+			return blobs[i].CreatedAt.Before(blobs[j].CreatedAt)
+		}
+		return blobs[i].ID < blobs[j].ID
+	})
+	if len(blobs) > limit {
+		blobs = blobs[:limit]
+	}
+	return blobs, nil
+}
+
+func (r *memoryMediaBlobRepo) Delete(ctx context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.media, id)
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package repository is the only code that talks to the database. Handlers
+// and the judgment pipeline depend on these interfaces; main wires in the
+// GORM implementations and tests use the in-memory ones.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+)
+
+var (
+	ErrNotFound   = errors.New("record not found")
+	ErrEmailTaken = errors.New("email already registered")
+	ErrNoCredits  = errors.New("no credits remaining")
+)
+
+type UserRepo interface {
+	// Create returns ErrEmailTaken when the email is already registered
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id uint) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	Delete(ctx context.Context, id uint) error
+	SetPremium(ctx context.Context, id uint, premium bool) error
+	// UpdateNotificationPreferences leaves nil preferences unchanged
+	UpdateNotificationPreferences(ctx context.Context, id uint, onComplete *bool, onFailure *bool) (*models.User, error)
+}
+
+// CreditLedger owns the credit balance. Consume is atomic, so two uploads
+// racing for the last credit cannot both succeed.
+type CreditLedger interface {
+	Balance(ctx context.Context, userID uint) (int, error)
+	// Consume takes one credit or returns ErrNoCredits
+	Consume(ctx context.Context, userID uint) error
+	SetBalance(ctx context.Context, userID uint, credits int) error
+}
+
+type ArgumentRepo interface {
+	Create(ctx context.Context, argument *models.Argument) error
+	FindByID(ctx context.Context, id uint) (*models.Argument, error)
+	// FindForUser loads the argument with its judgment, scoped to its owner
+	FindForUser(ctx context.Context, id uint, userID uint) (*models.Argument, error)
+	DeleteForUser(ctx context.Context, id uint, userID uint) error
+	List(ctx context.Context, params ArgumentListParams) (*ArgumentPage, error)
+	// ListJudged returns the complete arguments of a relationship with their
+	// judgments, oldest first
+	ListJudged(ctx context.Context, relationshipID uint, userID uint) ([]models.Argument, error)
+
+	// RecordStage sets the argument status and appends a stage event
+	RecordStage(ctx context.Context, id uint, status string, stage string) (*models.ArgumentEvent, error)
+	EventsSince(ctx context.Context, id uint, afterID uint) ([]models.ArgumentEvent, error)
+
+	// MarkResumePending flags a processing argument for another instance to
+	// pick up and reports whether it was flagged
+	MarkResumePending(ctx context.Context, id uint) (bool, error)
+	ResumePending(ctx context.Context) ([]uint, error)
+	// ClaimResume clears the flag and reports whether this caller cleared it
+	ClaimResume(ctx context.Context, id uint) (bool, error)
+}
+
+type JudgmentRepo interface {
+	Create(ctx context.Context, judgment *models.Judgment) error
+}
+
+type MediaBlobRepo interface {
+	Create(ctx context.Context, blob *models.MediaBlob) error
+	// ListForArgument returns the recording first, then the rest by position
+	ListForArgument(ctx context.Context, argumentID uint) ([]models.MediaBlob, error)
+	FindByKey(ctx context.Context, key string) (*models.MediaBlob, error)
+	// ListExpired returns blobs created before cutoff or detached from
+	// their argument, oldest first
+	ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.MediaBlob, error)
+	Delete(ctx context.Context, id uint) error
+}
+
+type RelationshipRepo interface {
+	Create(ctx context.Context, relationship *models.Relationship) error
+	FindForUser(ctx context.Context, id uint, userID uint) (*models.Relationship, error)
+	ListForUser(ctx context.Context, userID uint) ([]models.Relationship, error)
+	Delete(ctx context.Context, id uint) error
+}
+
+type DeviceRepo interface {
+	// Register upserts by token, moving it to userID if another user had it
+	Register(ctx context.Context, userID uint, token string, environment string) (*models.Device, error)
+	ListForUser(ctx context.Context, userID uint) ([]models.Device, error)
+	DeleteForUser(ctx context.Context, token string, userID uint) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// Repositories is the set of stores handed to handlers and the pipeline
+type Repositories struct {
+	Users         UserRepo
+	Credits       CreditLedger
+	Arguments     ArgumentRepo
+	Judgments     JudgmentRepo
+	Media         MediaBlobRepo
+	Relationships RelationshipRepo
+	Devices       DeviceRepo
+
+	// Ping reports whether the backing store is reachable
+	Ping func(ctx context.Context) error
+}
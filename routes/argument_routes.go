@@ -3,17 +3,38 @@ package routes
 import (
 	"github.com/calebchiang/thirdparty_server/controllers"
 	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
 	"github.com/gin-gonic/gin"
 )
 
-func ArgumentRoutes(r *gin.Engine) {
+// Uploads are checked per user and per IP so one account can't be used
+// from many addresses, nor many accounts from one address. They count when
+// they become an argument, however the bytes arrived.
+var (
+	uploadUserLimit = ratelimit.PerHour("upload_user", 30, 5)
+	uploadIPLimit   = ratelimit.PerHour("upload_ip", 60, 10)
+
+	// Uncached clips run ffmpeg
+	clipUserLimit = ratelimit.PerMinute("clip_user", 30, 10)
+)
+
+func ArgumentRoutes(r *gin.Engine, limiter *middleware.RateLimiter, arguments *controllers.ArgumentController) {
+	uploadLimits := []gin.HandlerFunc{
+		limiter.Limit(uploadIPLimit, middleware.KeyByIP),
+		limiter.Limit(uploadUserLimit, middleware.KeyByUser),
+	}
+
 	auth := r.Group("/arguments")
 	auth.Use(middleware.RequireAuth())
 	{
-		auth.GET("", controllers.GetArguments)
-		auth.GET("/:id", controllers.GetArgumentByID)
-		auth.POST("", controllers.CreateArgument)
-		auth.DELETE("/:id", controllers.DeleteArgument)
-		auth.POST("/screenshot", controllers.CreateArgumentByScreenshot)
+		auth.GET("", arguments.GetArguments)
+		auth.GET("/:id", arguments.GetArgumentByID)
+		auth.GET("/:id/events", arguments.GetArgumentEvents)
+		auth.GET("/:id/media", arguments.GetArgumentMedia)
+		auth.GET("/:id/audio", arguments.GetArgumentAudio)
+		auth.GET("/:id/clips", limiter.Limit(clipUserLimit, middleware.KeyByUser), arguments.GetArgumentClip)
+		auth.POST("", append(uploadLimits, arguments.CreateArgument)...)
+		auth.DELETE("/:id", arguments.DeleteArgument)
+		auth.POST("/screenshot", append(uploadLimits, arguments.CreateArgumentByScreenshot)...)
 	}
 }
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// Blob URLs carry their own signature instead of a bearer token, so they
+// work in players and image views that can't set headers
+func BlobRoutes(r *gin.Engine, blobs *controllers.BlobController) {
+	r.GET("/blobs/*key", blobs.DownloadBlob)
+}
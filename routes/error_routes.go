@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func ErrorRoutes(r *gin.Engine) {
+	r.GET("/error-codes", controllers.GetErrorCodes)
+	r.NoRoute(middleware.NotFound)
+}
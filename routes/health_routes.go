@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func HealthRoutes(r *gin.Engine, health *controllers.HealthController) {
+	r.GET("/healthz", health.Healthz)
+	r.GET("/readyz", health.Readyz)
+}
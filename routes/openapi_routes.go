@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/calebchiang/thirdparty_server/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+func OpenAPIRoutes(r *gin.Engine) {
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openapi.Spec)
+	})
+}
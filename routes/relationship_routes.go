@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RelationshipRoutes(r *gin.Engine, relationships *controllers.RelationshipController) {
+	auth := r.Group("/relationships")
+	auth.Use(middleware.RequireAuth())
+	{
+		auth.GET("", relationships.GetRelationships)
+		auth.GET("/:id", relationships.GetRelationshipByID)
+		auth.POST("", relationships.CreateRelationship)
+		auth.DELETE("/:id", relationships.DeleteRelationship)
+		auth.GET("/:id/trends", relationships.GetRelationshipTrends)
+	}
+}
@@ -5,6 +5,6 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RevenueCatRoutes(r *gin.Engine) {
-	r.POST("/revenuecat/webhook", controllers.RevenueCatWebhook)
+func RevenueCatRoutes(r *gin.Engine, revenueCat *controllers.RevenueCatController) {
+	r.POST("/revenuecat/webhook", revenueCat.RevenueCatWebhook)
 }
@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/services"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// Handlers holds the controllers the routes are bound to
+type Handlers struct {
+	Users         *controllers.UserController
+	Arguments     *controllers.ArgumentController
+	Relationships *controllers.RelationshipController
+	Devices       *controllers.DeviceController
+	RevenueCat    *controllers.RevenueCatController
+	Health        *controllers.HealthController
+	Uploads       *controllers.UploadController
+	Blobs         *controllers.BlobController
+
+	Limiter *middleware.RateLimiter
+}
+
+// Dependencies are what main (or a test) wires the handlers to
+type Dependencies struct {
+	Repos       *repository.Repositories
+	Pipeline    *services.Pipeline
+	Media       services.MediaNormalizer
+	Clips       services.ClipCutter
+	Frames      services.FrameExtractor
+	Transcriber services.Transcriber
+	Uploads     *services.UploadStore
+	Archive     *services.MediaArchive
+	Events      *services.EventBroker
+	RateLimits  ratelimit.Store
+}
+
+// NewHandlers constructs every controller from the same set of dependencies
+func NewHandlers(deps Dependencies) *Handlers {
+	repos := deps.Repos
+
+	return &Handlers{
+		Users:         controllers.NewUserController(repos.Users),
+		Arguments:     controllers.NewArgumentController(repos, deps.Pipeline, deps.Media, deps.Clips, deps.Frames, deps.Transcriber, deps.Uploads, deps.Archive, deps.Events),
+		Relationships: controllers.NewRelationshipController(repos),
+		Devices:       controllers.NewDeviceController(repos),
+		RevenueCat:    controllers.NewRevenueCatController(repos),
+		Health:        controllers.NewHealthController(repos.Ping, deps.Pipeline.Jobs),
+		Uploads:       controllers.NewUploadController(repos, deps.Uploads),
+		Blobs:         controllers.NewBlobController(deps.Archive.Blobs),
+
+		Limiter: middleware.NewRateLimiter(deps.RateLimits),
+	}
+}
+
+// NewRouter builds the engine with the global middleware and every route
+func NewRouter(serviceName string, h *Handlers) *gin.Engine {
+	r := gin.New()
+	r.Use(
+		otelgin.Middleware(serviceName),
+		middleware.RequestID(),
+		middleware.RequestLogger(),
+		middleware.Metrics(),
+		middleware.ErrorHandler(),
+		middleware.Recovery(),
+	)
+
+	UserRoutes(r, h.Limiter, h.Users, h.Devices)
+	ArgumentRoutes(r, h.Limiter, h.Arguments)
+	UploadRoutes(r, h.Limiter, h.Uploads)
+	BlobRoutes(r, h.Blobs)
+	RelationshipRoutes(r, h.Relationships)
+	RevenueCatRoutes(r, h.RevenueCat)
+	MetricsRoutes(r)
+	HealthRoutes(r, h.Health)
+	ErrorRoutes(r)
+	OpenAPIRoutes(r)
+
+	return r
+}
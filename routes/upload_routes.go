@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/calebchiang/thirdparty_server/controllers"
+	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// Resumable uploads hold disk space until they expire. Their own bucket caps
+// that; the upload limits are charged once, when POST /arguments turns the
+// upload into an argument.
+var resumableUploadUserLimit = ratelimit.PerHour("resumable_upload_user", 30, 5)
+
+func UploadRoutes(r *gin.Engine, limiter *middleware.RateLimiter, uploads *controllers.UploadController) {
+	r.OPTIONS("/uploads", uploads.Options)
+
+	auth := r.Group("/uploads")
+	auth.Use(uploads.RequireTusResumable, middleware.RequireAuth())
+	{
+		// Creating an upload is limited; the chunks that follow aren't
+		auth.POST("", limiter.Limit(resumableUploadUserLimit, middleware.KeyByUser), uploads.CreateUpload)
+		auth.HEAD("/:id", uploads.GetUploadOffset)
+		auth.PATCH("/:id", uploads.AppendUpload)
+		auth.DELETE("/:id", uploads.DeleteUpload)
+	}
+}
@@ -3,18 +3,28 @@ package routes
 import (
 	"github.com/calebchiang/thirdparty_server/controllers"
 	"github.com/calebchiang/thirdparty_server/middleware"
+	"github.com/calebchiang/thirdparty_server/ratelimit"
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(r *gin.Engine) {
-	r.POST("/users", controllers.CreateUser)
-	r.POST("/login", controllers.LoginUser)
-	r.POST("/apple_login", controllers.AppleLogin)
+var (
+	loginLimit  = ratelimit.PerMinute("login", 10, 10)
+	signupLimit = ratelimit.PerHour("signup", 20, 5)
+)
+
+func UserRoutes(r *gin.Engine, limiter *middleware.RateLimiter, users *controllers.UserController, devices *controllers.DeviceController) {
+	r.POST("/users", limiter.Limit(signupLimit, middleware.KeyByIP), users.CreateUser)
+	r.POST("/login", limiter.Limit(loginLimit, middleware.KeyByIP), users.LoginUser)
+	r.POST("/apple_login", limiter.Limit(loginLimit, middleware.KeyByIP), users.AppleLogin)
 
 	auth := r.Group("/users")
 	auth.Use(middleware.RequireAuth())
 	{
-		auth.GET("/me", controllers.GetCurrentUser)
-		auth.DELETE("/me", controllers.DeleteCurrentUser)
+		auth.GET("/me", users.GetCurrentUser)
+		auth.DELETE("/me", users.DeleteCurrentUser)
+		auth.POST("/me/devices", devices.RegisterDevice)
+		auth.DELETE("/me/devices/:token", devices.DeleteDevice)
+		auth.GET("/me/notifications", devices.GetNotificationPreferences)
+		auth.PUT("/me/notifications", devices.UpdateNotificationPreferences)
 	}
 }
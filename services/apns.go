@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	apnsProductionHost = "https://api.push.apple.com"
+	apnsSandboxHost    = "https://api.sandbox.push.apple.com"
+
+	// Apple rejects provider tokens older than an hour and throttles
+	// refreshes more often than every 20 minutes.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsNotifier delivers notifications over HTTP/2 using token-based
+// (.p8 signing key) provider authentication.
+type APNsNotifier struct {
+	keyID  string
+	teamID string
+	topic  string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func NewAPNsNotifier(keyPath string, keyID string, teamID string, topic string) (*APNsNotifier, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("APNs key ID, team ID and topic are required")
+	}
+
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs signing key: %v", err)
+	}
+
+	return &APNsNotifier{
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		key:    key,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				ForceAttemptHTTP2: true,
+				TLSClientConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+			},
+		},
+	}, nil
+}
+
+func (a *APNsNotifier) providerToken(forceRefresh bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !forceRefresh && a.token != "" && time.Since(a.issuedAt) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyID
+
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+
+	a.token = signed
+	a.issuedAt = now
+
+	return signed, nil
+}
+
+func (a *APNsNotifier) Send(ctx context.Context, device models.Device, notification Notification) error {
+
+	payload := map[string]interface{}{}
+	for k, v := range notification.Data {
+		payload[k] = v
+	}
+	payload["aps"] = map[string]interface{}{
+		"alert": map[string]string{
+			"title": notification.Title,
+			"body":  notification.Body,
+		},
+		"sound": "default",
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	host := apnsProductionHost
+	if device.Environment == "sandbox" {
+		host = apnsSandboxHost
+	}
+
+	reason, status, err := a.post(ctx, host+"/3/device/"+device.Token, body, false)
+	if err != nil {
+		return err
+	}
+
+	// The cached provider token was rejected; retry once with a fresh one
+	if status == http.StatusForbidden && reason == "ExpiredProviderToken" {
+		reason, status, err = a.post(ctx, host+"/3/device/"+device.Token, body, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status == http.StatusGone,
+		reason == "BadDeviceToken",
+		reason == "DeviceTokenNotForTopic",
+		reason == "Unregistered":
+		return ErrInvalidDeviceToken
+	default:
+		return fmt.Errorf("APNs error: status %d, reason %s", status, reason)
+	}
+}
+
+func (a *APNsNotifier) post(ctx context.Context, url string, body []byte, forceRefresh bool) (string, int, error) {
+	token, err := a.providerToken(forceRefresh)
+	if err != nil {
+		return "", 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return "", resp.StatusCode, nil
+	}
+
+	var apnsError struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&apnsError)
+
+	return apnsError.Reason, resp.StatusCode, nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/google/uuid"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps original recordings and screenshots after the request that
+// uploaded them, so they can be re-processed or shown to their owner.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Open returns ErrBlobNotFound for missing keys. The object is seekable
+	// so ranges can be served from it.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete succeeds for keys that are already gone
+	Delete(ctx context.Context, key string) error
+	// SignedURL lets anyone holding it download the object until it expires
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// NewBlobStore builds the store named by cfg.BlobBackend
+func NewBlobStore(ctx context.Context, cfg *config.Config) (BlobStore, error) {
+	switch cfg.BlobBackend {
+	case "local":
+		return NewLocalBlobStore(cfg.BlobDir, cfg.PublicBaseURL, cfg.JWTSecret), nil
+	case "s3":
+		return NewS3BlobStore(ctx, S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.BlobBackend)
+	}
+}
+
+// LocalBlobStore keeps objects on disk under Dir. Its signed URLs point at
+// GET /blobs/{key} on this server, which checks the signature.
+type LocalBlobStore struct {
+	Dir string
+	// BaseURL prefixes signed URLs; they are relative to the API when empty
+	BaseURL string
+
+	signingKey []byte
+}
+
+func NewLocalBlobStore(dir string, baseURL string, secret string) *LocalBlobStore {
+	// Derived so a leaked URL signature says nothing about the JWT secret
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("blob-urls"))
+
+	return &LocalBlobStore{
+		Dir:        dir,
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		signingKey: mac.Sum(nil),
+	}
+}
+
+// path rejects keys that would escape Dir
+func (s *LocalBlobStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if key == "" || cleaned != "/"+key {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Written aside and renamed so readers never see a partial object
+	tmpPath := path + ".tmp-" + uuid.New().String()
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, body)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *LocalBlobStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrBlobNotFound
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.sign(key, expires))
+
+	return s.BaseURL + "/blobs/" + key + "?" + query.Encode(), nil
+}
+
+// Verify checks a signed URL's key, expiry and signature
+func (s *LocalBlobStore) Verify(key string, expires string, signature string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	expected := s.sign(key, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// File is the path of the object for serving it
+func (s *LocalBlobStore) File(key string) (string, error) {
+	return s.path(key)
+}
+
+func (s *LocalBlobStore) sign(key string, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// audioChunk is one piece of a long recording. The chunk file spans
+// [Start, End], which overlaps its neighbours; segments are only kept from
+// the chunk that owns them, [From, To).
+type audioChunk struct {
+	Start float64
+	End   float64
+	From  float64
+	To    float64
+}
+
+type silence struct {
+	Start float64
+	End   float64
+}
+
+// TranscribeRecording transcribes a normalized recording. Recordings longer
+// than the chunk length are split at pauses, transcribed in parallel and
+// stitched back into one transcript on the original timeline.
+func TranscribeRecording(ctx context.Context, transcriber Transcriber, path string, durationSeconds float64) (_ *TranscriptionResult, err error) {
+	transcriber = limitTranscriptions(transcriber)
+
+	chunkLength := time.Duration(config.App.TranscriptionChunkDuration).Seconds()
+	if durationSeconds <= chunkLength {
+		return transcriber.Transcribe(ctx, path)
+	}
+
+	ctx, span := tracing.Start(ctx, "transcribe.chunked", trace.WithAttributes(
+		attribute.Float64("audio.duration_seconds", durationSeconds),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	silences, err := detectSilences(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	overlap := time.Duration(config.App.TranscriptionChunkOverlap).Seconds()
+	chunks := planChunks(durationSeconds, silences, chunkLength, overlap)
+
+	span.SetAttributes(attribute.Int("transcribe.chunks", len(chunks)))
+
+	start := time.Now()
+	results := make([]*TranscriptionResult, len(chunks))
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(transcriptionConcurrency())
+
+	for i, chunk := range chunks {
+		group.Go(func() error {
+			chunkPath, err := extractChunk(groupCtx, path, chunk)
+			if err != nil {
+				return fmt.Errorf("chunk %d: %w", i, err)
+			}
+			defer os.Remove(chunkPath)
+
+			result, err := transcriber.Transcribe(groupCtx, chunkPath)
+			if err != nil {
+				return fmt.Errorf("chunk %d: %w", i, err)
+			}
+
+			results[i] = result
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := stitchTranscripts(chunks, results)
+	result.Duration = durationSeconds
+
+	slog.InfoContext(ctx, "chunked transcription complete",
+		"chunks", len(chunks),
+		"silences", len(silences),
+		"segments", len(result.Segments),
+		"elapsed_ms", time.Since(start).Milliseconds(),
+	)
+
+	return result, nil
+}
+
+// transcriptionConcurrency is how many transcriptions of one recording may
+// run at once
+func transcriptionConcurrency() int {
+	// Local models already use every core, so running them side by side
+	// only makes each one slower
+	switch config.App.TranscriptionBackend {
+	case "whisper_cpp", "faster_whisper":
+		return 1
+	}
+	return config.App.TranscriptionConcurrency
+}
+
+// limitedTranscriber holds the transcriptions of one recording, across its
+// channels and chunks, to transcriptionConcurrency at a time
+type limitedTranscriber struct {
+	next  Transcriber
+	slots chan struct{}
+}
+
+// limitTranscriptions returns transcriber limited to
+// transcriptionConcurrency, or as is when it already is
+func limitTranscriptions(transcriber Transcriber) Transcriber {
+	if _, ok := transcriber.(*limitedTranscriber); ok {
+		return transcriber
+	}
+	return &limitedTranscriber{next: transcriber, slots: make(chan struct{}, transcriptionConcurrency())}
+}
+
+func (t *limitedTranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-t.slots }()
+
+	return t.next.Transcribe(ctx, path)
+}
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: ([0-9.]+)`)
+)
+
+// detectSilences lists the pauses ffmpeg's silencedetect filter finds
+func detectSilences(ctx context.Context, path string) (_ []silence, err error) {
+
+	ctx, span := tracing.Start(ctx, "ffmpeg.silencedetect")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-hide_banner",
+		"-nostats",
+		"-i", path,
+		"-af", "silencedetect=noise=-35dB:d=0.4",
+		"-f", "null",
+		"-",
+	)
+
+	// silencedetect reports on stderr
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg silencedetect error: %w, output: %s", err, string(output))
+	}
+
+	return parseSilences(string(output)), nil
+}
+
+func parseSilences(output string) []silence {
+	var silences []silence
+	var open *float64
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+			if start, err := strconv.ParseFloat(match[1], 64); err == nil {
+				start = max(start, 0)
+				open = &start
+			}
+			continue
+		}
+
+		if match := silenceEndPattern.FindStringSubmatch(line); match != nil && open != nil {
+			if end, err := strconv.ParseFloat(match[1], 64); err == nil {
+				silences = append(silences, silence{Start: *open, End: end})
+			}
+			open = nil
+		}
+	}
+
+	return silences
+}
+
+// planChunks cuts the recording into pieces of at most chunkLength seconds
+// (plus overlap). Each cut goes in the middle of the longest pause in the
+// second half of the piece, or at the length limit when there is none, so
+// words are rarely split and the overlap catches the ones that are.
+func planChunks(duration float64, silences []silence, chunkLength float64, overlap float64) []audioChunk {
+	var cuts []float64
+
+	position := 0.0
+	for duration-position > chunkLength {
+		earliest := position + chunkLength/2
+		latest := position + chunkLength
+
+		cut := latest
+		longest := 0.0
+		for _, s := range silences {
+			middle := (s.Start + s.End) / 2
+			if middle < earliest || middle > latest {
+				continue
+			}
+			if length := s.End - s.Start; length > longest {
+				longest = length
+				cut = middle
+			}
+		}
+
+		cuts = append(cuts, cut)
+		position = cut
+	}
+
+	bounds := append(append([]float64{0}, cuts...), duration)
+
+	chunks := make([]audioChunk, 0, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		chunks = append(chunks, audioChunk{
+			Start: max(bounds[i]-overlap, 0),
+			End:   min(bounds[i+1]+overlap, duration),
+			From:  bounds[i],
+			To:    bounds[i+1],
+		})
+	}
+
+	return chunks
+}
+
+// extractChunk re-encodes a slice of the recording. Copying the stream
+// would snap the cut to the nearest AAC frame boundary.
+func extractChunk(ctx context.Context, path string, chunk audioChunk) (_ string, err error) {
+
+	ctx, span := tracing.Start(ctx, "ffmpeg.extract_chunk", trace.WithAttributes(
+		attribute.Float64("chunk.start_seconds", chunk.Start),
+		attribute.Float64("chunk.end_seconds", chunk.End),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	outputPath := filepath.Join(
+		filepath.Dir(path),
+		fmt.Sprintf("chunk_%s.m4a", uuid.New().String()),
+	)
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-y",
+		"-ss", strconv.FormatFloat(chunk.Start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(chunk.End-chunk.Start, 'f', 3, 64),
+		"-i", path,
+		"-ac", "1",
+		"-ar", "16000",
+		"-c:a", "aac",
+		"-b:a", "96k",
+		outputPath,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg chunk error: %w, output: %s", err, string(output))
+	}
+
+	return outputPath, nil
+}
+
+// stitchTranscripts moves each chunk's segments onto the recording's
+// timeline and keeps a segment only from the chunk whose range holds its
+// midpoint. Text repeated across a cut is dropped once more by comparing
+// neighbouring segments.
+func stitchTranscripts(chunks []audioChunk, results []*TranscriptionResult) *TranscriptionResult {
+	stitched := &TranscriptionResult{}
+
+	for i, chunk := range chunks {
+		result := results[i]
+		if stitched.Language == "" {
+			stitched.Language = result.Language
+		}
+
+		segments := result.Segments
+		if len(segments) == 0 && strings.TrimSpace(result.Text) != "" {
+			// Treat a chunk without segments as one spanning all of it
+			segments = []TranscriptionSegment{{Start: chunk.From - chunk.Start, End: chunk.To - chunk.Start, Text: result.Text}}
+		}
+
+		for _, segment := range segments {
+			segment.Start += chunk.Start
+			segment.End += chunk.Start
+
+			middle := (segment.Start + segment.End) / 2
+			last := i == len(chunks)-1
+			if middle < chunk.From || (middle >= chunk.To && !last) {
+				continue
+			}
+
+			segment.Text = strings.TrimSpace(segment.Text)
+			if segment.Text == "" {
+				continue
+			}
+
+			if n := len(stitched.Segments); n > 0 && sameWords(stitched.Segments[n-1].Text, segment.Text) {
+				continue
+			}
+
+			stitched.Segments = append(stitched.Segments, segment)
+		}
+	}
+
+	sort.SliceStable(stitched.Segments, func(a, b int) bool {
+		return stitched.Segments[a].Start < stitched.Segments[b].Start
+	})
+
+	texts := make([]string, 0, len(stitched.Segments))
+	for i := range stitched.Segments {
+		stitched.Segments[i].ID = i
+		texts = append(texts, stitched.Segments[i].Text)
+	}
+	stitched.Text = strings.Join(texts, " ")
+
+	return stitched
+}
+
+// sameWords compares text ignoring case and punctuation
+func sameWords(a string, b string) bool {
+	normalize := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}), " ")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+// silencedetect's stderr for a recording that opens with a pause, has two
+// more inside and ends mid-pause
+const silencedetectOutput = `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'normalized.m4a':
+  Duration: 00:16:40.00, start: 0.000000, bitrate: 97 kb/s
+  Stream #0:0[0x1](und): Audio: aac (LC) (mp4a / 0x6134706D), 16000 Hz, mono, fltp, 96 kb/s (default)
+[silencedetect @ 0x55d5c8a0c4c0] silence_start: -0.00133
+[silencedetect @ 0x55d5c8a0c4c0] silence_end: 1.20925 | silence_duration: 1.21058
+[silencedetect @ 0x55d5c8a0c4c0] silence_start: 300.5
+[silencedetect @ 0x55d5c8a0c4c0] silence_end: 302.25 | silence_duration: 1.75
+[silencedetect @ 0x55d5c8a0c4c0] silence_start: 650
+[silencedetect @ 0x55d5c8a0c4c0] silence_end: 654 | silence_duration: 4
+size=N/A time=00:16:40.00 bitrate=N/A speed= 812x
+[silencedetect @ 0x55d5c8a0c4c0] silence_start: 998.4
+video:0kB audio:0kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+`
+
+func TestParseSilences(t *testing.T) {
+	got := parseSilences(silencedetectOutput)
+	want := []silence{
+		// Leading silence starts a hair before zero
+		{Start: 0, End: 1.20925},
+		{Start: 300.5, End: 302.25},
+		{Start: 650, End: 654},
+		// The trailing pause never ends and is dropped
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseSilences() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPlanChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		silences []silence
+		want     []audioChunk
+	}{
+		{
+			name:     "short enough for one chunk",
+			duration: 380,
+			silences: []silence{{Start: 200, End: 202}},
+			want:     []audioChunk{{Start: 0, End: 380, From: 0, To: 380}},
+		},
+		{
+			name:     "cuts land in the longest pause",
+			duration: 1000,
+			silences: parseSilences(silencedetectOutput),
+			want: []audioChunk{
+				{Start: 0, End: 303.375, From: 0, To: 301.375},
+				{Start: 299.375, End: 654, From: 301.375, To: 652},
+				{Start: 650, End: 1000, From: 652, To: 1000},
+			},
+		},
+		{
+			name:     "longest pause in the window wins",
+			duration: 500,
+			silences: []silence{{Start: 250, End: 250.5}, {Start: 300, End: 303}, {Start: 390, End: 390.4}},
+			want: []audioChunk{
+				{Start: 0, End: 303.5, From: 0, To: 301.5},
+				{Start: 299.5, End: 500, From: 301.5, To: 500},
+			},
+		},
+		{
+			name:     "no silence falls back to the length limit",
+			duration: 1000,
+			want: []audioChunk{
+				{Start: 0, End: 402, From: 0, To: 400},
+				{Start: 398, End: 802, From: 400, To: 800},
+				{Start: 798, End: 1000, From: 800, To: 1000},
+			},
+		},
+		{
+			name:     "pauses early in the piece are ignored",
+			duration: 600,
+			silences: []silence{{Start: 100, End: 104}},
+			want: []audioChunk{
+				{Start: 0, End: 402, From: 0, To: 400},
+				{Start: 398, End: 600, From: 400, To: 600},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planChunks(tt.duration, tt.silences, 400, 2)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("planChunks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStitchTranscripts(t *testing.T) {
+	chunks := []audioChunk{
+		{Start: 0, End: 303, From: 0, To: 301},
+		{Start: 299, End: 600, From: 301, To: 600},
+	}
+
+	tests := []struct {
+		name    string
+		results []*TranscriptionResult
+		want    []TranscriptionSegment
+	}{
+		{
+			name: "overlap belongs to the chunk holding the midpoint",
+			results: []*TranscriptionResult{
+				{Language: "english", Segments: []TranscriptionSegment{
+					{Start: 0, End: 5, Text: " You forgot again."},
+					// Midpoint 301.5 is past the cut; the next chunk has it
+					{Start: 300, End: 303, Text: " I did not."},
+				}},
+				{Language: "english", Segments: []TranscriptionSegment{
+					// Midpoint 300 is before the cut; the first chunk had it
+					{Start: 0, End: 2, Text: " again."},
+					{Start: 1, End: 4, Text: " I did not."},
+					{Start: 10, End: 14, Text: " You always say that."},
+				}},
+			},
+			want: []TranscriptionSegment{
+				{ID: 0, Start: 0, End: 5, Text: "You forgot again."},
+				{ID: 1, Start: 300, End: 303, Text: "I did not."},
+				{ID: 2, Start: 309, End: 313, Text: "You always say that."},
+			},
+		},
+		{
+			name: "text repeated across the cut is kept once",
+			results: []*TranscriptionResult{
+				{Segments: []TranscriptionSegment{
+					{Start: 290, End: 300, Text: "You never listen to me."},
+				}},
+				{Segments: []TranscriptionSegment{
+					// The chunk heard the same words a little later
+					{Start: 1.5, End: 5, Text: "you never listen to me"},
+					{Start: 6, End: 8, Text: "That's not fair."},
+				}},
+			},
+			want: []TranscriptionSegment{
+				{ID: 0, Start: 290, End: 300, Text: "You never listen to me."},
+				{ID: 1, Start: 305, End: 307, Text: "That's not fair."},
+			},
+		},
+		{
+			name: "a chunk without segments spans what it owns",
+			results: []*TranscriptionResult{
+				{Text: " First half."},
+				{Segments: []TranscriptionSegment{{Start: 10, End: 12, Text: "Second half."}}},
+			},
+			want: []TranscriptionSegment{
+				{ID: 0, Start: 0, End: 301, Text: "First half."},
+				{ID: 1, Start: 309, End: 311, Text: "Second half."},
+			},
+		},
+		{
+			name: "the last chunk keeps segments running past its end",
+			results: []*TranscriptionResult{
+				{},
+				{Segments: []TranscriptionSegment{{Start: 299, End: 303, Text: "Bye."}}},
+			},
+			want: []TranscriptionSegment{
+				{ID: 0, Start: 598, End: 602, Text: "Bye."},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stitchTranscripts(chunks, tt.results)
+			if !reflect.DeepEqual(got.Segments, tt.want) {
+				t.Fatalf("segments = %+v, want %+v", got.Segments, tt.want)
+			}
+		})
+	}
+}
+
+func TestStitchTranscriptsText(t *testing.T) {
+	chunks := []audioChunk{
+		{Start: 0, End: 32, From: 0, To: 30},
+		{Start: 28, End: 60, From: 30, To: 60},
+	}
+	results := []*TranscriptionResult{
+		{Language: "english", Segments: []TranscriptionSegment{{Start: 1, End: 3, Text: "Where were you?"}}},
+		{Language: "french", Segments: []TranscriptionSegment{{Start: 5, End: 7, Text: "Out."}}},
+	}
+
+	got := stitchTranscripts(chunks, results)
+	if got.Text != "Where were you? Out." || got.Language != "english" {
+		t.Fatalf("stitched = %+v", got)
+	}
+}
+
+func TestSameWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"You never listen.", "you never listen", true},
+		{"  Fine,   whatever! ", "fine whatever", true},
+		{"¿Qué pasó?", "qué pasó", true},
+		{"I do", "I don't", false},
+		{"It was 10 pm", "It was 11 pm", false},
+	}
+
+	for _, tt := range tests {
+		if got := sameWords(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameWords(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
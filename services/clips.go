@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxClipSeconds bounds a clip; they are for hearing a cited moment, not
+// for downloading the recording piecemeal
+const MaxClipSeconds = 60
+
+// ClipCutter cuts the seconds from start to end out of a recording into an
+// AAC m4a. The caller removes the returned file.
+type ClipCutter interface {
+	Cut(ctx context.Context, inputPath string, start float64, end float64) (string, error)
+}
+
+func (m *MediaService) Cut(ctx context.Context, inputPath string, start float64, end float64) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "ffmpeg.clip", trace.WithAttributes(
+		attribute.Float64("clip.start", start),
+		attribute.Float64("clip.end", end),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	outputPath := filepath.Join(
+		filepath.Dir(inputPath),
+		fmt.Sprintf("clip_%s.m4a", uuid.New().String()),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-y",
+		// Seeking before the input skips decoding everything up to start
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", inputPath,
+		"-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+		"-vn",
+		"-c:a", "aac",
+		"-b:a", "96k",
+		// Lets players start before the whole clip has arrived
+		"-movflags", "+faststart",
+		outputPath,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg error: %w, output: %s", err, string(output))
+	}
+
+	return outputPath, nil
+}
+
+// Playback is the blob to play for the argument: the transcribed audio, or
+// the original recording for arguments stored before it was kept. Returns
+// ErrBlobNotFound when there is neither.
+func (a *MediaArchive) Playback(ctx context.Context, argumentID uint) (*models.MediaBlob, error) {
+	blobs, err := a.Media.ListForArgument(ctx, argumentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var recording *models.MediaBlob
+	for i := range blobs {
+		switch blobs[i].Kind {
+		case models.MediaKindAudio:
+			return &blobs[i], nil
+		case models.MediaKindRecording:
+			recording = &blobs[i]
+		}
+	}
+
+	if recording == nil {
+		return nil, ErrBlobNotFound
+	}
+	return recording, nil
+}
+
+// Clip returns the excerpt of the argument's audio from start to end
+// seconds. Clips are cut once and kept with the argument's other media, so
+// replaying a cited moment doesn't run ffmpeg again.
+func (a *MediaArchive) Clip(ctx context.Context, argument models.Argument, start float64, end float64, cutter ClipCutter) (_ *models.MediaBlob, _ io.ReadSeekCloser, err error) {
+	ctx, span := tracing.Start(ctx, "media.clip")
+	defer func() { tracing.End(span, err) }()
+
+	// Tenths of a second, so near-identical requests share a clip
+	from := int64(math.Round(start * 10))
+	to := int64(math.Round(end * 10))
+	key := fmt.Sprintf("users/%d/arguments/%d/clips/%d-%d.m4a", argument.UserID, argument.ID, from*100, to*100)
+
+	cached, err := a.Media.FindByKey(ctx, key)
+	if err == nil {
+		body, err := a.Blobs.Open(ctx, key)
+		if err == nil {
+			span.SetAttributes(attribute.Bool("clip.cached", true))
+			return cached, body, nil
+		}
+		if !errors.Is(err, ErrBlobNotFound) {
+			return nil, nil, err
+		}
+		// The row outlived its object; cut it again
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, err
+	}
+
+	source, err := a.Playback(ctx, argument.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourcePath, err := a.download(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(sourcePath)
+
+	clipPath, err := cutter.Cut(ctx, sourcePath, float64(from)/10, float64(to)/10)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clip, err := os.Open(clipPath)
+	if err != nil {
+		_ = os.Remove(clipPath)
+		return nil, nil, err
+	}
+	body := &tempFile{File: clip}
+
+	stat, err := clip.Stat()
+	if err != nil {
+		_ = body.Close()
+		return nil, nil, err
+	}
+
+	blob := &models.MediaBlob{
+		ArgumentID:  &argument.ID,
+		Kind:        models.MediaKindClip,
+		Key:         key,
+		ContentType: "audio/mp4",
+		SizeBytes:   stat.Size(),
+	}
+
+	// Caching is best effort; the clip is served from disk either way
+	if err := a.Blobs.Put(ctx, key, clip, stat.Size(), blob.ContentType); err != nil {
+		slog.WarnContext(ctx, "caching clip failed", "key", key, "error", err)
+	} else if cached == nil {
+		// A concurrent request for the same clip may have recorded it
+		// first; the objects are the same, so losing the race is fine
+		if err := a.Media.Create(ctx, blob); err != nil {
+			slog.InfoContext(ctx, "recording clip failed", "key", key, "error", err)
+		}
+	} else {
+		blob = cached
+	}
+
+	if _, err := clip.Seek(0, io.SeekStart); err != nil {
+		_ = body.Close()
+		return nil, nil, err
+	}
+
+	return blob, body, nil
+}
+
+// download copies a blob into the upload directory for tools that need a
+// file
+func (a *MediaArchive) download(ctx context.Context, blob *models.MediaBlob) (string, error) {
+	src, err := a.Blobs.Open(ctx, blob.Key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(config.App.UploadDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	dst, err := os.CreateTemp(config.App.UploadDir, "blob-*"+filepath.Ext(blob.Key))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
+
+// tempFile removes itself once closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// Phone and video-call recorders often put each side of the call on its own
+// channel. Transcribing the channels separately tells us who said what
+// without a diarization model.
+
+// Thresholds for treating a stereo recording as one speaker per channel.
+// Both channels must carry sound, and their difference must be nearly as
+// loud as their sum: that holds for independent signals, while the same
+// voice on both channels (a stereo mic, or mono saved as stereo) cancels
+// out in the difference.
+const (
+	minChannelVolumeDB = -50.0
+	maxSideDropDB      = 6.0
+)
+
+var meanVolumePattern = regexp.MustCompile(`\[volumedetect@(\w+) @ [^\]]+\] mean_volume: (-?[0-9.]+|-inf) dB`)
+
+// speakersOnSeparateChannels measures the first two channels of a
+// recording to tell a two-sided call recording from ordinary stereo
+func speakersOnSeparateChannels(ctx context.Context, path string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "ffmpeg.channel_volumes")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-nostats",
+		"-i", path,
+		"-filter_complex",
+		"[0:a]asplit=4[a][b][c][d];"+
+			"[a]pan=mono|c0=c0,volumedetect@left[left];"+
+			"[b]pan=mono|c0=c1,volumedetect@right[right];"+
+			"[c]pan=mono|c0=0.5*c0+0.5*c1,volumedetect@mid[mid];"+
+			"[d]pan=mono|c0=0.5*c0-0.5*c1,volumedetect@side[side]",
+		"-map", "[left]", "-f", "null", "-",
+		"-map", "[right]", "-f", "null", "-",
+		"-map", "[mid]", "-f", "null", "-",
+		"-map", "[side]", "-f", "null", "-",
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("ffmpeg volumedetect error: %w, output: %s", err, string(output))
+	}
+
+	volumes, err := parseChannelVolumes(string(output))
+	if err != nil {
+		return false, err
+	}
+
+	span.SetAttributes(
+		attribute.Float64("audio.left_db", volumes["left"]),
+		attribute.Float64("audio.right_db", volumes["right"]),
+		attribute.Float64("audio.side_drop_db", volumes["mid"]-volumes["side"]),
+	)
+
+	return separateSpeakers(volumes), nil
+}
+
+// parseChannelVolumes reads the left, right, mid and side mean volumes from
+// volumedetect's log
+func parseChannelVolumes(output string) (map[string]float64, error) {
+	volumes := map[string]float64{}
+	for _, match := range meanVolumePattern.FindAllStringSubmatch(output, -1) {
+		// -inf: the signal is digital silence. ParseFloat would accept it,
+		// but an infinite difference doesn't belong in a span attribute.
+		volume := -200.0
+		if match[2] != "-inf" {
+			parsed, err := strconv.ParseFloat(match[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("ffmpeg volumedetect: %w", err)
+			}
+			volume = parsed
+		}
+		volumes[match[1]] = volume
+	}
+
+	if len(volumes) != 4 {
+		return nil, fmt.Errorf("ffmpeg volumedetect: expected 4 measurements, got %d", len(volumes))
+	}
+
+	return volumes, nil
+}
+
+func separateSpeakers(volumes map[string]float64) bool {
+	return volumes["left"] > minChannelVolumeDB &&
+		volumes["right"] > minChannelVolumeDB &&
+		volumes["mid"]-volumes["side"] < maxSideDropDB
+}
+
+// normalizeChannels converts a two-sided recording into a mono mix for
+// playback and one file per channel for transcription. Pauses are kept so
+// the three share a timeline and the channels' segments can be interleaved.
+func (m *MediaService) normalizeChannels(ctx context.Context, inputPath string, maxDuration time.Duration, presetName string) (_ string, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "ffmpeg.normalize_channels")
+	defer func() { tracing.End(span, err) }()
+
+	filter := ""
+	if f := channelPreset(presetName).Filter(); f != "" {
+		filter = "," + f
+	}
+
+	dir := filepath.Dir(inputPath)
+	id := uuid.New().String()
+	mixPath := filepath.Join(dir, fmt.Sprintf("normalized_%s.m4a", id))
+	channelPaths := []string{
+		filepath.Join(dir, fmt.Sprintf("normalized_%s_left.m4a", id)),
+		filepath.Join(dir, fmt.Sprintf("normalized_%s_right.m4a", id)),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-filter_complex",
+		"[0:a]asplit=3[a][b][c];" +
+			"[a]pan=mono|c0=0.5*c0+0.5*c1" + filter + "[mix];" +
+			"[b]pan=mono|c0=c0" + filter + "[left];" +
+			"[c]pan=mono|c0=c1" + filter + "[right]",
+	}
+	outputs := []struct{ label, path string }{
+		{"[mix]", mixPath},
+		{"[left]", channelPaths[0]},
+		{"[right]", channelPaths[1]},
+	}
+	for _, output := range outputs {
+		args = append(args,
+			"-map", output.label,
+			// Probed durations can be wrong; never convert past the limit
+			"-t", strconv.FormatFloat(maxDuration.Seconds(), 'f', 0, 64),
+			"-ac", "1",
+			"-ar", "16000",
+			"-c:a", "aac",
+			"-b:a", "96k",
+			output.path,
+		)
+	}
+
+	start := time.Now()
+
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		for _, path := range append(channelPaths, mixPath) {
+			_ = os.Remove(path)
+		}
+		return "", nil, fmt.Errorf("ffmpeg error: %w, output: %s", err, string(output))
+	}
+
+	slog.InfoContext(ctx, "ffmpeg channel split complete", "elapsed_ms", time.Since(start).Milliseconds())
+
+	return mixPath, channelPaths, nil
+}
+
+// TranscribeMedia transcribes normalized media. Two-sided recordings are
+// transcribed per channel and merged into one transcript attributed to
+// Person A and Person B.
+func TranscribeMedia(ctx context.Context, transcriber Transcriber, media *NormalizedMedia, personAName string, personBName string) (*TranscriptionResult, error) {
+	if len(media.ChannelPaths) != 2 {
+		return TranscribeRecording(ctx, transcriber, media.Path, media.ProcessedDurationSeconds)
+	}
+
+	results := make([]*TranscriptionResult, len(media.ChannelPaths))
+
+	// The channels share one limit, so a call recording runs no more
+	// transcriptions at once than a mono one
+	transcriber = limitTranscriptions(transcriber)
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i, path := range media.ChannelPaths {
+		group.Go(func() error {
+			result, err := TranscribeRecording(groupCtx, transcriber, path, media.ProcessedDurationSeconds)
+			if err != nil {
+				return fmt.Errorf("channel %d: %w", i, err)
+			}
+			results[i] = result
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := mergeChannelTranscripts(results, personAName, personBName)
+	result.Duration = media.ProcessedDurationSeconds
+	return result, nil
+}
+
+// mergeChannelTranscripts interleaves the channels' segments by time and
+// labels each line with its speaker. Whoever speaks first is Person A, as
+// the judge is told.
+func mergeChannelTranscripts(results []*TranscriptionResult, personAName string, personBName string) *TranscriptionResult {
+	for _, result := range results {
+		// Backends that don't time segments get one spanning the channel
+		if len(result.Segments) == 0 && strings.TrimSpace(result.Text) != "" {
+			result.Segments = []TranscriptionSegment{{Start: 0, End: result.Duration, Text: result.Text}}
+		}
+	}
+
+	first := func(result *TranscriptionResult) float64 {
+		if len(result.Segments) == 0 {
+			return -1
+		}
+		return result.Segments[0].Start
+	}
+
+	a, b := results[0], results[1]
+	if first(b) >= 0 && (first(a) < 0 || first(b) < first(a)) {
+		a, b = b, a
+	}
+
+	var segments []TranscriptionSegment
+	for _, channel := range []struct {
+		result  *TranscriptionResult
+		speaker string
+	}{{a, personAName}, {b, personBName}} {
+		for _, segment := range channel.result.Segments {
+			segment.Text = strings.TrimSpace(segment.Text)
+			if segment.Text == "" {
+				continue
+			}
+			segment.Speaker = channel.speaker
+			segments = append(segments, segment)
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Start < segments[j].Start
+	})
+
+	// One line per turn: consecutive segments of a speaker are joined
+	var lines []string
+	for i := range segments {
+		segments[i].ID = i
+		if i > 0 && segments[i-1].Speaker == segments[i].Speaker {
+			lines[len(lines)-1] += " " + segments[i].Text
+			continue
+		}
+		lines = append(lines, segments[i].Speaker+": "+segments[i].Text)
+	}
+
+	return &TranscriptionResult{
+		Text:     strings.Join(lines, "\n"),
+		Language: a.Language,
+		Segments: segments,
+	}
+}
+
+// channelPreset is the preset without silence trimming, which would cut
+// each channel differently and pull their timelines apart
+func channelPreset(name string) AudioPreset {
+	preset := AudioPresets[name]
+	preset.MinSilence = 0
+	return preset
+}
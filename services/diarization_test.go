@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+)
+
+// volumedetect's log for a two-sided call, trimmed of the input banner
+const volumedetectOutput = `[volumedetect@left @ 0x5581e1c3a2c0] n_samples: 2646000
+[volumedetect@left @ 0x5581e1c3a2c0] mean_volume: -24.1 dB
+[volumedetect@left @ 0x5581e1c3a2c0] max_volume: -3.2 dB
+[volumedetect@left @ 0x5581e1c3a2c0] histogram_3db: 12
+[volumedetect@right @ 0x5581e1c3b480] n_samples: 2646000
+[volumedetect@right @ 0x5581e1c3b480] mean_volume: -27.3 dB
+[volumedetect@right @ 0x5581e1c3b480] max_volume: -5.0 dB
+[volumedetect@mid @ 0x5581e1c3c5c0] n_samples: 2646000
+[volumedetect@mid @ 0x5581e1c3c5c0] mean_volume: -28.5 dB
+[volumedetect@mid @ 0x5581e1c3c5c0] max_volume: -6.1 dB
+[volumedetect@side @ 0x5581e1c3d700] n_samples: 2646000
+[volumedetect@side @ 0x5581e1c3d700] mean_volume: -29.0 dB
+[volumedetect@side @ 0x5581e1c3d700] max_volume: -6.4 dB
+`
+
+// The same voice on both channels: the side signal is digital silence
+const monoAsStereoOutput = `[volumedetect@left @ 0x55d0a4f1e2c0] mean_volume: -22.0 dB
+[volumedetect@right @ 0x55d0a4f1f480] mean_volume: -22.0 dB
+[volumedetect@mid @ 0x55d0a4f205c0] mean_volume: -22.0 dB
+[volumedetect@side @ 0x55d0a4f21700] n_samples: 0
+[volumedetect@side @ 0x55d0a4f21700] mean_volume: -inf dB
+[volumedetect@side @ 0x55d0a4f21700] max_volume: -inf dB
+`
+
+func TestParseChannelVolumes(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]float64
+	}{
+		{
+			name:   "call recording",
+			output: volumedetectOutput,
+			want:   map[string]float64{"left": -24.1, "right": -27.3, "mid": -28.5, "side": -29},
+		},
+		{
+			name:   "silent side",
+			output: monoAsStereoOutput,
+			want:   map[string]float64{"left": -22, "right": -22, "mid": -22, "side": -200},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChannelVolumes(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseChannelVolumes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChannelVolumesMissingMeasurement(t *testing.T) {
+	output := `[volumedetect@left @ 0x5581e1c3a2c0] mean_volume: -24.1 dB
+[volumedetect@right @ 0x5581e1c3b480] mean_volume: -27.3 dB
+[volumedetect@mid @ 0x5581e1c3c5c0] mean_volume: -28.5 dB
+`
+	if _, err := parseChannelVolumes(output); err == nil {
+		t.Fatal("expected an error for three measurements")
+	}
+}
+
+func TestSeparateSpeakers(t *testing.T) {
+	tests := []struct {
+		name                   string
+		left, right, mid, side float64
+		want                   bool
+	}{
+		{name: "call recording", left: -24.1, right: -27.3, mid: -28.5, side: -29, want: true},
+		{name: "mono saved as stereo", left: -22, right: -22, mid: -22, side: -200},
+		{name: "stereo mic", left: -20, right: -21, mid: -20.6, side: -33},
+		{name: "just under the side drop", left: -30, right: -30, mid: -30, side: -35.9, want: true},
+		{name: "at the side drop", left: -30, right: -30, mid: -30, side: -36},
+		{name: "one side silent", left: -25, right: -200, mid: -31, side: -31},
+		{name: "one side too quiet", left: -25, right: -55, mid: -31, side: -31.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumes := map[string]float64{"left": tt.left, "right": tt.right, "mid": tt.mid, "side": tt.side}
+			if got := separateSpeakers(volumes); got != tt.want {
+				t.Fatalf("separateSpeakers(%v) = %v, want %v", volumes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeChannelTranscripts(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []*TranscriptionResult
+		text     string
+		speakers []string
+	}{
+		{
+			name: "interleaved turns, second channel speaks first",
+			results: []*TranscriptionResult{
+				{Language: "english", Segments: []TranscriptionSegment{
+					{Start: 4, End: 6, Text: " Traffic was bad."},
+					{Start: 6.5, End: 8, Text: " Really bad."},
+					{Start: 12, End: 13, Text: " Yes."},
+				}},
+				{Language: "english", Segments: []TranscriptionSegment{
+					{Start: 1, End: 3, Text: " Why are you late?"},
+					{Start: 9, End: 11, Text: " Again?"},
+				}},
+			},
+			text:     "Alex: Why are you late?\nSam: Traffic was bad. Really bad.\nAlex: Again?\nSam: Yes.",
+			speakers: []string{"Alex", "Sam", "Sam", "Alex", "Sam"},
+		},
+		{
+			name: "one channel empty",
+			results: []*TranscriptionResult{
+				{},
+				{Segments: []TranscriptionSegment{
+					{Start: 2, End: 4, Text: "Hello?"},
+					{Start: 5, End: 6, Text: " "},
+					{Start: 7, End: 9, Text: "Are you there?"},
+				}},
+			},
+			text:     "Alex: Hello? Are you there?",
+			speakers: []string{"Alex", "Alex"},
+		},
+		{
+			name: "backend without segments",
+			results: []*TranscriptionResult{
+				{Text: " We need to talk.", Duration: 30},
+				{Text: "Fine.", Duration: 30},
+			},
+			text:     "Alex: We need to talk.\nSam: Fine.",
+			speakers: []string{"Alex", "Sam"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeChannelTranscripts(tt.results, "Alex", "Sam")
+			if got.Text != tt.text {
+				t.Fatalf("text = %q, want %q", got.Text, tt.text)
+			}
+
+			var speakers []string
+			for i, segment := range got.Segments {
+				if segment.ID != i {
+					t.Fatalf("segment %d has ID %d", i, segment.ID)
+				}
+				if i > 0 && segment.Start < got.Segments[i-1].Start {
+					t.Fatalf("segments out of order: %+v", got.Segments)
+				}
+				speakers = append(speakers, segment.Speaker)
+			}
+			if !reflect.DeepEqual(speakers, tt.speakers) {
+				t.Fatalf("speakers = %v, want %v", speakers, tt.speakers)
+			}
+		})
+	}
+}
+
+func TestMergeChannelTranscriptsSpansChannel(t *testing.T) {
+	results := []*TranscriptionResult{
+		{Text: "We need to talk.", Duration: 30},
+		{},
+	}
+
+	got := mergeChannelTranscripts(results, "Alex", "Sam")
+	want := []TranscriptionSegment{{ID: 0, Start: 0, End: 30, Text: "We need to talk.", Speaker: "Alex"}}
+	if !reflect.DeepEqual(got.Segments, want) {
+		t.Fatalf("segments = %+v, want %+v", got.Segments, want)
+	}
+}
+
+// countingTranscriber records how many transcriptions ran at once
+type countingTranscriber struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (t *countingTranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	t.mu.Lock()
+	t.running++
+	t.peak = max(t.peak, t.running)
+	t.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	t.mu.Lock()
+	t.running--
+	t.mu.Unlock()
+
+	return &TranscriptionResult{Segments: []TranscriptionSegment{{Start: 1, End: 2, Text: path}}}, nil
+}
+
+func TestTranscribeMediaSharesTheLimitAcrossChannels(t *testing.T) {
+	previous := config.App
+	t.Cleanup(func() { config.App = previous })
+
+	tests := []struct {
+		backend     string
+		concurrency int
+		want        int
+	}{
+		{backend: "openai", concurrency: 3, want: 2},
+		{backend: "openai", concurrency: 1, want: 1},
+		{backend: "whisper_cpp", concurrency: 3, want: 1},
+		{backend: "faster_whisper", concurrency: 3, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s at %d", tt.backend, tt.concurrency), func(t *testing.T) {
+			config.App = config.Default()
+			config.App.TranscriptionBackend = tt.backend
+			config.App.TranscriptionConcurrency = tt.concurrency
+
+			transcriber := &countingTranscriber{}
+			media := &NormalizedMedia{ChannelPaths: []string{"left.m4a", "right.m4a"}, ProcessedDurationSeconds: 30}
+
+			if _, err := TranscribeMedia(context.Background(), transcriber, media, "Alex", "Sam"); err != nil {
+				t.Fatal(err)
+			}
+			if transcriber.peak != tt.want {
+				t.Fatalf("%d transcriptions ran at once, want %d", transcriber.peak, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/jackc/pgx/v5"
+)
+
+// Pipeline stages reported alongside the argument status
+const (
+	StageQueued   = "queued"
+	StageJudging  = "judging"
+	StageSaving   = "saving"
+	StageComplete = "complete"
+	StageFailed   = "failed"
+
+	// The instance shut down mid-judgment; another will resume it
+	StageInterrupted = "interrupted"
+)
+
+type ArgumentEvent struct {
+	ID         uint      `json:"id"`
+	ArgumentID uint      `json:"argument_id"`
+	Status     string    `json:"status"`
+	Stage      string    `json:"stage"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Terminal reports whether no further events will follow this one.
+func (e ArgumentEvent) Terminal() bool {
+	return e.Status == "complete" || e.Status == "failed"
+}
+
+func NewArgumentEvent(event models.ArgumentEvent) ArgumentEvent {
+	return ArgumentEvent{
+		ID:         event.ID,
+		ArgumentID: event.ArgumentID,
+		Status:     event.Status,
+		Stage:      event.Stage,
+		CreatedAt:  event.CreatedAt,
+	}
+}
+
+// EventBroker holds one LISTEN connection per instance and fans
+// notifications out to the streams subscribed on this instance.
+type EventBroker struct {
+	dsn string
+
+	mu          sync.Mutex
+	subscribers map[uint]map[chan ArgumentEvent]struct{}
+}
+
+func NewEventBroker(dsn string) *EventBroker {
+	return &EventBroker{
+		dsn:         dsn,
+		subscribers: make(map[uint]map[chan ArgumentEvent]struct{}),
+	}
+}
+
+// Subscribe registers interest in an argument. The returned function must be
+// called to release the subscription.
+func (b *EventBroker) Subscribe(argumentID uint) (<-chan ArgumentEvent, func()) {
+	ch := make(chan ArgumentEvent, 16)
+
+	b.mu.Lock()
+	if b.subscribers[argumentID] == nil {
+		b.subscribers[argumentID] = make(map[chan ArgumentEvent]struct{})
+	}
+	b.subscribers[argumentID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[argumentID], ch)
+		if len(b.subscribers[argumentID]) == 0 {
+			delete(b.subscribers, argumentID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Publish delivers an event to this instance's subscribers. Run calls it for
+// every notification; deployments without Postgres call it directly.
+func (b *EventBroker) Publish(event ArgumentEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.ArgumentID] {
+		// Slow readers miss live events and catch up from the table
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Run listens until ctx is cancelled, reconnecting with backoff when the
+// connection drops. The backoff starts over once a connection is listening.
+func (b *EventBroker) Run(ctx context.Context) {
+	const initialBackoff = time.Second
+	backoff := initialBackoff
+
+	for {
+		err := b.listen(ctx, func() { backoff = initialBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("argument event listener disconnected", "error", err, "retry_in", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// listen calls connected once it is listening, then delivers notifications
+// until the connection fails
+func (b *EventBroker) listen(ctx context.Context, connected func()) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+repository.ArgumentEventsChannel); err != nil {
+		return err
+	}
+	connected()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event ArgumentEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			slog.Warn("invalid argument event payload", "error", err)
+			continue
+		}
+
+		b.Publish(event)
+	}
+}
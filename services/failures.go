@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrInvalidModelResponse is wrapped by errors caused by unusable LLM output
+var ErrInvalidModelResponse = errors.New("invalid model response")
+
+// UpstreamError is a non-2xx response from a provider API
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	return "OpenAI error: " + e.Body
+}
+
+// failureReason maps an error to the bounded "reason" label used by the
+// pipeline failure metrics.
+func failureReason(err error) string {
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	var upstreamErr *UpstreamError
+	var exitErr *exec.ExitError
+	var rejection *MediaRejection
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrInvalidModelResponse):
+		return "invalid_response"
+	case errors.As(err, &rejection):
+		return "rejected"
+	case errors.As(err, &apiErr), errors.As(err, &requestErr), errors.As(err, &upstreamErr):
+		return "upstream"
+	case errors.As(err, &exitErr):
+		return "ffmpeg"
+	default:
+		return "other"
+	}
+}
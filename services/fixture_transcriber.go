@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FixtureTranscriber answers from TranscriptionResult JSON files instead of
+// running a model, for offline development. A recording whose SHA-256 has a
+// fixture (<hex>.json) gets that one; everything else gets default.json.
+type FixtureTranscriber struct {
+	Dir string
+}
+
+func (t *FixtureTranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixturePath := filepath.Join(t.Dir, digest+".json")
+	contents, err := os.ReadFile(fixturePath)
+	if errors.Is(err, os.ErrNotExist) {
+		fixturePath = filepath.Join(t.Dir, "default.json")
+		contents, err = os.ReadFile(fixturePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading transcription fixture: %w", err)
+	}
+
+	result := &TranscriptionResult{}
+	if err := json.Unmarshal(contents, result); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fixturePath, err)
+	}
+
+	return result, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FrameExtractor picks candidate frames out of a screen recording of a
+// chat, in recording order. Recordings that aren't video, or are longer than
+// maxDuration, return a *MediaRejection.
+type FrameExtractor interface {
+	ExtractFrames(ctx context.Context, videoPath string, maxDuration time.Duration) ([]Screenshot, error)
+}
+
+const (
+	// Scrolling a chat rarely moves more than a screen in half a second
+	frameSampleRate = 2
+	// Frames that differ from the previous sample by less than this scene
+	// score show the same screen, e.g. while the user reads
+	frameSceneThreshold = 0.01
+	maxCandidateFrames  = 240
+	// Frames are scaled to at most this wide; chat text stays legible
+	maxFrameWidth = 1280
+)
+
+func (m *MediaService) ExtractFrames(ctx context.Context, videoPath string, maxDuration time.Duration) (_ []Screenshot, err error) {
+	ctx, span := tracing.Start(ctx, "media.extract_frames")
+	defer func() { tracing.End(span, err) }()
+
+	info, err := ProbeMedia(ctx, videoPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.HasVideo {
+		return nil, &MediaRejection{Reason: ErrUnsupportedMedia, Detail: "the file has no video track"}
+	}
+	if info.DurationSeconds > maxDuration.Seconds() {
+		return nil, &MediaRejection{
+			Reason:          ErrRecordingTooLong,
+			Detail:          fmt.Sprintf("%.0fs is longer than the %s maximum", info.DurationSeconds, maxDuration),
+			DurationSeconds: info.DurationSeconds,
+			LimitSeconds:    maxDuration.Seconds(),
+		}
+	}
+
+	dir, err := os.MkdirTemp(config.App.UploadDir, "frames-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-i", videoPath,
+		"-an",
+		"-vf", fmt.Sprintf(
+			"fps=%d,select='eq(n\\,0)+gt(scene\\,%g)',scale='min(iw\\,%d)':-2",
+			frameSampleRate, frameSceneThreshold, maxFrameWidth,
+		),
+		"-fps_mode", "vfr",
+		// One frame past the cap tells a full scroll from a cut-off one
+		"-frames:v", fmt.Sprint(maxCandidateFrames+1),
+		"-q:v", "2",
+		filepath.Join(dir, "frame_%04d.jpg"),
+	)
+
+	start := time.Now()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w, output: %s", err, string(output))
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "frame_*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	if err := checkCandidateFrames(len(paths), info.DurationSeconds); err != nil {
+		return nil, err
+	}
+
+	frames := make([]Screenshot, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, Screenshot{Filename: filepath.Base(path), ContentType: "image/jpeg", Data: data})
+	}
+
+	span.SetAttributes(attribute.Int("frames.candidates", len(frames)))
+	slog.InfoContext(ctx, "ffmpeg frame extraction complete",
+		"frames", len(frames),
+		"elapsed_ms", time.Since(start).Milliseconds(),
+	)
+
+	return frames, nil
+}
+
+// checkCandidateFrames rejects a recording whose screen changed on more
+// sampled frames than are extracted, since the end of the scroll would be
+// missing. Recordings short enough to fit at the sample rate always pass.
+func checkCandidateFrames(count int, durationSeconds float64) error {
+	if count <= maxCandidateFrames {
+		return nil
+	}
+	limit := float64(maxCandidateFrames / frameSampleRate)
+	return &MediaRejection{
+		Reason:          ErrRecordingTooLong,
+		Detail:          fmt.Sprintf("the screen changes on more than %d frames; recordings up to %.0fs always fit", maxCandidateFrames, limit),
+		DurationSeconds: durationSeconds,
+		LimitSeconds:    limit,
+	}
+}
+
+// A frame is kept once at least this much of it has scrolled into view
+// since the last kept frame
+const minNovelContent = 0.35
+
+// DistinctFrames keeps the frames of a scroll through a chat that together
+// show every message with little repetition, oldest messages first.
+func DistinctFrames(ctx context.Context, frames []Screenshot) (_ []Screenshot, err error) {
+	_, span := tracing.Start(ctx, "media.distinct_frames", trace.WithAttributes(
+		attribute.Int("frames.candidates", len(frames)),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if len(frames) == 0 {
+		return nil, nil
+	}
+
+	profiles := make([]rowProfile, len(frames))
+	for i, frame := range frames {
+		img, _, err := image.Decode(bytes.NewReader(frame.Data))
+		if err != nil {
+			return nil, fmt.Errorf("decoding frame %d: %w", i, err)
+		}
+		profiles[i] = profileImage(img, profileRowHeight)
+	}
+
+	kept := []int{0}
+	last, pending := 0, -1
+	// Net direction of the scroll; negative when scrolling up to older
+	// messages
+	direction := 0.0
+
+	keep := func(i int, o overlap) {
+		kept = append(kept, i)
+		last, pending = i, -1
+		direction += o.Shift
+	}
+
+	for i := 1; i < len(frames); i++ {
+		o := measureOverlap(profiles[last], profiles[i])
+
+		if !o.Found && pending >= 0 {
+			// Scrolled past everything the last kept frame shows; keep the
+			// frame in between so no message is skipped
+			keep(pending, measureOverlap(profiles[last], profiles[pending]))
+			o = measureOverlap(profiles[last], profiles[i])
+		}
+
+		if o.Found && o.Novel < minNovelContent {
+			pending = i
+			continue
+		}
+
+		keep(i, o)
+	}
+
+	// The end of the scroll, unless it adds next to nothing
+	if pending >= 0 {
+		if o := measureOverlap(profiles[last], profiles[pending]); !o.Found || o.Novel > 0.05 {
+			keep(pending, o)
+		}
+	}
+
+	if direction < 0 {
+		for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+	}
+
+	distinct := make([]Screenshot, len(kept))
+	for i, index := range kept {
+		distinct[i] = frames[index]
+	}
+
+	span.SetAttributes(
+		attribute.Int("frames.distinct", len(distinct)),
+		attribute.Bool("frames.reversed", direction < 0),
+	)
+
+	return distinct, nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+)
+
+// chatColumn is a long chat of messages: bands of random shade, a few to a
+// dozen rows tall. Seeds give different chats.
+func chatColumn(seed uint64, length int) []uint8 {
+	random := rand.New(rand.NewPCG(seed, 2))
+	chat := make([]uint8, length)
+	for y := 0; y < len(chat); {
+		shade := uint8(40 + random.IntN(200))
+		for end := y + 4 + random.IntN(24); y < end && y < len(chat); y++ {
+			chat[y] = shade
+		}
+	}
+	return chat
+}
+
+// chatScreen is a 240x320 phone screen with the chat scrolled to offset
+// between a fixed header and input bar
+func chatScreen(chat []uint8, offset int) *image.Gray {
+	const width, height, bar = 240, 320, 40
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		shade := uint8(250)
+		if y >= bar && y < height-bar {
+			shade = chat[offset+y-bar]
+		}
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: shade})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scrollFrames are the frames of a recording scrolling through one chat,
+// named after their offsets
+func scrollFrames(t *testing.T, offsets ...int) []Screenshot {
+	t.Helper()
+
+	chat := chatColumn(1, 2000)
+	frames := make([]Screenshot, len(offsets))
+	for i, offset := range offsets {
+		frames[i] = Screenshot{
+			Filename:    fmt.Sprintf("offset_%d.jpg", offset),
+			ContentType: "image/jpeg",
+			Data:        encodeJPEG(t, chatScreen(chat, offset), 90),
+		}
+	}
+	return frames
+}
+
+func TestDistinctFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int
+		want    []int
+	}{
+		{
+			// The first frames add too little on their own; 360 is the
+			// last to overlap 280, so it is kept for what only it shows
+			name:    "scrolling down",
+			offsets: []int{0, 0, 40, 80, 160, 280, 360, 700, 800},
+			want:    []int{0, 160, 280, 360, 700, 800},
+		},
+		{
+			name:    "scrolling up is reversed to oldest first",
+			offsets: []int{800, 700, 360, 280, 160, 80, 40, 0, 0},
+			want:    []int{0, 40, 160, 280, 360, 700, 800},
+		},
+		{
+			name:    "a jump with no overlap keeps both sides",
+			offsets: []int{0, 100, 1500, 1600},
+			want:    []int{0, 100, 1500, 1600},
+		},
+		{
+			name:    "steady scroll keeps every other frame",
+			offsets: []int{0, 60, 120, 180, 240, 300},
+			want:    []int{0, 120, 240, 300},
+		},
+		{
+			name:    "a last frame adding next to nothing is dropped",
+			offsets: []int{0, 160, 164},
+			want:    []int{0, 160},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distinct, err := DistinctFrames(context.Background(), scrollFrames(t, tt.offsets...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(distinct))
+			for i, frame := range distinct {
+				got[i] = frame.Filename
+			}
+			want := make([]string, len(tt.want))
+			for i, offset := range tt.want {
+				want[i] = fmt.Sprintf("offset_%d.jpg", offset)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("kept %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDistinctFramesRejectsUndecodableFrame(t *testing.T) {
+	frames := append(scrollFrames(t, 0), Screenshot{Filename: "broken.jpg", Data: []byte("not an image")})
+	if _, err := DistinctFrames(context.Background(), frames); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCheckCandidateFrames(t *testing.T) {
+	for _, count := range []int{1, maxCandidateFrames} {
+		if err := checkCandidateFrames(count, 170); err != nil {
+			t.Fatalf("checkCandidateFrames(%d) = %v, want nil", count, err)
+		}
+	}
+
+	// ffmpeg stopped at the cap, so the rest of the scroll was never read
+	err := checkCandidateFrames(maxCandidateFrames+1, 170)
+
+	var rejection *MediaRejection
+	if !errors.As(err, &rejection) || !errors.Is(err, ErrRecordingTooLong) {
+		t.Fatalf("checkCandidateFrames(%d) = %v, want ErrRecordingTooLong", maxCandidateFrames+1, err)
+	}
+	if rejection.DurationSeconds != 170 || rejection.LimitSeconds != 120 {
+		t.Fatalf("rejection = %+v", rejection)
+	}
+}
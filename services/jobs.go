@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// JobTracker owns the background pipeline work of this instance so that
+// shutdown can wait for it, and interrupt what does not finish in time.
+type JobTracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	active   map[uint]context.CancelFunc
+	draining chan struct{}
+	stopped  bool
+}
+
+func NewJobTracker() *JobTracker {
+	return &JobTracker{
+		active:   make(map[uint]context.CancelFunc),
+		draining: make(chan struct{}),
+	}
+}
+
+// Go runs fn for an argument in the background. ctx contributes its values
+// (request ID, trace) but not its cancellation; the job is cancelled only by
+// Interrupt. Once the tracker is stopped fn is not run and Go returns false.
+func (t *JobTracker) Go(ctx context.Context, argumentID uint, fn func(ctx context.Context, argumentID uint)) bool {
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		cancel()
+		return false
+	}
+	t.active[argumentID] = cancel
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.active, argumentID)
+			t.mu.Unlock()
+			cancel()
+			t.wg.Done()
+		}()
+
+		fn(jobCtx, argumentID)
+	}()
+
+	return true
+}
+
+// StartDraining flags the instance as shutting down. Readiness checks fail
+// and long-lived streams close from this point on.
+func (t *JobTracker) StartDraining() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.draining:
+	default:
+		close(t.draining)
+	}
+}
+
+// Draining is closed once StartDraining has been called
+func (t *JobTracker) Draining() <-chan struct{} {
+	return t.draining
+}
+
+func (t *JobTracker) IsDraining() bool {
+	select {
+	case <-t.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// ActiveCount returns the number of jobs still running
+func (t *JobTracker) ActiveCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+// Wait blocks until every job has finished or ctx is done, and reports
+// whether all jobs finished.
+func (t *JobTracker) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Interrupt stops accepting jobs, cancels the running ones and returns
+// their argument IDs. Cancelled jobs mark themselves for resumption.
+func (t *JobTracker) Interrupt() []uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+
+	ids := make([]uint, 0, len(t.active))
+	for id, cancel := range t.active {
+		ids = append(ids, id)
+		cancel()
+	}
+
+	return ids
+}
@@ -2,17 +2,23 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"os"
+	"log/slog"
 	"strings"
+	"time"
 
-	"github.com/calebchiang/thirdparty_server/database"
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/metrics"
 	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/tracing"
 	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var personaPrompts = map[string]string{
@@ -51,14 +57,20 @@ type aiJSONResponse struct {
 	ManipulationToxicity int    `json:"manipulation_toxicity"`
 }
 
-func GenerateJudgment(argument models.Argument) (*JudgmentResult, error) {
+func GenerateJudgment(ctx context.Context, argument models.Argument) (_ *JudgmentResult, err error) {
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY not set")
-	}
+	ctx, span := tracing.Start(ctx, "openai.judgment", trace.WithAttributes(
+		attribute.String("llm.model", config.App.JudgmentModel),
+		attribute.String("argument.persona", argument.Persona),
+	))
+
+	start := time.Now()
+	defer func() {
+		metrics.ObserveStage(metrics.StageJudgment, start, err, failureReason(err))
+		tracing.End(span, err)
+	}()
 
-	client := openai.NewClient(apiKey)
+	client := newOpenAIClient()
 
 	systemPrompt, ok := personaPrompts[argument.Persona]
 	if !ok {
@@ -74,6 +86,7 @@ PERSON B = %s
 
 - The FIRST person to speak in the transcript is ALWAYS PERSON A (%s).
 - The SECOND person is PERSON B (%s).
+- A line starting with a name and a colon was said by that person.
 
 STANDARD RULES:
 - In the "reasoning" field, ALWAYS refer to them using their actual names (%s and %s).
@@ -147,9 +160,9 @@ Analyze and return your judgment in JSON format.`,
 	)
 
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
-			Model:       openai.GPT4oMini,
+			Model:       config.App.JudgmentModel,
 			Temperature: 0.3,
 			MaxTokens:   500,
 			Messages: []openai.ChatCompletionMessage{
@@ -163,6 +176,16 @@ Analyze and return your judgment in JSON format.`,
 		return nil, err
 	}
 
+	metrics.RecordTokens(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	span.SetAttributes(
+		attribute.Int("llm.prompt_tokens", resp.Usage.PromptTokens),
+		attribute.Int("llm.completion_tokens", resp.Usage.CompletionTokens),
+	)
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("%w: no choices returned", ErrInvalidModelResponse)
+	}
+
 	fullResponse := resp.Choices[0].Message.Content
 
 	result, err := parseJSONResponse(fullResponse, argument)
@@ -192,6 +215,12 @@ Analyze and return your judgment in JSON format.`,
 	}, nil
 }
 
+// fingerprint identifies a model response in logs without revealing it
+func fingerprint(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:6])
+}
+
 func parseJSONResponse(response string, argument models.Argument) (*JudgmentResult, error) {
 
 	response = strings.TrimSpace(response)
@@ -202,7 +231,9 @@ func parseJSONResponse(response string, argument models.Argument) (*JudgmentResu
 
 	var parsed aiJSONResponse
 	if err := json.Unmarshal([]byte(response), &parsed); err != nil {
-		return nil, fmt.Errorf("failed to parse AI JSON response: %v\nRaw response: %s", err, response)
+		// The response quotes the conversation, so only its size and a
+		// fingerprint go into the error and from there into the logs
+		return nil, fmt.Errorf("%w: failed to parse AI JSON response (%d bytes, sha256 %s): %v", ErrInvalidModelResponse, len(response), fingerprint(response), err)
 	}
 
 	var mappedWinner string
@@ -215,13 +246,13 @@ func parseJSONResponse(response string, argument models.Argument) (*JudgmentResu
 	case "tie":
 		mappedWinner = "tie"
 	default:
-		return nil, fmt.Errorf("invalid winner_name returned: %s", parsed.WinnerName)
+		return nil, fmt.Errorf("%w: winner_name matches neither person (%d bytes)", ErrInvalidModelResponse, len(parsed.WinnerName))
 	}
 
 	// Validate score ranges (1–10)
 	validateScore := func(value int, field string) error {
 		if value < 1 || value > 10 {
-			return fmt.Errorf("%s must be between 1 and 10, got %d", field, value)
+			return fmt.Errorf("%w: %s must be between 1 and 10, got %d", ErrInvalidModelResponse, field, value)
 		}
 		return nil
 	}
@@ -254,31 +285,55 @@ func parseJSONResponse(response string, argument models.Argument) (*JudgmentResu
 	}, nil
 }
 
-func ProcessJudgment(argumentID uint) {
+// ProcessJudgment runs in the background after an argument is created. ctx
+// should carry the originating request ID but not its cancellation.
+func (p *Pipeline) ProcessJudgment(ctx context.Context, argumentID uint) {
 
-	fmt.Println("Starting judgment for argument:", argumentID)
+	defer metrics.TrackJudgment()()
 
-	var argument models.Argument
-	if err := database.DB.First(&argument, argumentID).Error; err != nil {
-		fmt.Println("Failed to load argument:", err)
+	// A new trace linked to the request's, since this outlives the request
+	ctx, span := tracing.StartDetached(ctx, "judgment.process", trace.WithAttributes(
+		attribute.Int("argument.id", int(argumentID)),
+	))
+	defer span.End()
+
+	logger := slog.With("argument_id", argumentID)
+	logger.InfoContext(ctx, "judgment started")
+
+	argument, err := p.Arguments.FindByID(ctx, argumentID)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to load argument", "error", err)
 		return
 	}
 
-	fmt.Println("Persona from DB:", argument.Persona)
-
 	if argument.Status == "complete" {
-		fmt.Println("Already completed:", argumentID)
+		logger.InfoContext(ctx, "judgment already complete")
 		return
 	}
 
-	result, err := GenerateJudgment(argument)
+	p.RecordStage(ctx, argument.ID, "processing", StageJudging)
+
+	result, err := GenerateJudgment(ctx, *argument)
+	if err != nil && ctx.Err() != nil {
+		// Interrupted by shutdown rather than a real failure
+		logger.WarnContext(ctx, "judgment interrupted", "error", err)
+		p.MarkForResumption(context.WithoutCancel(ctx), argument.ID)
+		return
+	}
 	if err != nil {
-		fmt.Println("GenerateJudgment failed:", err)
-		database.DB.Model(&argument).Update("status", "failed")
+		logger.ErrorContext(ctx, "judgment generation failed", "persona", argument.Persona, "error", err)
+		span.SetStatus(codes.Error, "judgment generation failed")
+		p.RecordStage(ctx, argument.ID, "failed", StageFailed)
+		p.Notifications.JudgmentFinished(ctx, *argument, "failed")
 		return
 	}
 
-	fmt.Println("Judgment generated successfully.")
+	logger.InfoContext(ctx, "judgment generated", "persona", argument.Persona, "winner", result.Winner)
+
+	// The verdict is paid for; save it even if shutdown interrupts from here
+	ctx = context.WithoutCancel(ctx)
+
+	p.RecordStage(ctx, argument.ID, "processing", StageSaving)
 
 	judgment := models.Judgment{
 		ArgumentID:              argument.ID,
@@ -293,30 +348,45 @@ func ProcessJudgment(argumentID uint) {
 		ConversationHealthScore: result.ConversationHealthScore,
 	}
 
-	if err := database.DB.Create(&judgment).Error; err != nil {
-		fmt.Println("Failed to save judgment:", err)
-		database.DB.Model(&argument).Update("status", "failed")
+	saveStart := time.Now()
+	_, dbSpan := tracing.Start(ctx, "db.insert_judgment")
+	err = p.Judgments.Create(ctx, &judgment)
+	tracing.End(dbSpan, err)
+	metrics.ObserveStage(metrics.StageSave, saveStart, err, "db")
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to save judgment", "error", err)
+		span.SetStatus(codes.Error, "failed to save judgment")
+		p.RecordStage(ctx, argument.ID, "failed", StageFailed)
+		p.Notifications.JudgmentFinished(ctx, *argument, "failed")
 		return
 	}
 
-	database.DB.Model(&argument).Update("status", "complete")
+	p.RecordStage(ctx, argument.ID, "complete", StageComplete)
+	p.Notifications.JudgmentFinished(ctx, *argument, "complete")
 
-	fmt.Println("Judgment saved and argument marked complete:", argumentID)
+	logger.InfoContext(ctx, "judgment complete", "judgment_id", judgment.ID)
 }
 
 func GenerateScreenshotJudgment(
+	ctx context.Context,
 	personAName string,
 	personBName string,
 	persona string,
-	files []*multipart.FileHeader,
-) (*JudgmentResult, error) {
+	screenshots []Screenshot,
+) (_ *JudgmentResult, err error) {
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY not set")
-	}
+	ctx, span := tracing.Start(ctx, "openai.screenshot_judgment", trace.WithAttributes(
+		attribute.String("llm.model", config.App.ScreenshotModel),
+		attribute.Int("screenshots.count", len(screenshots)),
+	))
 
-	client := openai.NewClient(apiKey)
+	start := time.Now()
+	defer func() {
+		metrics.ObserveStage(metrics.StageScreenshotJudgment, start, err, failureReason(err))
+		tracing.End(span, err)
+	}()
+
+	client := newOpenAIClient()
 
 	systemPrompt, ok := personaPrompts[persona]
 	if !ok {
@@ -390,31 +460,25 @@ Do NOT include any extra text outside the JSON.`,
 	// Convert images to base64 parts
 	var contentParts []openai.ChatMessagePart
 
-	for _, fileHeader := range files {
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			return nil, err
-		}
+	for _, screenshot := range screenshots {
 
-		bytes, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			return nil, err
-		}
+		encoded := base64.StdEncoding.EncodeToString(screenshot.Data)
 
-		encoded := base64.StdEncoding.EncodeToString(bytes)
-
-		mimeType := fileHeader.Header.Get("Content-Type")
+		mimeType := screenshot.ContentType
 		if mimeType == "" {
 			mimeType = "image/png"
 		}
 
+		detail := openai.ImageURLDetailHigh
+		if screenshot.Width > 0 && screenshot.Width <= lowDetailSide && screenshot.Height <= lowDetailSide {
+			detail = openai.ImageURLDetailLow
+		}
+
 		contentParts = append(contentParts, openai.ChatMessagePart{
 			Type: openai.ChatMessagePartTypeImageURL,
 			ImageURL: &openai.ChatMessageImageURL{
 				URL:    fmt.Sprintf("data:%s;base64,%s", mimeType, encoded),
-				Detail: "high",
+				Detail: detail,
 			},
 		})
 	}
@@ -426,9 +490,9 @@ Do NOT include any extra text outside the JSON.`,
 	})
 
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
-			Model:       openai.GPT4o,
+			Model:       config.App.ScreenshotModel,
 			Temperature: 0.3,
 			MaxTokens:   800,
 			Messages: []openai.ChatCompletionMessage{
@@ -448,6 +512,16 @@ Do NOT include any extra text outside the JSON.`,
 		return nil, err
 	}
 
+	metrics.RecordTokens(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	span.SetAttributes(
+		attribute.Int("llm.prompt_tokens", resp.Usage.PromptTokens),
+		attribute.Int("llm.completion_tokens", resp.Usage.CompletionTokens),
+	)
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("%w: no choices returned", ErrInvalidModelResponse)
+	}
+
 	fullResponse := resp.Choices[0].Message.Content
 
 	// Create temp argument struct for reuse of parser
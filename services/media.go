@@ -4,22 +4,67 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/metrics"
+	"github.com/calebchiang/thirdparty_server/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// MediaNormalizer checks an uploaded audio or video file against the limits
+// and turns it into the m4a that transcription expects. Rejected uploads
+// return a *MediaRejection. The input is left in place; the caller removes
+// both it and the returned file.
+type MediaNormalizer interface {
+	Normalize(ctx context.Context, inputPath string, limits MediaLimits) (*NormalizedMedia, error)
+}
+
+type NormalizedMedia struct {
+	Path string
+	// DurationSeconds is the upload's length and ProcessedDurationSeconds
+	// that of Path, after pauses were trimmed. Transcript times refer to
+	// the latter.
+	DurationSeconds          float64
+	ProcessedDurationSeconds float64
+	// ChannelPaths holds one file per speaker when each side of a call was
+	// recorded on its own channel; Path is then their mix
+	ChannelPaths []string
+}
+
+// Remove deletes the normalized files
+func (m *NormalizedMedia) Remove() {
+	for _, path := range append([]string{m.Path}, m.ChannelPaths...) {
+		_ = os.Remove(path)
+	}
+}
+
+// TranscribedSeconds is how much audio transcription is billed for
+func (m *NormalizedMedia) TranscribedSeconds() float64 {
+	return m.ProcessedDurationSeconds * float64(max(len(m.ChannelPaths), 1))
+}
+
 type MediaService struct{}
 
 func NewMediaService() *MediaService {
 	return &MediaService{}
 }
 
-func (m *MediaService) saveUploadedFile(fileHeader *multipart.FileHeader) (string, error) {
+// SaveUploadedFile copies a multipart upload into the upload directory
+func SaveUploadedFile(ctx context.Context, fileHeader *multipart.FileHeader) (_ string, err error) {
+	_, span := tracing.Start(ctx, "media.save_upload", trace.WithAttributes(
+		attribute.Int64("upload.size_bytes", fileHeader.Size),
+	))
+	defer func() { tracing.End(span, err) }()
+
 	src, err := fileHeader.Open()
 	if err != nil {
 		return "", err
@@ -27,7 +72,7 @@ func (m *MediaService) saveUploadedFile(fileHeader *multipart.FileHeader) (strin
 	defer src.Close()
 
 	// Ensure uploads directory exists
-	uploadDir := "/tmp/uploads"
+	uploadDir := config.App.UploadDir
 	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
 		return "", err
 	}
@@ -51,23 +96,33 @@ func (m *MediaService) saveUploadedFile(fileHeader *multipart.FileHeader) (strin
 	return dstPath, nil
 }
 
-func (m *MediaService) normalizeToM4A(inputPath string) (string, error) {
+func (m *MediaService) normalizeToM4A(ctx context.Context, inputPath string, maxDuration time.Duration, preset string) (_ string, err error) {
+
+	ctx, span := tracing.Start(ctx, "ffmpeg.normalize", trace.WithAttributes(
+		attribute.String("audio.preset", preset),
+	))
+	defer func() { tracing.End(span, err) }()
 
 	outputPath := filepath.Join(
 		filepath.Dir(inputPath),
 		fmt.Sprintf("normalized_%s.m4a", uuid.New().String()),
 	)
 
-	// 60-second timeout to prevent hanging FFmpeg processes
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	// Timeout to prevent hanging FFmpeg processes
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
 	defer cancel()
 
-	cmd := exec.CommandContext(
-		ctx,
-		"ffmpeg",
+	args := []string{
 		"-y",
 		"-i", inputPath,
 		"-vn",
+		// Probed durations can be wrong; never convert past the limit
+		"-t", strconv.FormatFloat(maxDuration.Seconds(), 'f', 0, 64),
+	}
+	if filter := AudioPresets[preset].Filter(); filter != "" {
+		args = append(args, "-af", filter)
+	}
+	args = append(args,
 		"-ac", "1",
 		"-ar", "16000",
 		"-c:a", "aac",
@@ -75,32 +130,106 @@ func (m *MediaService) normalizeToM4A(inputPath string) (string, error) {
 		outputPath,
 	)
 
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	start := time.Now()
+
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		_ = os.Remove(outputPath)
-		return "", fmt.Errorf("ffmpeg error: %v, output: %s", err, string(output))
+		slog.ErrorContext(ctx, "ffmpeg normalize failed",
+			"error", err,
+			"elapsed_ms", time.Since(start).Milliseconds(),
+		)
+		return "", fmt.Errorf("ffmpeg error: %w, output: %s", err, string(output))
 	}
 
+	slog.InfoContext(ctx, "ffmpeg normalize complete", "elapsed_ms", time.Since(start).Milliseconds())
+
 	return outputPath, nil
 }
 
-func (m *MediaService) Normalize(fileHeader *multipart.FileHeader) (string, error) {
+func (m *MediaService) Normalize(ctx context.Context, inputPath string, limits MediaLimits) (_ *NormalizedMedia, err error) {
+
+	ctx, span := tracing.Start(ctx, "media.normalize")
 
-	// 1. Save original file
-	originalPath, err := m.saveUploadedFile(fileHeader)
+	start := time.Now()
+	defer func() {
+		metrics.ObserveStage(metrics.StageNormalize, start, err, failureReason(err))
+		tracing.End(span, err)
+	}()
+
+	// 1. Check it is media we can judge before spending time converting it
+	info, err := ProbeMedia(ctx, inputPath)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if err := info.Validate(limits); err != nil {
+		slog.InfoContext(ctx, "media rejected",
+			"reason", err.Error(),
+			"duration_seconds", info.DurationSeconds,
+		)
+		return nil, err
 	}
 
-	// 2. Convert to standardized m4a
-	normalizedPath, err := m.normalizeToM4A(originalPath)
+	// 2. Clean up and convert to standardized m4a, per speaker when each
+	// has their own channel
+	normalized := &NormalizedMedia{DurationSeconds: info.DurationSeconds}
+	if info.AudioChannels >= 2 && config.App.Diarization == "channels" {
+		separate, err := speakersOnSeparateChannels(ctx, inputPath)
+		if err != nil {
+			// Transcribing the mix still works
+			slog.WarnContext(ctx, "measuring channels failed", "error", err)
+		}
+		if separate {
+			normalized.Path, normalized.ChannelPaths, err = m.normalizeChannels(ctx, inputPath, limits.MaxDuration, config.App.AudioPreset)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if normalized.Path == "" {
+		normalized.Path, err = m.normalizeToM4A(ctx, inputPath, limits.MaxDuration, config.App.AudioPreset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Measure what silence trimming left, which is what gets billed
+	processed, err := ProbeMedia(ctx, normalized.Path)
 	if err != nil {
-		_ = os.Remove(originalPath)
-		return "", err
+		normalized.Remove()
+		return nil, fmt.Errorf("probing normalized audio: %v", err)
 	}
+	normalized.ProcessedDurationSeconds = processed.DurationSeconds
 
-	// 3. Delete original after successful normalization
-	_ = os.Remove(originalPath)
+	if err := checkProcessedDuration(processed.DurationSeconds, limits); err != nil {
+		normalized.Remove()
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "audio preprocessed",
+		"preset", config.App.AudioPreset,
+		"duration_seconds", info.DurationSeconds,
+		"processed_duration_seconds", processed.DurationSeconds,
+		"channels_transcribed", len(normalized.ChannelPaths),
+	)
+
+	return normalized, nil
+}
+
+// checkProcessedDuration rejects a recording that was nearly all silence;
+// once trimmed there is nothing to judge
+func checkProcessedDuration(seconds float64, limits MediaLimits) error {
+	if seconds >= limits.MinDuration.Seconds() {
+		return nil
+	}
 
-	return normalizedPath, nil
+	return &MediaRejection{
+		Reason:          ErrRecordingTooShort,
+		Detail:          fmt.Sprintf("only %.1fs is speech, less than the %s minimum", seconds, limits.MinDuration),
+		DurationSeconds: seconds,
+		LimitSeconds:    limits.MinDuration.Seconds(),
+	}
 }
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MediaArchive files original uploads in the blob store and records their
+// keys against the argument they belong to
+type MediaArchive struct {
+	Blobs BlobStore
+	Media repository.MediaBlobRepo
+}
+
+func NewMediaArchive(blobs BlobStore, media repository.MediaBlobRepo) *MediaArchive {
+	return &MediaArchive{Blobs: blobs, Media: media}
+}
+
+// MediaLink is a stored blob with a download URL valid until ExpiresAt
+type MediaLink struct {
+	Blob      models.MediaBlob
+	URL       string
+	ExpiresAt time.Time
+}
+
+var extensionPattern = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// Save stores body as the argument's kind at position, e.g. its recording
+// or its third screenshot
+func (a *MediaArchive) Save(ctx context.Context, argument models.Argument, kind string, position int, body io.Reader, size int64, contentType string, filename string) (_ *models.MediaBlob, err error) {
+	ctx, span := tracing.Start(ctx, "blob.put", trace.WithAttributes(
+		attribute.String("blob.kind", kind),
+		attribute.Int64("blob.size_bytes", size),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	// Keys keep the extension so downloads open in the right app
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !extensionPattern.MatchString(ext) {
+		ext = ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	key := fmt.Sprintf("users/%d/arguments/%d/%s-%d%s", argument.UserID, argument.ID, kind, position, ext)
+
+	if err := a.Blobs.Put(ctx, key, body, size, mediaType); err != nil {
+		return nil, err
+	}
+
+	blob := &models.MediaBlob{
+		ArgumentID:  &argument.ID,
+		Kind:        kind,
+		Key:         key,
+		ContentType: mediaType,
+		SizeBytes:   size,
+		Position:    position,
+	}
+	if err := a.Media.Create(ctx, blob); err != nil {
+		_ = a.Blobs.Delete(ctx, key)
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// SaveFile stores a file on disk, see Save
+func (a *MediaArchive) SaveFile(ctx context.Context, argument models.Argument, kind string, position int, path string, contentType string, filename string) (*models.MediaBlob, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Save(ctx, argument, kind, position, file, stat.Size(), contentType, filename)
+}
+
+// Links signs a download URL for every blob of the argument except cached
+// clips
+func (a *MediaArchive) Links(ctx context.Context, argumentID uint) ([]MediaLink, error) {
+	blobs, err := a.Media.ListForArgument(ctx, argumentID)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(config.App.BlobURLTTL)
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+
+	links := make([]MediaLink, 0, len(blobs))
+	for _, blob := range blobs {
+		if blob.Kind == models.MediaKindClip {
+			continue
+		}
+
+		url, err := a.Blobs.SignedURL(ctx, blob.Key, ttl)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, MediaLink{Blob: blob, URL: url, ExpiresAt: expiresAt})
+	}
+
+	return links, nil
+}
+
+const sweepBatchSize = 100
+
+// Sweep deletes blobs past the retention period and those whose argument
+// was deleted, object first so a failure leaves the row to retry
+func (a *MediaArchive) Sweep(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-time.Duration(config.App.BlobRetention))
+	removed := 0
+
+	for {
+		blobs, err := a.Media.ListExpired(ctx, cutoff, sweepBatchSize)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, blob := range blobs {
+			if err := a.Blobs.Delete(ctx, blob.Key); err != nil {
+				return removed, fmt.Errorf("deleting %s: %w", blob.Key, err)
+			}
+			if err := a.Media.Delete(ctx, blob.ID); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+
+		if len(blobs) < sweepBatchSize {
+			return removed, nil
+		}
+	}
+}
+
+// RunSweeper periodically applies the retention policy until ctx is done
+func (a *MediaArchive) RunSweeper(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := a.Sweep(ctx)
+			if err != nil {
+				slog.Warn("sweeping media failed", "error", err)
+			}
+			if removed > 0 {
+				slog.Info("expired media removed", "count", removed)
+			}
+		}
+	}
+}
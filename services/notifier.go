@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/repository"
+)
+
+// ErrInvalidDeviceToken is returned by a Notifier when the push provider
+// reports that a token is no longer valid. The device should be forgotten.
+var ErrInvalidDeviceToken = errors.New("invalid device token")
+
+type Notification struct {
+	Title string
+	Body  string
+	Data  map[string]interface{}
+}
+
+type Notifier interface {
+	Send(ctx context.Context, device models.Device, notification Notification) error
+}
+
+type SentNotification struct {
+	Device       models.Device
+	Notification Notification
+}
+
+// FakeNotifier records notifications instead of delivering them. Tokens in
+// InvalidTokens are rejected the way APNs rejects unregistered devices.
+type FakeNotifier struct {
+	mu            sync.Mutex
+	Sent          []SentNotification
+	InvalidTokens map[string]bool
+}
+
+func NewFakeNotifier() *FakeNotifier {
+	return &FakeNotifier{InvalidTokens: map[string]bool{}}
+}
+
+func (f *FakeNotifier) Send(ctx context.Context, device models.Device, notification Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.InvalidTokens[device.Token] {
+		return ErrInvalidDeviceToken
+	}
+
+	f.Sent = append(f.Sent, SentNotification{Device: device, Notification: notification})
+	slog.InfoContext(ctx, "push sent (fake)", "device_id", device.ID, "title", notification.Title)
+
+	return nil
+}
+
+// Notifications sends pushes about an argument to its owner's devices
+type Notifications struct {
+	Users   repository.UserRepo
+	Devices repository.DeviceRepo
+	Push    Notifier
+}
+
+// JudgmentFinished tells the owner's devices that an argument reached a
+// terminal status, honouring their notification preferences. Devices whose
+// tokens are rejected are deleted.
+func (n *Notifications) JudgmentFinished(ctx context.Context, argument models.Argument, status string) {
+
+	user, err := n.Users.FindByID(ctx, argument.UserID)
+	if err != nil {
+		slog.WarnContext(ctx, "push skipped, user not found", "user_id", argument.UserID)
+		return
+	}
+
+	var notification Notification
+
+	switch status {
+	case "complete":
+		if !user.NotifyOnComplete {
+			return
+		}
+		notification = Notification{
+			Title: "The verdict is in",
+			Body:  fmt.Sprintf("Your argument between %s and %s has been judged.", argument.PersonAName, argument.PersonBName),
+		}
+	case "failed":
+		if !user.NotifyOnFailure {
+			return
+		}
+		notification = Notification{
+			Title: "We couldn't judge your argument",
+			Body:  fmt.Sprintf("Something went wrong judging %s vs %s. Please try again.", argument.PersonAName, argument.PersonBName),
+		}
+	default:
+		return
+	}
+
+	notification.Data = map[string]interface{}{
+		"argument_id": argument.ID,
+		"status":      status,
+	}
+
+	devices, err := n.Devices.ListForUser(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load devices", "user_id", user.ID, "error", err)
+		return
+	}
+
+	for _, device := range devices {
+		err := n.Push.Send(ctx, device, notification)
+		if err == nil {
+			continue
+		}
+
+		if errors.Is(err, ErrInvalidDeviceToken) {
+			slog.InfoContext(ctx, "removing invalid device", "device_id", device.ID)
+			_ = n.Devices.Delete(ctx, device.ID)
+			continue
+		}
+
+		slog.ErrorContext(ctx, "push failed", "device_id", device.ID, "error", err)
+	}
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/logging"
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// openAIHTTPClient tags every OpenAI call with the originating request ID
+// and records a client span for it
+var openAIHTTPClient = &http.Client{
+	Transport: otelhttp.NewTransport(logging.Transport(http.DefaultTransport)),
+}
+
+// openAIBaseURL points requests at OpenAI or an API-compatible server
+func openAIBaseURL() string {
+	return strings.TrimSuffix(config.App.OpenAIBaseURL, "/")
+}
+
+func newOpenAIClient() *openai.Client {
+	clientConfig := openai.DefaultConfig(config.App.OpenAIAPIKey)
+	clientConfig.BaseURL = openAIBaseURL()
+	clientConfig.HTTPClient = openAIHTTPClient
+
+	return openai.NewClientWithConfig(clientConfig)
+}
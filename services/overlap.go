@@ -0,0 +1,192 @@
+package services
+
+import (
+	"image"
+	"math"
+)
+
+// Screenshots of one chat overlap: scrolling moves the messages up or down
+// while the app's header and input bar stay put. Comparing brightness
+// profiles row by row finds how far the content moved between two images,
+// and so how much of the second one is new.
+
+const (
+	profileColumns = 32
+	// profileRowHeight pixels of a video frame are averaged into each
+	// profile row
+	profileRowHeight = 4
+	// Mean difference, out of 255, below which two profile rows count as
+	// the same; compression noise stays well under it
+	profileRowTolerance = 4.0
+)
+
+type profileRow [profileColumns]float64
+
+// rowProfile is an image reduced to the average brightness of a few
+// columns per band of rows
+type rowProfile []profileRow
+
+// profileImage averages rowHeight pixel rows into each profile row. Scrolls
+// that aren't a whole number of rows blur the match, so small images need
+// short rows.
+func profileImage(img image.Image, rowHeight int) rowProfile {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < profileColumns || height < rowHeight {
+		return nil
+	}
+
+	profile := make(rowProfile, height/rowHeight)
+	counts := make([]profileRow, len(profile))
+
+	// JPEG frames carry luma already; reading it directly is much faster
+	// than going through At
+	ycc, _ := img.(*image.YCbCr)
+
+	for y := 0; y < len(profile)*rowHeight; y++ {
+		row := y / rowHeight
+		for x := 0; x < width; x++ {
+			var luma float64
+			if ycc != nil {
+				luma = float64(ycc.Y[ycc.YOffset(bounds.Min.X+x, bounds.Min.Y+y)])
+			} else {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				// Rec. 601 luma, scaled from 16 bits to 0-255
+				luma = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			}
+
+			column := x * profileColumns / width
+			profile[row][column] += luma
+			counts[row][column]++
+		}
+	}
+
+	for row := range profile {
+		for column := range profile[row] {
+			profile[row][column] /= counts[row][column]
+		}
+	}
+
+	return profile
+}
+
+func rowDistance(a, b *profileRow) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += math.Abs(a[i] - b[i])
+	}
+	return sum / profileColumns
+}
+
+// overlap says how the content of one image moved to reach the next.
+// Shift is in profile rows, to the nearest half row: positive when the content moved up, as when
+// scrolling down to newer messages, and negative when it moved down.
+type overlap struct {
+	Found bool
+	Shift float64
+	// Novel is the fraction of the scrolling area that is new, 1 when the
+	// images share nothing
+	Novel float64
+}
+
+// measureOverlap finds the scroll between a and b. Rows that are the same
+// at the top and bottom of both, like a header and input bar, are left out
+// so they don't anchor the match at no scroll.
+func measureOverlap(a, b rowProfile) overlap {
+	height := len(a)
+	if height == 0 || len(b) != height {
+		return overlap{Novel: 1}
+	}
+
+	top := 0
+	for top < height && rowDistance(&a[top], &b[top]) < profileRowTolerance {
+		top++
+	}
+	if top == height {
+		return overlap{Found: true}
+	}
+
+	bottom := 0
+	for bottom < height-top && rowDistance(&a[height-1-bottom], &b[height-1-bottom]) < profileRowTolerance {
+		bottom++
+	}
+
+	from, to := a[top:height-bottom], b[top:height-bottom]
+	rows := len(from)
+
+	// The images must share at least a fifth of the scrolling area, so
+	// a few similar rows can't pass for an overlap
+	minShared := max(rows/5, 1)
+
+	bestShift, bestDistance := 0, math.Inf(1)
+	for shift := -(rows - minShared); shift <= rows-minShared; shift++ {
+		distance := shiftDistance(from, to, shift, false, bestDistance)
+		if distance < bestDistance || (distance == bestDistance && abs(shift) < abs(bestShift)) {
+			bestShift, bestDistance = shift, distance
+		}
+	}
+
+	// Scrolls rarely land on a whole row; half a row either side of the
+	// best shift catches the rest of the way
+	refined := float64(bestShift)
+	for _, shift := range []int{bestShift - 1, bestShift} {
+		if abs(shift) > rows-minShared {
+			continue
+		}
+		if distance := shiftDistance(from, to, shift, true, bestDistance); distance < bestDistance {
+			refined, bestDistance = float64(shift)+0.5, distance
+		}
+	}
+
+	if bestDistance >= profileRowTolerance {
+		return overlap{Novel: 1}
+	}
+
+	return overlap{
+		Found: true,
+		Shift: refined,
+		Novel: math.Abs(refined) / float64(rows),
+	}
+}
+
+// shiftDistance is the mean distance between the rows of to and the rows
+// of from shift rows on, or half a row further when half is set. It gives
+// up once the mean is sure to exceed limit.
+func shiftDistance(from, to rowProfile, shift int, half bool, limit float64) float64 {
+	first := max(0, -shift)
+	last := min(len(to), len(from)-shift)
+	if half {
+		last = min(last, len(from)-shift-1)
+	}
+	shared := last - first
+	if shared <= 0 {
+		return math.Inf(1)
+	}
+
+	sum := 0.0
+	for i := first; i < last; i++ {
+		row := &from[i+shift]
+		if half {
+			var between profileRow
+			for c := range between {
+				between[c] = (from[i+shift][c] + from[i+shift+1][c]) / 2
+			}
+			row = &between
+		}
+		sum += rowDistance(row, &to[i])
+
+		// Already worse than the limit; stop early
+		if sum > limit*float64(shared) {
+			return math.Inf(1)
+		}
+	}
+
+	return sum / float64(shared)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
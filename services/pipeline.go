@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/calebchiang/thirdparty_server/repository"
+)
+
+// Pipeline runs judgments in the background and records their progress
+type Pipeline struct {
+	Arguments     repository.ArgumentRepo
+	Judgments     repository.JudgmentRepo
+	Notifications *Notifications
+	Jobs          *JobTracker
+}
+
+func NewPipeline(repos *repository.Repositories, notifications *Notifications, jobs *JobTracker) *Pipeline {
+	return &Pipeline{
+		Arguments:     repos.Arguments,
+		Judgments:     repos.Judgments,
+		Notifications: notifications,
+		Jobs:          jobs,
+	}
+}
+
+// Enqueue starts judging an argument in the background. Once shutdown has
+// begun the argument is marked for resumption instead.
+func (p *Pipeline) Enqueue(ctx context.Context, argumentID uint) {
+	if !p.Jobs.Go(ctx, argumentID, p.ProcessJudgment) {
+		p.MarkForResumption(ctx, argumentID)
+	}
+}
+
+// RecordStage updates the argument status and publishes the transition.
+// Failures are logged; a missed event is recovered by the stream's catch-up.
+func (p *Pipeline) RecordStage(ctx context.Context, argumentID uint, status string, stage string) {
+	if _, err := p.Arguments.RecordStage(ctx, argumentID, status, stage); err != nil {
+		slog.ErrorContext(ctx, "failed to record argument stage",
+			"argument_id", argumentID,
+			"status", status,
+			"stage", stage,
+			"error", err,
+		)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AudioPreset describes the ffmpeg filters run on a recording before it is
+// transcribed. Phone recordings of arguments are noisy, vary in volume and
+// have long pauses; cleaning them up helps Whisper and cutting the pauses
+// saves billed seconds.
+type AudioPreset struct {
+	// HighpassHz cuts rumble and handling noise below it; 0 disables it
+	HighpassHz int
+	// NoiseFloorDB is afftdn's estimate of the noise level; 0 disables
+	// denoising
+	NoiseFloorDB int
+	// Loudnorm evens out the volume to EBU R128 speech levels, so quiet and
+	// shouting speakers land at the same level
+	Loudnorm bool
+	// Pauses quieter than SilenceThresholdDB for longer than MinSilence are
+	// shortened to KeepSilence. Thresholds are measured after loudnorm, so
+	// they work for quiet and loud recordings alike. MinSilence of 0
+	// disables trimming.
+	SilenceThresholdDB int
+	MinSilence         time.Duration
+	KeepSilence        time.Duration
+}
+
+// AudioPresets are selected by AUDIO_PRESET
+var AudioPresets = map[string]AudioPreset{
+	"none": {},
+	"light": {
+		HighpassHz: 80,
+		Loudnorm:   true,
+	},
+	"standard": {
+		HighpassHz:         80,
+		NoiseFloorDB:       -25,
+		Loudnorm:           true,
+		SilenceThresholdDB: -45,
+		MinSilence:         1500 * time.Millisecond,
+		KeepSilence:        500 * time.Millisecond,
+	},
+	"aggressive": {
+		HighpassHz:         120,
+		NoiseFloorDB:       -20,
+		Loudnorm:           true,
+		SilenceThresholdDB: -38,
+		MinSilence:         700 * time.Millisecond,
+		KeepSilence:        300 * time.Millisecond,
+	},
+}
+
+// Filter is the -af argument for the preset, empty when it does nothing
+func (p AudioPreset) Filter() string {
+	var filters []string
+
+	if p.HighpassHz > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%d", p.HighpassHz))
+	}
+	if p.NoiseFloorDB != 0 {
+		filters = append(filters, fmt.Sprintf("afftdn=nf=%d", p.NoiseFloorDB))
+	}
+	if p.Loudnorm {
+		filters = append(filters, "loudnorm=I=-16:TP=-1.5:LRA=11")
+	}
+	if p.MinSilence > 0 {
+		// Leading silence goes entirely; pauses inside are shortened
+		filters = append(filters, fmt.Sprintf(
+			"silenceremove=start_periods=1:start_threshold=%ddB:"+
+				"stop_periods=-1:stop_duration=%.2f:stop_threshold=%ddB:stop_silence=%.2f",
+			p.SilenceThresholdDB, p.MinSilence.Seconds(), p.SilenceThresholdDB, p.KeepSilence.Seconds(),
+		))
+	}
+
+	return strings.Join(filters, ",")
+}
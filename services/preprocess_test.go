@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAudioPresetFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset AudioPreset
+		want   string
+	}{
+		{name: "none", preset: AudioPresets["none"], want: ""},
+		{
+			name:   "light",
+			preset: AudioPresets["light"],
+			want:   "highpass=f=80,loudnorm=I=-16:TP=-1.5:LRA=11",
+		},
+		{
+			name:   "standard",
+			preset: AudioPresets["standard"],
+			want: "highpass=f=80,afftdn=nf=-25,loudnorm=I=-16:TP=-1.5:LRA=11," +
+				"silenceremove=start_periods=1:start_threshold=-45dB:stop_periods=-1:stop_duration=1.50:stop_threshold=-45dB:stop_silence=0.50",
+		},
+		{
+			name:   "aggressive",
+			preset: AudioPresets["aggressive"],
+			want: "highpass=f=120,afftdn=nf=-20,loudnorm=I=-16:TP=-1.5:LRA=11," +
+				"silenceremove=start_periods=1:start_threshold=-38dB:stop_periods=-1:stop_duration=0.70:stop_threshold=-38dB:stop_silence=0.30",
+		},
+		{
+			// Channels must keep their pauses to share a timeline
+			name:   "standard per channel",
+			preset: channelPreset("standard"),
+			want:   "highpass=f=80,afftdn=nf=-25,loudnorm=I=-16:TP=-1.5:LRA=11",
+		},
+		{
+			name:   "aggressive per channel",
+			preset: channelPreset("aggressive"),
+			want:   "highpass=f=120,afftdn=nf=-20,loudnorm=I=-16:TP=-1.5:LRA=11",
+		},
+		{
+			name:   "trimming only",
+			preset: AudioPreset{SilenceThresholdDB: -50, MinSilence: 2 * time.Second},
+			want:   "silenceremove=start_periods=1:start_threshold=-50dB:stop_periods=-1:stop_duration=2.00:stop_threshold=-50dB:stop_silence=0.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.preset.Filter(); got != tt.want {
+				t.Fatalf("Filter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckProcessedDuration(t *testing.T) {
+	limits := MediaLimits{MinDuration: 2 * time.Second, MaxDuration: 10 * time.Minute}
+
+	// A 40s upload that passed validation but was mostly silence
+	err := checkProcessedDuration(1.4, limits)
+
+	var rejection *MediaRejection
+	if !errors.As(err, &rejection) || !errors.Is(err, ErrRecordingTooShort) {
+		t.Fatalf("checkProcessedDuration(1.4) = %v, want ErrRecordingTooShort", err)
+	}
+	if rejection.DurationSeconds != 1.4 || rejection.LimitSeconds != 2 {
+		t.Fatalf("rejection = %+v", rejection)
+	}
+
+	for _, seconds := range []float64{2, 35.2} {
+		if err := checkProcessedDuration(seconds, limits); err != nil {
+			t.Fatalf("checkProcessedDuration(%v) = %v, want nil", seconds, err)
+		}
+	}
+}
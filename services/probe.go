@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/models"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Reasons an upload is rejected before any conversion or transcription.
+// They reach callers wrapped in a *MediaRejection.
+var (
+	ErrUnsupportedMedia  = errors.New("unsupported media")
+	ErrNoAudioStream     = errors.New("no audio stream")
+	ErrRecordingTooShort = errors.New("recording too short")
+	ErrRecordingTooLong  = errors.New("recording too long")
+)
+
+// MediaRejection says why an upload was refused
+type MediaRejection struct {
+	Reason error
+	Detail string
+
+	DurationSeconds float64
+	// LimitSeconds is the bound that was crossed, for duration rejections
+	LimitSeconds float64
+}
+
+func (e *MediaRejection) Error() string {
+	return fmt.Sprintf("%v: %s", e.Reason, e.Detail)
+}
+
+func (e *MediaRejection) Unwrap() error {
+	return e.Reason
+}
+
+// MediaLimits bounds the length of a recording
+type MediaLimits struct {
+	MinDuration time.Duration
+	MaxDuration time.Duration
+}
+
+// MediaLimitsFor returns the duration limits of the user's tier
+func MediaLimitsFor(user models.User) MediaLimits {
+	limits := MediaLimits{
+		MinDuration: time.Duration(config.App.MinRecordingDuration),
+		MaxDuration: time.Duration(config.App.MaxRecordingDurationFree),
+	}
+	if user.IsPremium {
+		limits.MaxDuration = time.Duration(config.App.MaxRecordingDurationPremium)
+	}
+	return limits
+}
+
+// Check rejects durations outside the limits
+func (l MediaLimits) Check(durationSeconds float64) error {
+	if durationSeconds < l.MinDuration.Seconds() {
+		return &MediaRejection{
+			Reason:          ErrRecordingTooShort,
+			Detail:          fmt.Sprintf("%.1fs is shorter than the %s minimum", durationSeconds, l.MinDuration),
+			DurationSeconds: durationSeconds,
+			LimitSeconds:    l.MinDuration.Seconds(),
+		}
+	}
+	if durationSeconds > l.MaxDuration.Seconds() {
+		return &MediaRejection{
+			Reason:          ErrRecordingTooLong,
+			Detail:          fmt.Sprintf("%.0fs is longer than the %s maximum", durationSeconds, l.MaxDuration),
+			DurationSeconds: durationSeconds,
+			LimitSeconds:    l.MaxDuration.Seconds(),
+		}
+	}
+	return nil
+}
+
+// MediaInfo is what ffprobe reports about an upload
+type MediaInfo struct {
+	Formats         []string
+	AudioCodec      string
+	AudioChannels   int
+	HasVideo        bool
+	DurationSeconds float64
+}
+
+// Containers and audio codecs phones and browsers record in. ffprobe
+// names formats by demuxer, e.g. "mov,mp4,m4a,3gp,3g2,mj2".
+var (
+	supportedFormats = map[string]bool{
+		"mov": true, "mp4": true, "m4a": true, "3gp": true,
+		"matroska": true, "webm": true, "ogg": true, "wav": true,
+		"mp3": true, "aac": true, "flac": true, "caf": true, "amr": true,
+	}
+	supportedAudioCodecs = map[string]bool{
+		"aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true,
+		"alac": true, "amr_nb": true, "amr_wb": true,
+	}
+)
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Channels  int    `json:"channels"`
+		Duration  string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+}
+
+// ProbeMedia inspects a file with ffprobe. Files ffprobe can't read at all
+// are reported as unsupported media rather than as a processing failure.
+func ProbeMedia(ctx context.Context, path string) (_ *MediaInfo, err error) {
+
+	ctx, span := tracing.Start(ctx, "ffprobe.inspect")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			return nil, &MediaRejection{Reason: ErrUnsupportedMedia, Detail: "not an audio or video file"}
+		}
+		return nil, fmt.Errorf("ffprobe error: %w", err)
+	}
+
+	info, err := parseProbeOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("media.format", strings.Join(info.Formats, ",")),
+		attribute.String("media.audio_codec", info.AudioCodec),
+		attribute.Float64("media.duration_seconds", info.DurationSeconds),
+	)
+
+	return info, nil
+}
+
+func parseProbeOutput(output []byte) (*MediaInfo, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{}
+	if probe.Format.FormatName != "" {
+		info.Formats = strings.Split(probe.Format.FormatName, ",")
+	}
+
+	var audioDuration string
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+				info.AudioChannels = stream.Channels
+				audioDuration = stream.Duration
+			}
+		case "video":
+			info.HasVideo = true
+		}
+	}
+
+	// Some containers only carry the duration on the stream
+	for _, raw := range []string{probe.Format.Duration, audioDuration} {
+		if seconds, err := strconv.ParseFloat(raw, 64); err == nil && seconds > 0 {
+			info.DurationSeconds = seconds
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// Validate checks the container, codec, audio stream and duration
+func (info *MediaInfo) Validate(limits MediaLimits) error {
+	supported := false
+	for _, format := range info.Formats {
+		if supportedFormats[format] {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return &MediaRejection{Reason: ErrUnsupportedMedia, Detail: fmt.Sprintf("container %q is not supported", strings.Join(info.Formats, ","))}
+	}
+
+	if info.AudioCodec == "" {
+		return &MediaRejection{Reason: ErrNoAudioStream, Detail: "the file has no audio track"}
+	}
+
+	if !supportedAudioCodecs[info.AudioCodec] && !strings.HasPrefix(info.AudioCodec, "pcm_") {
+		return &MediaRejection{Reason: ErrUnsupportedMedia, Detail: fmt.Sprintf("audio codec %q is not supported", info.AudioCodec)}
+	}
+
+	if info.DurationSeconds == 0 {
+		return &MediaRejection{Reason: ErrUnsupportedMedia, Detail: "the duration could not be determined"}
+	}
+
+	return limits.Check(info.DurationSeconds)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   MediaInfo
+	}{
+		{
+			name: "voice memo",
+			output: `{
+				"streams": [{"index": 0, "codec_type": "audio", "codec_name": "aac", "channels": 1, "duration": "93.480000"}],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "93.500000"}
+			}`,
+			want: MediaInfo{
+				Formats:         []string{"mov", "mp4", "m4a", "3gp", "3g2", "mj2"},
+				AudioCodec:      "aac",
+				AudioChannels:   1,
+				DurationSeconds: 93.5,
+			},
+		},
+		{
+			name: "video with stereo audio",
+			output: `{
+				"streams": [
+					{"index": 0, "codec_type": "video", "codec_name": "h264"},
+					{"index": 1, "codec_type": "audio", "codec_name": "aac", "channels": 2, "duration": "12.000000"}
+				],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.010000"}
+			}`,
+			want: MediaInfo{
+				Formats:         []string{"mov", "mp4", "m4a", "3gp", "3g2", "mj2"},
+				AudioCodec:      "aac",
+				AudioChannels:   2,
+				HasVideo:        true,
+				DurationSeconds: 12.01,
+			},
+		},
+		{
+			name: "duration only on the stream",
+			output: `{
+				"streams": [{"index": 0, "codec_type": "audio", "codec_name": "opus", "channels": 1, "duration": "41.250000"}],
+				"format": {"format_name": "matroska,webm", "duration": "N/A"}
+			}`,
+			want: MediaInfo{
+				Formats:         []string{"matroska", "webm"},
+				AudioCodec:      "opus",
+				AudioChannels:   1,
+				DurationSeconds: 41.25,
+			},
+		},
+		{
+			name: "first audio stream wins",
+			output: `{
+				"streams": [
+					{"index": 0, "codec_type": "audio", "codec_name": "mp3", "channels": 2, "duration": "8.000000"},
+					{"index": 1, "codec_type": "audio", "codec_name": "aac", "channels": 6, "duration": "9.000000"}
+				],
+				"format": {"format_name": "matroska,webm"}
+			}`,
+			want: MediaInfo{
+				Formats:         []string{"matroska", "webm"},
+				AudioCodec:      "mp3",
+				AudioChannels:   2,
+				DurationSeconds: 8,
+			},
+		},
+		{
+			name: "no audio stream",
+			output: `{
+				"streams": [{"index": 0, "codec_type": "video", "codec_name": "hevc", "duration": "5.000000"}],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "5.000000"}
+			}`,
+			want: MediaInfo{
+				Formats:         []string{"mov", "mp4", "m4a", "3gp", "3g2", "mj2"},
+				HasVideo:        true,
+				DurationSeconds: 5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseProbeOutput([]byte(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.AudioCodec != tt.want.AudioCodec || info.AudioChannels != tt.want.AudioChannels ||
+				info.HasVideo != tt.want.HasVideo || info.DurationSeconds != tt.want.DurationSeconds ||
+				len(info.Formats) != len(tt.want.Formats) {
+				t.Fatalf("info = %+v, want %+v", *info, tt.want)
+			}
+			for i := range info.Formats {
+				if info.Formats[i] != tt.want.Formats[i] {
+					t.Fatalf("formats = %v, want %v", info.Formats, tt.want.Formats)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProbeOutputRejectsGarbage(t *testing.T) {
+	if _, err := parseProbeOutput([]byte("not json")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMediaInfoValidate(t *testing.T) {
+	limits := MediaLimits{MinDuration: 3 * time.Second, MaxDuration: 10 * time.Minute}
+
+	memo := func(change func(*MediaInfo)) *MediaInfo {
+		info := &MediaInfo{
+			Formats:         []string{"mov", "mp4", "m4a"},
+			AudioCodec:      "aac",
+			AudioChannels:   1,
+			DurationSeconds: 60,
+		}
+		if change != nil {
+			change(info)
+		}
+		return info
+	}
+
+	tests := []struct {
+		name      string
+		info      *MediaInfo
+		want      error
+		wantLimit float64
+	}{
+		{name: "accepted", info: memo(nil)},
+		{name: "pcm wav", info: memo(func(i *MediaInfo) { i.Formats, i.AudioCodec = []string{"wav"}, "pcm_s16le" })},
+		{name: "unsupported container", info: memo(func(i *MediaInfo) { i.Formats = []string{"avi"} }), want: ErrUnsupportedMedia},
+		{name: "no audio stream", info: memo(func(i *MediaInfo) { i.AudioCodec = "" }), want: ErrNoAudioStream},
+		{name: "unsupported codec", info: memo(func(i *MediaInfo) { i.AudioCodec = "wmav2" }), want: ErrUnsupportedMedia},
+		{name: "unknown duration", info: memo(func(i *MediaInfo) { i.DurationSeconds = 0 }), want: ErrUnsupportedMedia},
+		{name: "below the minimum", info: memo(func(i *MediaInfo) { i.DurationSeconds = 2.5 }), want: ErrRecordingTooShort, wantLimit: 3},
+		{name: "at the minimum", info: memo(func(i *MediaInfo) { i.DurationSeconds = 3 })},
+		{name: "over the tier maximum", info: memo(func(i *MediaInfo) { i.DurationSeconds = 601 }), want: ErrRecordingTooLong, wantLimit: 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.info.Validate(limits)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var rejection *MediaRejection
+			if !errors.As(err, &rejection) || !errors.Is(err, tt.want) {
+				t.Fatalf("Validate() = %v, want a rejection for %v", err, tt.want)
+			}
+			if rejection.LimitSeconds != tt.wantLimit {
+				t.Fatalf("limit = %v, want %v", rejection.LimitSeconds, tt.wantLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+)
+
+// MarkForResumption flags an argument whose judgment was interrupted by a
+// shutdown. The next instance to boot picks it up again.
+func (p *Pipeline) MarkForResumption(ctx context.Context, argumentID uint) {
+	marked, err := p.Arguments.MarkResumePending(ctx, argumentID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to mark argument for resumption", "argument_id", argumentID, "error", err)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	p.RecordStage(ctx, argumentID, "processing", StageInterrupted)
+	slog.InfoContext(ctx, "argument marked for resumption", "argument_id", argumentID)
+}
+
+// ResumeInterruptedJudgments restarts judgments interrupted by a previous
+// shutdown. Each argument is claimed with a conditional update, so when
+// several instances boot together only one of them resumes it.
+func (p *Pipeline) ResumeInterruptedJudgments(ctx context.Context) {
+	ids, err := p.Arguments.ResumePending(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load interrupted arguments", "error", err)
+		return
+	}
+
+	for _, id := range ids {
+		claimed, err := p.Arguments.ClaimResume(ctx, id)
+		if err != nil || !claimed {
+			continue
+		}
+
+		slog.InfoContext(ctx, "resuming interrupted judgment", "argument_id", id)
+		p.RecordStage(ctx, id, "processing", StageQueued)
+		p.Enqueue(ctx, id)
+	}
+}
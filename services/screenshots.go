@@ -0,0 +1,478 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// Screenshot is one image of a chat, uploaded as is or taken from a screen
+// recording. Width and Height are set once it has been prepared, and stay 0
+// for images that can't be decoded here.
+type Screenshot struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+
+	Width  int
+	Height int
+}
+
+// ReadScreenshots loads uploaded images in upload order. The content type
+// is sniffed from the data; clients label screenshots however they like.
+func ReadScreenshots(files []*multipart.FileHeader) ([]Screenshot, error) {
+	screenshots := make([]Screenshot, 0, len(files))
+
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		screenshots = append(screenshots, Screenshot{
+			Filename:    fileHeader.Filename,
+			ContentType: http.DetectContentType(data),
+			Data:        data,
+		})
+	}
+
+	return screenshots, nil
+}
+
+// The vision model scales images to fit within maxImageSide and then until
+// the short side is at most maxImageShortSide before tiling them; sending
+// more pixels than that only makes the request bigger
+const (
+	maxImageSide      = 2048
+	maxImageShortSide = 768
+	// Images that fit in a single low detail tile are sent at low detail,
+	// which costs a fraction of the tokens and loses nothing
+	lowDetailSide = 512
+	// Resized screenshots are sent as JPEG; text stays sharp at this quality
+	screenshotJPEGQuality = 90
+	// Decoding takes 4 bytes a pixel, twice over while flattening, so
+	// images are measured before they are decoded. A long scrolling
+	// screenshot of a chat is well under this.
+	maxImagePixels = 25_000_000
+)
+
+const (
+	// Thumbnails for comparing screenshots are this wide, whatever the
+	// size of the original
+	thumbnailWidth = 240
+	// Screenshots whose difference hashes differ in at most this many of the
+	// 64 bits may be the same screen; re-encoding and rescaling flip a few
+	maxDuplicateHashDistance = 6
+	// and they are the same screen when no more than this much of one has
+	// scrolled out of view in the other
+	maxDuplicateNovelContent = 0.02
+)
+
+// preparedImage is a screenshot with what is known about its content
+type preparedImage struct {
+	Screenshot
+	// index in upload order
+	index int
+	// taken is parsed from the file name; zero when it has no timestamp
+	taken   time.Time
+	hash    uint64
+	profile rowProfile
+	decoded bool
+}
+
+// PrepareScreenshots gets uploaded screenshots ready for the vision model:
+// images are checked by their content, scaled down to what the model reads,
+// near duplicates are dropped and, when reorder is set, the rest ordered
+// oldest messages first. Frames of a screen recording are already in order.
+// Images that aren't PNG, JPEG, GIF or WebP return a *MediaRejection.
+func PrepareScreenshots(ctx context.Context, screenshots []Screenshot, reorder bool) (_ []Screenshot, err error) {
+	ctx, span := tracing.Start(ctx, "media.prepare_screenshots", trace.WithAttributes(
+		attribute.Int("screenshots.received", len(screenshots)),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	images := make([]*preparedImage, len(screenshots))
+
+	group := new(errgroup.Group)
+	group.SetLimit(runtime.GOMAXPROCS(0))
+	for i, screenshot := range screenshots {
+		group.Go(func() error {
+			shot, err := prepareImage(screenshot)
+			if err != nil {
+				return err
+			}
+			shot.index = i
+			images[i] = shot
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	resized := 0
+	for i, shot := range images {
+		if shot.Width > 0 && len(shot.Data) != len(screenshots[i].Data) {
+			resized++
+		}
+	}
+
+	images = dropDuplicates(images)
+	reordered := reorder && orderScreenshots(images)
+
+	prepared := make([]Screenshot, len(images))
+	for i, shot := range images {
+		prepared[i] = shot.Screenshot
+	}
+
+	span.SetAttributes(
+		attribute.Int("screenshots.kept", len(prepared)),
+		attribute.Int("screenshots.resized", resized),
+		attribute.Bool("screenshots.reordered", reordered),
+	)
+	slog.InfoContext(ctx, "screenshots prepared",
+		"received", len(screenshots),
+		"kept", len(prepared),
+		"resized", resized,
+		"reordered", reordered,
+	)
+
+	return prepared, nil
+}
+
+func prepareImage(screenshot Screenshot) (*preparedImage, error) {
+	prepared := &preparedImage{Screenshot: screenshot, taken: screenshotTime(screenshot.Filename)}
+	prepared.ContentType = http.DetectContentType(screenshot.Data)
+
+	switch prepared.ContentType {
+	case "image/png", "image/jpeg", "image/gif":
+	case "image/webp":
+		// The model reads WebP but the standard library can't; it is sent
+		// as is and kept out of deduplication and ordering
+		return prepared, nil
+	default:
+		return nil, &MediaRejection{
+			Reason: ErrUnsupportedMedia,
+			Detail: fmt.Sprintf("%s is %s, not a PNG, JPEG, GIF or WebP image", screenshot.Filename, prepared.ContentType),
+		}
+	}
+
+	size, _, err := image.DecodeConfig(bytes.NewReader(screenshot.Data))
+	if err != nil {
+		return nil, &MediaRejection{
+			Reason: ErrUnsupportedMedia,
+			Detail: fmt.Sprintf("%s could not be read as an image", screenshot.Filename),
+		}
+	}
+	if size.Width*size.Height > maxImagePixels {
+		return nil, &MediaRejection{
+			Reason: ErrUnsupportedMedia,
+			Detail: fmt.Sprintf("%s is %dx%d, more than the %d megapixel limit", screenshot.Filename, size.Width, size.Height, maxImagePixels/1_000_000),
+		}
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(screenshot.Data))
+	if err != nil {
+		return nil, &MediaRejection{
+			Reason: ErrUnsupportedMedia,
+			Detail: fmt.Sprintf("%s could not be read as an image", screenshot.Filename),
+		}
+	}
+
+	width, height := providerSize(decoded.Bounds().Dx(), decoded.Bounds().Dy())
+	img := downscale(flatten(decoded), width, height)
+
+	// Animated GIFs aren't accepted, so GIFs are always re-encoded from
+	// their first frame
+	if width != decoded.Bounds().Dx() || height != decoded.Bounds().Dy() || prepared.ContentType == "image/gif" {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: screenshotJPEGQuality}); err != nil {
+			return nil, err
+		}
+		prepared.ContentType = "image/jpeg"
+		prepared.Data = buf.Bytes()
+	}
+
+	prepared.Width, prepared.Height = width, height
+
+	thumbnail := downscale(img, thumbnailWidth, max(height*thumbnailWidth/width, 1))
+	// A row of the thumbnail is several of the screenshot already
+	prepared.profile = smoothProfile(profileImage(thumbnail, 1))
+	prepared.hash = differenceHash(thumbnail)
+	prepared.decoded = true
+
+	return prepared, nil
+}
+
+// smoothProfile blurs a profile across rows, so rows straddling the edge of
+// a line of text differ less when a scroll doesn't land on a whole row
+func smoothProfile(profile rowProfile) rowProfile {
+	smoothed := make(rowProfile, len(profile))
+	for i := range profile {
+		above, below := profile[max(i-1, 0)], profile[min(i+1, len(profile)-1)]
+		for c := range smoothed[i] {
+			smoothed[i][c] = (above[c] + 2*profile[i][c] + below[c]) / 4
+		}
+	}
+	return smoothed
+}
+
+// providerSize is the size the model would scale an image to, never larger
+// than the image
+func providerSize(width, height int) (int, int) {
+	scale := math.Min(1, float64(maxImageSide)/float64(max(width, height)))
+	scale = math.Min(scale, float64(maxImageShortSide)/float64(min(width, height)))
+
+	return max(int(math.Round(float64(width)*scale)), 1), max(int(math.Round(float64(height)*scale)), 1)
+}
+
+// flatten draws img onto white, so transparent screenshots stay legible as
+// JPEG, in a form downscale can read directly
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+		return out
+	}
+
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Over)
+	return out
+}
+
+// downscale shrinks img to width by height, averaging the pixels each
+// output pixel covers. Enlarging, for thumbnails of narrow images, repeats
+// pixels.
+func downscale(img *image.RGBA, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	if bounds.Dx() == width && bounds.Dy() == height {
+		return img
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				offset := img.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(img.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := out.PixOffset(x, y)
+			for c := range sum {
+				out.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return out
+}
+
+// differenceHash is the dHash of img: whether brightness rises or falls
+// between neighbouring cells of a 9x8 grid. Similar images have hashes a
+// few bits apart.
+func differenceHash(img *image.RGBA) uint64 {
+	grid := downscale(img, 9, 8)
+
+	luma := func(x, y int) int {
+		offset := grid.PixOffset(x, y)
+		return 299*int(grid.Pix[offset]) + 587*int(grid.Pix[offset+1]) + 114*int(grid.Pix[offset+2])
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luma(x, y) < luma(x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// dropDuplicates keeps the first of each set of screenshots showing the
+// same screen. The hash tolerates re-encoding and rescaling; comparing the
+// rows keeps apart different screens of a chat that share a layout.
+func dropDuplicates(images []*preparedImage) []*preparedImage {
+	kept := images[:0:0]
+
+	for _, shot := range images {
+		duplicate := false
+		for _, other := range kept {
+			if !shot.decoded || !other.decoded || bits.OnesCount64(shot.hash^other.hash) > maxDuplicateHashDistance {
+				continue
+			}
+			if o := measureOverlap(other.profile, shot.profile); o.Found && o.Novel <= maxDuplicateNovelContent {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			kept = append(kept, shot)
+		}
+	}
+
+	return kept
+}
+
+// Screenshot file names usually say when they were taken, e.g.
+// "Screenshot 2024-05-01 at 10.22.31 PM.png" or
+// "Screenshot_20240501-222231_WhatsApp.jpg"
+var screenshotTimePattern = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})(?:[ _T-]|\s+at\s+)(\d{1,2})[.:_-]?(\d{2})[.:_-]?(\d{2})(?:\s?([AP]M))?`)
+
+func screenshotTime(filename string) time.Time {
+	match := screenshotTimePattern.FindStringSubmatch(filename)
+	if match == nil {
+		return time.Time{}
+	}
+
+	fields := make([]int, 6)
+	for i := range fields {
+		fields[i], _ = strconv.Atoi(match[i+1])
+	}
+
+	hour := fields[3]
+	switch match[7] {
+	case "AM":
+		hour %= 12
+	case "PM":
+		hour = hour%12 + 12
+	}
+
+	taken := time.Date(fields[0], time.Month(fields[1]), fields[2], hour, fields[4], fields[5], 0, time.UTC)
+	// time.Date normalizes out of range fields; those weren't timestamps
+	if taken.Month() != time.Month(fields[1]) || taken.Day() != fields[2] ||
+		taken.Hour() != hour || taken.Minute() != fields[4] || taken.Second() != fields[5] {
+		return time.Time{}
+	}
+	return taken
+}
+
+// orderScreenshots puts screenshots in the order their messages were sent.
+// File name timestamps order them when every one has one. Overlap is
+// stronger evidence: screenshots that continue one another are chained
+// together, oldest messages first, wherever the chain's first screenshot
+// landed. Reports whether the order changed.
+func orderScreenshots(images []*preparedImage) bool {
+	timed := true
+	for _, shot := range images {
+		timed = timed && !shot.taken.IsZero()
+	}
+	if timed {
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].taken.Before(images[j].taken)
+		})
+	}
+
+	type link struct {
+		from, to int
+		shift    float64
+	}
+
+	// Content moving up means the second screenshot scrolled down to newer
+	// messages
+	var links []link
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if !images[i].decoded || !images[j].decoded {
+				continue
+			}
+			o := measureOverlap(images[i].profile, images[j].profile)
+			switch {
+			case !o.Found || o.Shift == 0:
+			case o.Shift > 0:
+				links = append(links, link{from: i, to: j, shift: o.Shift})
+			default:
+				links = append(links, link{from: j, to: i, shift: -o.Shift})
+			}
+		}
+	}
+
+	// The nearest continuation wins: a screenshot two screens on overlaps
+	// less than the one in between
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].shift < links[j].shift
+	})
+
+	next := make([]int, len(images))
+	previous := make([]int, len(images))
+	for i := range images {
+		next[i], previous[i] = -1, -1
+	}
+
+	head := func(i int) int {
+		for previous[i] >= 0 {
+			i = previous[i]
+		}
+		return i
+	}
+
+	for _, l := range links {
+		if next[l.from] >= 0 || previous[l.to] >= 0 || head(l.from) == l.to {
+			continue
+		}
+		next[l.from], previous[l.to] = l.to, l.from
+	}
+
+	ordered := make([]*preparedImage, 0, len(images))
+	placed := make([]bool, len(images))
+	for i := range images {
+		if placed[i] {
+			continue
+		}
+		for at := head(i); at >= 0; at = next[at] {
+			ordered = append(ordered, images[at])
+			placed[at] = true
+		}
+	}
+
+	reordered := false
+	for i, shot := range ordered {
+		reordered = reordered || (i > 0 && shot.index < ordered[i-1].index)
+		images[i] = shot
+	}
+	return reordered
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareScreenshotsReorder(t *testing.T) {
+	// Two unrelated chats, named as taken in the opposite order to upload
+	upload := []Screenshot{
+		{Filename: "Screenshot_20240501-222231_WhatsApp.jpg", Data: encodeJPEG(t, chatScreen(chatColumn(1, 2000), 0), 90)},
+		{Filename: "Screenshot_20240501-101500_WhatsApp.jpg", Data: encodeJPEG(t, chatScreen(chatColumn(2, 2000), 0), 90)},
+	}
+
+	tests := []struct {
+		name    string
+		reorder bool
+		want    []string
+	}{
+		{name: "screenshots", reorder: true, want: []string{upload[1].Filename, upload[0].Filename}},
+		{name: "recording frames", reorder: false, want: []string{upload[0].Filename, upload[1].Filename}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prepared, err := PrepareScreenshots(context.Background(), upload, tt.reorder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prepared) != len(tt.want) {
+				t.Fatalf("kept %d screenshots, want %d", len(prepared), len(tt.want))
+			}
+			for i, shot := range prepared {
+				if shot.Filename != tt.want[i] {
+					t.Fatalf("screenshot %d is %s, want %s", i, shot.Filename, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareImageRejectsOversizedImage(t *testing.T) {
+	// A small PNG whose header claims 20000x20000: decoding it would
+	// allocate 1.6GB before finding the data is missing
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 20000)
+	binary.BigEndian.PutUint32(data[20:], 20000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := prepareImage(Screenshot{Filename: "bomb.png", Data: data})
+
+	var rejection *MediaRejection
+	if !errors.As(err, &rejection) || !errors.Is(err, ErrUnsupportedMedia) {
+		t.Fatalf("prepareImage() = %v, want an unsupported media rejection", err)
+	}
+	if !strings.Contains(rejection.Detail, "20000x20000") {
+		t.Fatalf("detail = %q", rejection.Detail)
+	}
+}
+
+func preparedScreen(t *testing.T, name string, img image.Image, quality int) *preparedImage {
+	t.Helper()
+
+	shot, err := prepareImage(Screenshot{Filename: name, Data: encodeJPEG(t, img, quality)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shot
+}
+
+func TestMeasureOverlap(t *testing.T) {
+	chat := chatColumn(1, 2000)
+	profile := func(offset int) rowProfile {
+		img, _, err := image.Decode(bytes.NewReader(encodeJPEG(t, chatScreen(chat, offset), 90)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return profileImage(img, profileRowHeight)
+	}
+	other := profileImage(chatScreen(chatColumn(3, 2000), 0), profileRowHeight)
+
+	tests := []struct {
+		name string
+		a, b rowProfile
+		want overlap
+	}{
+		{name: "same screen", a: profile(0), b: profile(0), want: overlap{Found: true}},
+		// 80 of the 240 scrolling pixels, 20 rows of 4
+		{name: "scrolled down", a: profile(0), b: profile(80), want: overlap{Found: true, Shift: 20, Novel: 1.0 / 3}},
+		{name: "scrolled up", a: profile(80), b: profile(0), want: overlap{Found: true, Shift: -20, Novel: 1.0 / 3}},
+		// Half a row past a whole one; the half step has to win
+		{name: "scrolled down half a row more", a: profile(0), b: profile(90), want: overlap{Found: true, Shift: 22.5, Novel: 22.5 / 60}},
+		{name: "scrolled up half a row more", a: profile(90), b: profile(0), want: overlap{Found: true, Shift: -22.5, Novel: 22.5 / 60}},
+		{name: "scrolled past", a: profile(0), b: profile(400), want: overlap{Novel: 1}},
+		{name: "another chat", a: profile(0), b: other, want: overlap{Novel: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := measureOverlap(tt.a, tt.b)
+			if got.Found != tt.want.Found || got.Shift != tt.want.Shift || math.Abs(got.Novel-tt.want.Novel) > 1e-9 {
+				t.Fatalf("measureOverlap() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropDuplicates(t *testing.T) {
+	chat := chatColumn(1, 2000)
+
+	// Same layout, lighter bubbles: the difference hash can't tell it apart
+	lighter := make([]uint8, len(chat))
+	for i, shade := range chat {
+		lighter[i] = shade + 12
+	}
+
+	tests := []struct {
+		name  string
+		other *preparedImage
+		kept  bool
+	}{
+		{name: "re-encoded", other: preparedScreen(t, "b.jpg", chatScreen(chat, 0), 60)},
+		{name: "scrolled a row", other: preparedScreen(t, "b.jpg", chatScreen(chat, 4), 90)},
+		{name: "scrolled a few lines", other: preparedScreen(t, "b.jpg", chatScreen(chat, 40), 90), kept: true},
+		{name: "another chat with the same layout", other: preparedScreen(t, "b.jpg", chatScreen(chatColumn(3, 2000), 0), 90), kept: true},
+		{name: "same layout, other messages", other: preparedScreen(t, "b.jpg", chatScreen(lighter, 0), 90), kept: true},
+		{name: "not decoded", other: &preparedImage{Screenshot: Screenshot{Filename: "b.webp"}}, kept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := preparedScreen(t, "a.jpg", chatScreen(chat, 0), 90)
+
+			got := dropDuplicates([]*preparedImage{first, tt.other})
+			want := 1
+			if tt.kept {
+				want = 2
+			}
+			if len(got) != want || got[0] != first {
+				t.Fatalf("kept %d screenshots, want %d with the first one first", len(got), want)
+			}
+		})
+	}
+}
+
+func TestScreenshotTime(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     time.Time
+	}{
+		{"Screenshot 2024-05-01 at 10.22.31 PM.png", time.Date(2024, 5, 1, 22, 22, 31, 0, time.UTC)},
+		{"Screenshot 2024-05-01 at 9.05.09 AM.png", time.Date(2024, 5, 1, 9, 5, 9, 0, time.UTC)},
+		{"Screenshot 2024-05-01 at 12.05.09 AM.png", time.Date(2024, 5, 1, 0, 5, 9, 0, time.UTC)},
+		{"Screenshot 2024-05-01 at 12.05.09 PM.png", time.Date(2024, 5, 1, 12, 5, 9, 0, time.UTC)},
+		{"Screenshot 2024-05-01 at 12.05.09 PM.png", time.Date(2024, 5, 1, 12, 5, 9, 0, time.UTC)},
+		{"Screenshot_20240501-222231_WhatsApp.jpg", time.Date(2024, 5, 1, 22, 22, 31, 0, time.UTC)},
+		{"Screenshot_20240501-002231_Messages.jpg", time.Date(2024, 5, 1, 0, 22, 31, 0, time.UTC)},
+		{"Screenshot 2024-05-01 222231.png", time.Date(2024, 5, 1, 22, 22, 31, 0, time.UTC)},
+		{"IMG_4821.PNG", time.Time{}},
+		{"Screenshot_20241301-101010.jpg", time.Time{}},
+		{"Screenshot_20240230-101010.jpg", time.Time{}},
+		{"Screenshot_20240501-251010.jpg", time.Time{}},
+		{"Screenshot_20240501-106010.jpg", time.Time{}},
+		{"Screenshot_20240501-103060.jpg", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		if got := screenshotTime(tt.filename); !got.Equal(tt.want) {
+			t.Errorf("screenshotTime(%q) = %v, want %v", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestOrderScreenshots(t *testing.T) {
+	chat := chatColumn(1, 2000)
+	screen := func(name string, offset int) *preparedImage {
+		return preparedScreen(t, name, chatScreen(chat, offset), 90)
+	}
+	unrelated := func(name string, seed uint64) *preparedImage {
+		return preparedScreen(t, name, chatScreen(chatColumn(seed, 2000), 0), 90)
+	}
+
+	tests := []struct {
+		name      string
+		images    []*preparedImage
+		want      []string
+		reordered bool
+	}{
+		{
+			name:   "already in order",
+			images: []*preparedImage{screen("0", 0), screen("160", 160), screen("320", 320)},
+			want:   []string{"0", "160", "320"},
+		},
+		{
+			name:      "overlap chains screens",
+			images:    []*preparedImage{screen("320", 320), screen("0", 0), screen("160", 160)},
+			want:      []string{"0", "160", "320"},
+			reordered: true,
+		},
+		{
+			name: "file name times order unrelated screens",
+			images: []*preparedImage{
+				unrelated("Screenshot 2024-05-01 at 1.00.00 PM.png", 2),
+				unrelated("Screenshot 2024-05-01 at 12.30.00 AM.png", 3),
+				unrelated("Screenshot 2024-05-01 at 12.30.00 PM.png", 4),
+			},
+			want: []string{
+				"Screenshot 2024-05-01 at 12.30.00 AM.png",
+				"Screenshot 2024-05-01 at 12.30.00 PM.png",
+				"Screenshot 2024-05-01 at 1.00.00 PM.png",
+			},
+			reordered: true,
+		},
+		{
+			// Names disagree with the content; the scroll wins
+			name: "overlap beats file name times",
+			images: []*preparedImage{
+				preparedScreen(t, "Screenshot_20240501-100000.jpg", chatScreen(chat, 160), 90),
+				preparedScreen(t, "Screenshot_20240501-110000.jpg", chatScreen(chat, 0), 90),
+			},
+			want:      []string{"Screenshot_20240501-110000.jpg", "Screenshot_20240501-100000.jpg"},
+			reordered: true,
+		},
+		{
+			name:   "one screen without a time keeps upload order",
+			images: []*preparedImage{unrelated("Screenshot_20240501-110000.jpg", 2), unrelated("IMG_4821.PNG", 3)},
+			want:   []string{"Screenshot_20240501-110000.jpg", "IMG_4821.PNG"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, shot := range tt.images {
+				shot.index = i
+			}
+
+			reordered := orderScreenshots(tt.images)
+
+			got := make([]string, len(tt.images))
+			for i, shot := range tt.images {
+				got[i] = shot.Filename
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || reordered != tt.reordered {
+				t.Fatalf("order = %v, reordered %v; want %v, reordered %v", got, reordered, tt.want, tt.reordered)
+			}
+		})
+	}
+}
@@ -2,13 +2,22 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/calebchiang/thirdparty_server/metrics"
+	"github.com/calebchiang/thirdparty_server/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TranscriptionSegment struct {
@@ -16,6 +25,8 @@ type TranscriptionSegment struct {
 	Start float64 `json:"start"`
 	End   float64 `json:"end"`
 	Text  string  `json:"text"`
+	// Speaker is set when channels were transcribed separately
+	Speaker string `json:"speaker,omitempty"`
 }
 
 type TranscriptionResult struct {
@@ -25,9 +36,77 @@ type TranscriptionResult struct {
 	Segments []TranscriptionSegment `json:"segments,omitempty"`
 }
 
-func GenerateTranscriptFromPath(filePath string) (*TranscriptionResult, error) {
+// Transcriber turns a normalized recording into timed text
+type Transcriber interface {
+	Transcribe(ctx context.Context, path string) (*TranscriptionResult, error)
+}
+
+// NewTranscriber builds the backend named by TRANSCRIPTION_BACKEND
+func NewTranscriber(cfg *config.Config) (Transcriber, error) {
+	var backend Transcriber
+
+	switch cfg.TranscriptionBackend {
+	case "openai":
+		backend = &OpenAITranscriber{Model: cfg.TranscriptionModel}
+	case "whisper_cpp":
+		backend = &WhisperCppTranscriber{Command: cfg.WhisperCommand, Model: cfg.WhisperModel, Threads: cfg.WhisperThreads}
+	case "faster_whisper":
+		backend = &FasterWhisperTranscriber{Command: cfg.WhisperCommand, Model: cfg.WhisperModel}
+	case "fixture":
+		backend = &FixtureTranscriber{Dir: cfg.TranscriptionFixtureDir}
+	default:
+		return nil, fmt.Errorf("unknown transcription backend %q", cfg.TranscriptionBackend)
+	}
+
+	return &instrumentedTranscriber{name: cfg.TranscriptionBackend, next: backend}, nil
+}
+
+// instrumentedTranscriber gives every backend the same span, metrics and log
+type instrumentedTranscriber struct {
+	name string
+	next Transcriber
+}
+
+func (t *instrumentedTranscriber) Transcribe(ctx context.Context, path string) (result *TranscriptionResult, err error) {
+
+	ctx, span := tracing.Start(ctx, "transcribe", trace.WithAttributes(
+		attribute.String("transcription.backend", t.name),
+	))
+
+	start := time.Now()
+	defer func() {
+		metrics.ObserveStage(metrics.StageTranscribe, start, err, failureReason(err))
+		if result != nil {
+			span.SetAttributes(attribute.Float64("audio.duration_seconds", result.Duration))
+		}
+		tracing.End(span, err)
+	}()
+
+	result, err = t.next.Transcribe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "transcription complete",
+		"backend", t.name,
+		"audio_seconds", result.Duration,
+		"segments", len(result.Segments),
+		"elapsed_ms", time.Since(start).Milliseconds(),
+	)
+
+	return result, nil
+}
+
+// OpenAITranscriber calls the Whisper API, or a compatible server at
+// OPENAI_BASE_URL
+type OpenAITranscriber struct {
+	Model string
+}
+
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("llm.model", t.Model))
 
-	file, err := os.Open(filePath)
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +116,7 @@ func GenerateTranscriptFromPath(filePath string) (*TranscriptionResult, error) {
 	writer := multipart.NewWriter(&requestBody)
 
 	// Required model field
-	if err := writer.WriteField("model", "whisper-1"); err != nil {
+	if err := writer.WriteField("model", t.Model); err != nil {
 		return nil, err
 	}
 
@@ -50,7 +129,7 @@ func GenerateTranscriptFromPath(filePath string) (*TranscriptionResult, error) {
 		return nil, err
 	}
 
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	part, err := writer.CreateFormFile("file", filepath.Base(path))
 	if err != nil {
 		return nil, err
 	}
@@ -61,20 +140,20 @@ func GenerateTranscriptFromPath(filePath string) (*TranscriptionResult, error) {
 
 	writer.Close()
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
-		"https://api.openai.com/v1/audio/transcriptions",
+		openAIBaseURL()+"/audio/transcriptions",
 		&requestBody,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	req.Header.Set("Authorization", "Bearer "+config.App.OpenAIAPIKey)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openAIHTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -82,13 +161,13 @@ func GenerateTranscriptFromPath(filePath string) (*TranscriptionResult, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenAI error: %s", string(body))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	var result TranscriptionResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	result := &TranscriptionResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	return result, nil
 }
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/models"
+)
+
+const DefaultTrendWindow = 5
+
+type ScoreAverages struct {
+	ConversationHealthScore float64 `json:"conversation_health_score"`
+	Respect                 float64 `json:"respect"`
+	Empathy                 float64 `json:"empathy"`
+	Accountability          float64 `json:"accountability"`
+	EmotionalRegulation     float64 `json:"emotional_regulation"`
+	ManipulationToxicity    float64 `json:"manipulation_toxicity"`
+}
+
+type TrendPoint struct {
+	ArgumentID              uint          `json:"argument_id"`
+	CreatedAt               time.Time     `json:"created_at"`
+	Winner                  string        `json:"winner"`
+	ConversationHealthScore int           `json:"conversation_health_score"`
+	Respect                 int           `json:"respect"`
+	Empathy                 int           `json:"empathy"`
+	Accountability          int           `json:"accountability"`
+	EmotionalRegulation     int           `json:"emotional_regulation"`
+	ManipulationToxicity    int           `json:"manipulation_toxicity"`
+	Rolling                 ScoreAverages `json:"rolling"`
+}
+
+type WinLossRecord struct {
+	PersonAWins int `json:"person_a_wins"`
+	PersonBWins int `json:"person_b_wins"`
+	Ties        int `json:"ties"`
+	Total       int `json:"total"`
+}
+
+type Weakness struct {
+	Category     string  `json:"category"`
+	Count        int     `json:"count"`
+	AverageScore float64 `json:"average_score"`
+}
+
+type RelationshipTrends struct {
+	RelationshipID uint          `json:"relationship_id"`
+	PersonAName    string        `json:"person_a_name"`
+	PersonBName    string        `json:"person_b_name"`
+	Window         int           `json:"window"`
+	Record         WinLossRecord `json:"record"`
+	Averages       ScoreAverages `json:"averages"`
+	Weaknesses     []Weakness    `json:"weaknesses"`
+	Points         []TrendPoint  `json:"points"`
+}
+
+// BuildRelationshipTrends aggregates the judged arguments of a relationship
+// into a timeline. Arguments without a judgment (still processing or failed)
+// are skipped.
+func BuildRelationshipTrends(relationship models.Relationship, arguments []models.Argument, window int) *RelationshipTrends {
+
+	if window <= 0 {
+		window = DefaultTrendWindow
+	}
+
+	judged := make([]models.Argument, 0, len(arguments))
+	for _, argument := range arguments {
+		if argument.Judgment != nil {
+			judged = append(judged, argument)
+		}
+	}
+
+	sort.SliceStable(judged, func(i, j int) bool {
+		return judged[i].CreatedAt.Before(judged[j].CreatedAt)
+	})
+
+	trends := &RelationshipTrends{
+		RelationshipID: relationship.ID,
+		PersonAName:    relationship.PersonAName,
+		PersonBName:    relationship.PersonBName,
+		Window:         window,
+		Weaknesses:     []Weakness{},
+		Points:         make([]TrendPoint, 0, len(judged)),
+	}
+
+	weaknessCounts := map[string]int{}
+	scoreTotals := map[string]int{}
+
+	for i, argument := range judged {
+		j := argument.Judgment
+
+		switch j.Winner {
+		case "person_a":
+			trends.Record.PersonAWins++
+		case "person_b":
+			trends.Record.PersonBWins++
+		case "tie":
+			trends.Record.Ties++
+		}
+		trends.Record.Total++
+
+		scores := subScores(j)
+		lowest, tied := "", false
+		lowestValue := 11
+		for _, s := range scores {
+			scoreTotals[s.category] += s.value
+			switch {
+			case s.value < lowestValue:
+				lowest, lowestValue, tied = s.category, s.value, false
+			case s.value == lowestValue:
+				tied = true
+			}
+		}
+
+		// A category counts as a weakness when it is strictly the lowest
+		// sub-score of a judgment. When several share the lowest score,
+		// none of them stands out, e.g. a judgment of all 10s.
+		if !tied {
+			weaknessCounts[lowest]++
+		}
+
+		start := i - window + 1
+		if start < 0 {
+			start = 0
+		}
+
+		trends.Points = append(trends.Points, TrendPoint{
+			ArgumentID:              argument.ID,
+			CreatedAt:               argument.CreatedAt,
+			Winner:                  j.Winner,
+			ConversationHealthScore: j.ConversationHealthScore,
+			Respect:                 j.Respect,
+			Empathy:                 j.Empathy,
+			Accountability:          j.Accountability,
+			EmotionalRegulation:     j.EmotionalRegulation,
+			ManipulationToxicity:    j.ManipulationToxicity,
+			Rolling:                 averageScores(judged[start : i+1]),
+		})
+	}
+
+	trends.Averages = averageScores(judged)
+
+	for category, count := range weaknessCounts {
+		trends.Weaknesses = append(trends.Weaknesses, Weakness{
+			Category:     category,
+			Count:        count,
+			AverageScore: float64(scoreTotals[category]) / float64(len(judged)),
+		})
+	}
+
+	sort.Slice(trends.Weaknesses, func(i, j int) bool {
+		a, b := trends.Weaknesses[i], trends.Weaknesses[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.AverageScore != b.AverageScore {
+			return a.AverageScore < b.AverageScore
+		}
+		return a.Category < b.Category
+	})
+
+	return trends
+}
+
+type categoryScore struct {
+	category string
+	value    int
+}
+
+func subScores(j *models.Judgment) []categoryScore {
+	return []categoryScore{
+		{"respect", j.Respect},
+		{"empathy", j.Empathy},
+		{"accountability", j.Accountability},
+		{"emotional_regulation", j.EmotionalRegulation},
+		{"manipulation_toxicity", j.ManipulationToxicity},
+	}
+}
+
+func averageScores(arguments []models.Argument) ScoreAverages {
+	var avg ScoreAverages
+	if len(arguments) == 0 {
+		return avg
+	}
+
+	for _, argument := range arguments {
+		j := argument.Judgment
+		avg.ConversationHealthScore += float64(j.ConversationHealthScore)
+		avg.Respect += float64(j.Respect)
+		avg.Empathy += float64(j.Empathy)
+		avg.Accountability += float64(j.Accountability)
+		avg.EmotionalRegulation += float64(j.EmotionalRegulation)
+		avg.ManipulationToxicity += float64(j.ManipulationToxicity)
+	}
+
+	n := float64(len(arguments))
+	avg.ConversationHealthScore /= n
+	avg.Respect /= n
+	avg.Empathy /= n
+	avg.Accountability /= n
+	avg.EmotionalRegulation /= n
+	avg.ManipulationToxicity /= n
+
+	return avg
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadLocked         = errors.New("upload is being written")
+)
+
+// Upload is a resumable upload. Its bytes so far live in a data file whose
+// size is the offset, so a crash mid-write never loses track of progress.
+type Upload struct {
+	ID     string `json:"id"`
+	UserID uint   `json:"user_id"`
+	Length int64  `json:"length"`
+	// Metadata is the client's Upload-Metadata header, returned as sent
+	Metadata  string    `json:"metadata,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Offset    int64     `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// MetadataValue decodes one Upload-Metadata entry, such as "filename"
+func (u *Upload) MetadataValue(key string) string {
+	for _, pair := range strings.Split(u.Metadata, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if name != key {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ""
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+// UploadStore keeps resumable uploads on local disk. An upload expires once
+// it has gone ttl without receiving data.
+type UploadStore struct {
+	dir string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	writing map[string]bool
+}
+
+func NewUploadStore(dir string, ttl time.Duration) *UploadStore {
+	return &UploadStore{
+		dir:     dir,
+		ttl:     ttl,
+		writing: map[string]bool{},
+	}
+}
+
+func (s *UploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// Path is the data file of the upload
+func (s *UploadStore) Path(upload *Upload) string {
+	return filepath.Join(s.dir, upload.ID+".bin")
+}
+
+func (s *UploadStore) Create(userID uint, length int64, metadata string) (*Upload, error) {
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.Create(s.Path(upload))
+	if err != nil {
+		return nil, err
+	}
+	_ = data.Close()
+
+	if err := os.WriteFile(s.infoPath(upload.ID), info, 0o600); err != nil {
+		_ = os.Remove(s.Path(upload))
+		return nil, err
+	}
+
+	upload.ExpiresAt = upload.CreatedAt.Add(s.ttl)
+	return upload, nil
+}
+
+// Get loads the user's upload. Uploads of other users and expired ones are
+// reported as not found.
+func (s *UploadStore) Get(id string, userID uint) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	contents, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{}
+	if err := json.Unmarshal(contents, upload); err != nil {
+		return nil, fmt.Errorf("parsing upload %s: %w", id, err)
+	}
+
+	if upload.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+
+	stat, err := os.Stat(s.Path(upload))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload.Offset = stat.Size()
+	upload.ExpiresAt = stat.ModTime().Add(s.ttl)
+
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// Append writes body to the end of the upload, which must currently be
+// offset bytes long. Bytes past the declared length are never written. When
+// the body breaks off, what arrived is kept and the returned upload holds
+// the new offset, so the client can resume from there.
+func (s *UploadStore) Append(ctx context.Context, id string, userID uint, offset int64, body io.Reader) (*Upload, error) {
+	if !s.lock(id) {
+		return nil, ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != upload.Offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+
+	data, err := os.OpenFile(s.Path(upload), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	written, copyErr := io.Copy(data, io.LimitReader(body, upload.Length-upload.Offset))
+	closeErr := data.Close()
+
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().Add(s.ttl)
+
+	if copyErr != nil {
+		slog.InfoContext(ctx, "upload interrupted",
+			"upload_id", id,
+			"offset", upload.Offset,
+			"length", upload.Length,
+			"error", copyErr,
+		)
+		return upload, copyErr
+	}
+
+	return upload, closeErr
+}
+
+// Claim loads the user's upload and holds it until release is called, so
+// two requests can't both turn the same upload into an argument. A claimed
+// upload is not written to or swept.
+func (s *UploadStore) Claim(id string, userID uint) (*Upload, func(), error) {
+	if !s.lock(id) {
+		return nil, nil, ErrUploadLocked
+	}
+
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		s.unlock(id)
+		return nil, nil, err
+	}
+
+	return upload, func() { s.unlock(id) }, nil
+}
+
+func (s *UploadStore) Delete(id string, userID uint) error {
+	upload, err := s.Get(id, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.remove(upload.ID)
+}
+
+func (s *UploadStore) remove(id string) error {
+	err := errors.Join(
+		os.Remove(filepath.Join(s.dir, id+".bin")),
+		os.Remove(s.infoPath(id)),
+	)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Sweep deletes uploads that have expired and returns how many it removed
+func (s *UploadStore) Sweep() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			continue
+		}
+
+		// The data file is touched by every write; without one the
+		// upload is already half removed
+		modified := time.Time{}
+		if stat, err := os.Stat(filepath.Join(s.dir, id+".bin")); err == nil {
+			modified = stat.ModTime()
+		}
+
+		if time.Since(modified) < s.ttl || s.busy(id) {
+			continue
+		}
+
+		if err := s.remove(id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// RunSweeper periodically removes expired uploads until ctx is done
+func (s *UploadStore) RunSweeper(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := s.Sweep()
+			if err != nil {
+				slog.Warn("sweeping uploads failed", "error", err)
+			}
+			if removed > 0 {
+				slog.Info("expired uploads removed", "count", removed)
+			}
+		}
+	}
+}
+
+// One write per upload at a time. A client that times out and retries
+// while its first request is still being read gets ErrUploadLocked rather
+// than interleaving bytes.
+func (s *UploadStore) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writing[id] {
+		return false
+	}
+	s.writing[id] = true
+	return true
+}
+
+func (s *UploadStore) unlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.writing, id)
+}
+
+func (s *UploadStore) busy(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writing[id]
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadStoreClaim(t *testing.T) {
+	store := NewUploadStore(t.TempDir(), time.Hour)
+
+	upload, err := store.Create(7, 4, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(context.Background(), upload.ID, 7, 0, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := store.Claim(upload.ID, 8); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("claim by another user = %v, want ErrUploadNotFound", err)
+	}
+
+	claimed, release, err := store.Claim(upload.ID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed.Complete() {
+		t.Fatalf("claimed upload is at %d of %d", claimed.Offset, claimed.Length)
+	}
+
+	// A second request for the same upload is turned away while the first
+	// one holds it
+	if _, _, err := store.Claim(upload.ID, 7); !errors.Is(err, ErrUploadLocked) {
+		t.Fatalf("second claim = %v, want ErrUploadLocked", err)
+	}
+	if _, err := store.Append(context.Background(), upload.ID, 7, 4, strings.NewReader("")); !errors.Is(err, ErrUploadLocked) {
+		t.Fatalf("append while claimed = %v, want ErrUploadLocked", err)
+	}
+
+	// The first request fails before creating an argument; a retry can
+	// claim the upload again
+	release()
+	_, release, err = store.Claim(upload.ID, 7)
+	if err != nil {
+		t.Fatalf("claim after release = %v", err)
+	}
+
+	// It succeeds and removes the upload, so nothing is left to claim
+	if err := store.Delete(upload.ID, 7); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, _, err := store.Claim(upload.ID, 7); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("claim after delete = %v, want ErrUploadNotFound", err)
+	}
+}
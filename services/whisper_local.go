@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/config"
+	"github.com/google/uuid"
+)
+
+// Local transcription keeps recordings on our own machines. The models run
+// as subprocesses so a crash or leak in them can't take the server down.
+
+// WhisperCppTranscriber runs whisper.cpp's CLI against a ggml model file
+type WhisperCppTranscriber struct {
+	// Command is the whisper.cpp binary, "whisper-cli" when empty
+	Command string
+	// Model is the path of the ggml model, e.g. ggml-base.en.bin
+	Model   string
+	Threads int
+}
+
+type whisperCppOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets struct {
+			From int `json:"from"`
+			To   int `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func (t *WhisperCppTranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	workDir, err := os.MkdirTemp(filepath.Dir(path), "whisper_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// whisper.cpp only reads 16kHz WAV
+	wavPath := filepath.Join(workDir, "input.wav")
+	if err := convertToWav(ctx, path, wavPath); err != nil {
+		return nil, err
+	}
+
+	command := t.Command
+	if command == "" {
+		command = "whisper-cli"
+	}
+
+	outputPrefix := filepath.Join(workDir, "transcript")
+	args := []string{
+		"-m", t.Model,
+		"-f", wavPath,
+		"-l", "auto",
+		"-oj",
+		"-of", outputPrefix,
+		"-np",
+	}
+	if t.Threads > 0 {
+		args = append(args, "-t", strconv.Itoa(t.Threads))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.WhisperTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("whisper.cpp error: %w, output: %s", err, lastLines(string(output), 5))
+	}
+
+	contents, err := os.ReadFile(outputPrefix + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var output whisperCppOutput
+	if err := json.Unmarshal(contents, &output); err != nil {
+		return nil, fmt.Errorf("parsing whisper.cpp output: %w", err)
+	}
+
+	result := &TranscriptionResult{Language: output.Result.Language}
+	texts := make([]string, 0, len(output.Transcription))
+
+	for i, entry := range output.Transcription {
+		text := strings.TrimSpace(entry.Text)
+		result.Segments = append(result.Segments, TranscriptionSegment{
+			ID:    i,
+			Start: float64(entry.Offsets.From) / 1000,
+			End:   float64(entry.Offsets.To) / 1000,
+			Text:  text,
+		})
+		texts = append(texts, text)
+	}
+
+	result.Text = strings.Join(texts, " ")
+	if n := len(result.Segments); n > 0 {
+		result.Duration = result.Segments[n-1].End
+	}
+
+	return result, nil
+}
+
+// FasterWhisperTranscriber runs faster-whisper through whisper-ctranslate2,
+// whose CLI and JSON output follow openai-whisper's
+type FasterWhisperTranscriber struct {
+	// Command is the CLI, "whisper-ctranslate2" when empty
+	Command string
+	// Model is a model size such as "small" or a converted model directory
+	Model string
+}
+
+func (t *FasterWhisperTranscriber) Transcribe(ctx context.Context, path string) (*TranscriptionResult, error) {
+	workDir, err := os.MkdirTemp(filepath.Dir(path), "whisper_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	command := t.Command
+	if command == "" {
+		command = "whisper-ctranslate2"
+	}
+
+	// Output is named after the input, so give it a predictable name
+	inputPath := filepath.Join(workDir, uuid.New().String()+filepath.Ext(path))
+	if err := os.Symlink(path, inputPath); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.WhisperTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command,
+		inputPath,
+		"--model", t.Model,
+		"--output_format", "json",
+		"--output_dir", workDir,
+		"--verbose", "False",
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("faster-whisper error: %w, output: %s", err, lastLines(string(output), 5))
+	}
+
+	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".json"
+	contents, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TranscriptionResult{}
+	if err := json.Unmarshal(contents, result); err != nil {
+		return nil, fmt.Errorf("parsing faster-whisper output: %w", err)
+	}
+
+	result.Text = strings.TrimSpace(result.Text)
+	if n := len(result.Segments); n > 0 && result.Duration == 0 {
+		result.Duration = result.Segments[n-1].End
+	}
+
+	return result, nil
+}
+
+// convertToWav writes the 16kHz mono WAV whisper.cpp reads
+func convertToWav(ctx context.Context, path string, wavPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.App.FFmpegTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", path, "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le", wavPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg error: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// lastLines keeps error output short; the models print progress at length
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
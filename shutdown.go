@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/calebchiang/thirdparty_server/services"
+)
+
+// Time given to cancelled judgments to record that they were interrupted
+const interruptGrace = 5 * time.Second
+
+// drain stops the server in phases: fail readiness and close event streams,
+// stop accepting connections and finish in-flight requests, then wait for
+// background judgments. Everything shares one deadline; work still running
+// when it passes is cancelled and marked for resumption.
+func drain(srv *http.Server, pipeline *services.Pipeline, cancelRequests context.CancelFunc, timeout time.Duration) {
+	slog.Info("shutdown started",
+		"timeout", timeout.String(),
+		"jobs_inflight", pipeline.Jobs.ActiveCount(),
+	)
+
+	pipeline.Jobs.StartDraining()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("requests still running at shutdown deadline", "error", err)
+	}
+
+	finished := pipeline.Jobs.Wait(ctx)
+
+	// From here on new jobs are marked for resumption instead of started
+	interrupted := pipeline.Jobs.Interrupt()
+	cancelRequests()
+
+	if !finished {
+		slog.Warn("interrupting unfinished judgments", "argument_ids", interrupted)
+
+		grace, cancelGrace := context.WithTimeout(context.Background(), interruptGrace)
+		defer cancelGrace()
+
+		if !pipeline.Jobs.Wait(grace) {
+			// Jobs that ignored cancellation are marked on their behalf
+			for _, id := range interrupted {
+				pipeline.MarkForResumption(context.Background(), id)
+			}
+		}
+	}
+
+	_ = srv.Close()
+
+	slog.Info("shutdown complete")
+}